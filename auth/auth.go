@@ -1,10 +1,7 @@
 package auth
 
 import (
-	"encoding/json"
 	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
-	"github.com/hwsc-org/hwsc-lib/consts"
-	"strings"
 )
 
 // Authority ensures the identification is authorized.
@@ -14,51 +11,33 @@ type Authority struct {
 	body               *Body
 	tokenRequired      TokenType
 	permissionRequired Permission
+	options            []VerifierOption
 }
 
 // NewAuthority makes an authority for a service with the required token and permission level.
 // The authority defaults to NoPermission if unknown permission level is used.
 // Returns an authority with the embedded required token and permission level.
-func NewAuthority(tokenRequired TokenType, permissionRequired Permission) Authority {
-	permission := NoPermission
-	if permissionRequired > NoPermission && permissionRequired <= Admin {
-		permission = permissionRequired
-	}
-	token := NoType
-	if tokenRequired > NoType && tokenRequired <= Jwt {
-		token = tokenRequired
-	}
+// The options configure the underlying Verifier, ie: to require an issuer and audience.
+// An authority keeps the state of the last authorization, use a Verifier to share it between goroutines.
+func NewAuthority(tokenRequired TokenType, permissionRequired Permission, opts ...VerifierOption) Authority {
+	v := NewVerifier(tokenRequired, permissionRequired)
 	return Authority{
 		header:             &Header{},
 		body:               &Body{},
-		tokenRequired:      token,
-		permissionRequired: permission,
+		tokenRequired:      v.tokenRequired,
+		permissionRequired: v.permissionRequired,
+		options:            opts,
 	}
 }
 
 // Body extracts a copy of the body.
 func (a *Authority) Body() *Body {
-	if a.body == nil {
-		return nil
-	}
-
-	return &Body{
-		UUID:                a.body.UUID,
-		Permission:          a.body.Permission,
-		ExpirationTimestamp: a.body.ExpirationTimestamp,
-	}
+	return copyBody(a.body)
 }
 
 // Header extracts a copy of the header.
 func (a *Authority) Header() *Header {
-	if a.header == nil {
-		return nil
-	}
-
-	return &Header{
-		Alg:      a.header.Alg,
-		TokenTyp: a.header.TokenTyp,
-	}
+	return copyHeader(a.header)
 }
 
 // Authorize the identification and generates its fields.
@@ -81,66 +60,27 @@ func (a *Authority) Invalidate() {
 	a.body = nil
 	a.tokenRequired = NoType
 	a.permissionRequired = NoPermission
+	a.options = nil
 }
 
 // Validate checks if the token is authorized using a secret.
+// The header and body of the authority are replaced with the verified ones.
 // Returns an error if not valid.
 func (a *Authority) Validate() error {
-	if err := ValidateIdentification(a.id); err != nil {
-		return err
+	v := &Verifier{
+		tokenRequired:      a.tokenRequired,
+		permissionRequired: a.permissionRequired,
 	}
-	tokenSignature := strings.Split(a.id.GetToken(), ".")
-	// check 1: do we have a header, body, and signature?
-	if len(tokenSignature) != 3 {
-		return consts.ErrIncompleteToken
+	for _, opt := range a.options {
+		opt(v)
 	}
-	// check 2: decode header
-	decodedHeader, err := base64Decode(tokenSignature[0])
+	claims, err := v.Verify(a.id)
 	if err != nil {
 		return err
 	}
-	// check 3: decode header
-	// a.header is mutated here
-	if err := json.Unmarshal([]byte(decodedHeader), a.header); err != nil {
-		return err
-	}
-	if err := ValidateHeader(a.header); err != nil {
-		return err
-	}
-	// check 4: decode body
-	decodedBody, err := base64Decode(tokenSignature[1])
-	if err != nil {
-		return err
-	}
-	// check 5: parses body from string to a struct
-	// a.body is mutated here
-	if err := json.Unmarshal([]byte(decodedBody), a.body); err != nil {
-		return err
-	}
-	// check expiration in body
-	if err := ValidateBody(a.body); err != nil {
-		return err
-	}
-	// check 6: checks permission requirement
-	if a.body.Permission < a.permissionRequired {
-		return consts.ErrInvalidPermission
-	}
-	if a.body.Permission == Admin && a.header.Alg != Hs512 {
-		return consts.ErrInvalidPermission
-	}
-	// check 7: checks token type
-	if a.header.TokenTyp != a.tokenRequired {
-		return consts.ErrInvalidRequiredTokenType
-	}
-	// check 8: rebuild the signature using the secret
-	suspectedSignature, err := buildTokenSignature(tokenSignature[0], tokenSignature[1], a.header.Alg, a.id.GetSecret())
-	if err != nil {
-		return err
-	}
-	// the signature in the token should be the same with the suspected signature
-	if a.id.GetToken() != suspectedSignature {
-		return consts.ErrInvalidSignature
-	}
+	// claims are not shared, so the authority can own them
+	a.header = claims.header
+	a.body = claims.body
 	return nil
 }
 
@@ -5,6 +5,7 @@ import (
 	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
 	"github.com/hwsc-org/hwsc-lib/consts"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,7 +19,12 @@ func TestNewAuthority(t *testing.T) {
 		expPermLevel  Permission
 	}{
 		{"test for negative token type", NoType - 1, NoType, -1, NoPermission},
-		{"test for over max token type", Jwt + 1, NoType, -1, NoPermission},
+		{"test for over max token type", Jmt + 1, NoType, -1, NoPermission},
+		{"test for Jet", Jet, Jet, User, User},
+		{"test for Jrt", Jrt, Jrt, User, User},
+		{"test for Jpt", Jpt, Jpt, User, User},
+		{"test for Jct", Jct, Jct, User, User},
+		{"test for Jmt", Jmt, Jmt, User, User},
 		{"test for negative permission", NoType, NoType, NoPermission - 1, NoPermission},
 		{"test for negative permission", Jwt, Jwt, -1, NoPermission},
 		{"test for NoPermission", NoType, NoType, NoPermission, NoPermission},
@@ -463,6 +469,57 @@ func TestValidate(t *testing.T) {
 				},
 			}, false, nil,
 		},
+		{"test for duplicate permission keys",
+			&Authority{
+				tokenRequired:      Jwt,
+				permissionRequired: Admin,
+				id: &pbauth.Identification{
+					Token: signRaw(Hs256, `{"Alg":1,"TokenTyp":1}`,
+						`{"UUID":"22d3x3wm2nnrdfzp0tka2vw9dx","Permission":2,"ExpirationTimestamp":1893456000,"permission":3}`),
+					Secret: validSecret,
+				},
+			}, true, consts.ErrDuplicateJSONKey,
+		},
+		{"test for unknown header field",
+			&Authority{
+				tokenRequired:      Jwt,
+				permissionRequired: User,
+				id: &pbauth.Identification{
+					Token:  signRaw(Hs256, `{"Alg":1,"TokenTyp":1,"None":true}`, `{"UUID":"22d3x3wm2nnrdfzp0tka2vw9dx","Permission":2,"ExpirationTimestamp":1893456000}`),
+					Secret: validSecret,
+				},
+			}, true, consts.ErrUnknownHeaderField,
+		},
+		{"test for unpadded signature",
+			&Authority{
+				tokenRequired:      Jwt,
+				permissionRequired: User,
+				id: &pbauth.Identification{
+					Token:  strings.TrimRight(valid256JWTUserTokenString, "="),
+					Secret: validSecret,
+				},
+			}, true, consts.ErrInvalidSignature,
+		},
+		{"test for truncated signature",
+			&Authority{
+				tokenRequired:      Jwt,
+				permissionRequired: User,
+				id: &pbauth.Identification{
+					Token:  valid256JWTUserSignature + ".e5-zlHh02bJeZ7rVGuSVVTUG1k1L_aKKRddXXojpcxI",
+					Secret: validSecret,
+				},
+			}, true, consts.ErrInvalidSignature,
+		},
+		{"test for token over max size",
+			&Authority{
+				tokenRequired:      Jwt,
+				permissionRequired: User,
+				id: &pbauth.Identification{
+					Token:  valid256JWTUserTokenString + strings.Repeat("=", MaxTokenSize),
+					Secret: validSecret,
+				},
+			}, true, consts.ErrTokenTooLarge,
+		},
 		{"test for invalid header with unknown algorithm",
 			&Authority{
 				header:             &Header{},
@@ -578,3 +635,32 @@ func TestHasExpired(t *testing.T) {
 	}
 
 }
+
+func FuzzValidate(f *testing.F) {
+	seeds := []string{
+		valid256JWTUserTokenString,
+		valid512JWTAdminTokenString,
+		invalid256NoTypeTokenString,
+		fakeToken,
+		unknownAlgToken,
+		"a.b.c",
+		"..",
+		"e30.e30.",
+		"bnVsbA.bnVsbA.AAAA",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, token string) {
+		// malformed tokens must be rejected without panicking
+		a := NewAuthority(Jwt, User)
+		a.id = &pbauth.Identification{
+			Token:  token,
+			Secret: validSecret,
+		}
+		if err := a.Validate(); err == nil && token != valid256JWTUserTokenString &&
+			token != valid512JWTAdminTokenString {
+			t.Errorf("unexpected valid token %q", token)
+		}
+	})
+}
@@ -14,9 +14,45 @@ const (
 	Admin
 )
 
+// PrincipalType is the kind of caller a token identifies.
+type PrincipalType int32
+
+const (
+	// UserPrincipal identifies a user by UUID
+	UserPrincipal PrincipalType = iota
+	// ServicePrincipal identifies an hwsc service by name, see ClientIssuer
+	ServicePrincipal
+)
+
 // Body contains the user's uuid, permission level, and expiration timestamp.
+// Tokens of a ServicePrincipal have the name of the Service instead of a uuid.
+// IssuedAt and NotBefore are optional unix timestamps,
+// a token is not valid before NotBefore.
+// Issuer names the service that minted the token and Audience the services it is meant for.
+// ID identifies refresh and email tokens, FamilyID is only set on refresh tokens.
+// Binding ties a purpose token to the user's current password or email, see PurposeIssuer.
+// Roles and Scopes grant fine-grained access evaluated by a Policy.
+// Actor is the principal acting as the user or service of the token, ie: an admin impersonating a user.
+// Confirmation binds the token to a key or TLS certificate of the client, see VerifyPresentation.
+// Session identifies the login the token was issued for, see SessionStore.
+// AuthMethods are how the user authenticated at login, the amr claim of RFC 8176, see WithMFA.
 type Body struct {
 	UUID                string
 	Permission          Permission
 	ExpirationTimestamp int64
+	IssuedAt            int64         `json:",omitempty"`
+	NotBefore           int64         `json:",omitempty"`
+	Issuer              string        `json:",omitempty"`
+	Audience            []string      `json:",omitempty"`
+	Binding             string        `json:",omitempty"`
+	ID                  string        `json:",omitempty"`
+	FamilyID            string        `json:",omitempty"`
+	Roles               []string      `json:",omitempty"`
+	Scopes              []string      `json:",omitempty"`
+	Confirmation        *Confirmation `json:",omitempty"`
+	Principal           PrincipalType `json:",omitempty"`
+	Service             string        `json:",omitempty"`
+	Actor               *Actor        `json:",omitempty"`
+	Session             string        `json:",omitempty"`
+	AuthMethods         []string      `json:",omitempty"`
 }
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientTokenLifetime is how long service tokens are valid unless configured otherwise.
+	DefaultClientTokenLifetime = 15 * time.Minute
+)
+
+// ServiceClient is a service registered to exchange its credential for service tokens.
+// SecretHash is the base64 encoded SHA-256 hash of the client secret, the secret itself is never stored.
+// Scopes are the most a token of the service can be granted, and Audience the services it may call.
+type ServiceClient struct {
+	Service    string
+	SecretHash string
+	Permission Permission
+	Scopes     []string
+	Audience   []string
+}
+
+// ClientStore keeps the services registered with a ClientIssuer.
+type ClientStore interface {
+	// Register adds the client.
+	// Returns consts.ErrDuplicateClient if the service is already registered.
+	Register(client *ServiceClient) error
+	// Client looks up the client of the service.
+	// Returns consts.ErrUnknownClient if the service is not registered.
+	Client(service string) (*ServiceClient, error)
+	// Remove unregisters the service, its tokens stay valid until they expire.
+	// Returns consts.ErrUnknownClient if the service is not registered.
+	Remove(service string) error
+}
+
+// memoryClientStore is an in memory ClientStore.
+type memoryClientStore struct {
+	locker  sync.Mutex
+	clients map[string]*ServiceClient
+}
+
+// NewMemoryClientStore makes an in memory ClientStore.
+// Registrations are lost when the process exits.
+func NewMemoryClientStore() ClientStore {
+	return &memoryClientStore{
+		clients: make(map[string]*ServiceClient),
+	}
+}
+
+// Register implements ClientStore.
+func (s *memoryClientStore) Register(client *ServiceClient) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if _, ok := s.clients[client.Service]; ok {
+		return consts.ErrDuplicateClient
+	}
+	s.clients[client.Service] = copyServiceClient(client)
+	return nil
+}
+
+// Client implements ClientStore.
+func (s *memoryClientStore) Client(service string) (*ServiceClient, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	client, ok := s.clients[service]
+	if !ok {
+		return nil, consts.ErrUnknownClient
+	}
+	return copyServiceClient(client), nil
+}
+
+// Remove implements ClientStore.
+func (s *memoryClientStore) Remove(service string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if _, ok := s.clients[service]; !ok {
+		return consts.ErrUnknownClient
+	}
+	delete(s.clients, service)
+	return nil
+}
+
+// copyServiceClient makes a deep copy of the client.
+func copyServiceClient(client *ServiceClient) *ServiceClient {
+	return &ServiceClient{
+		Service:    client.Service,
+		SecretHash: client.SecretHash,
+		Permission: client.Permission,
+		Scopes:     copyStrings(client.Scopes),
+		Audience:   copyStrings(client.Audience),
+	}
+}
+
+// ClientOption configures a ClientIssuer.
+type ClientOption func(*ClientIssuer)
+
+// WithClientClock makes the client issuer read the current time from the clock.
+func WithClientClock(clock Clock) ClientOption {
+	return func(c *ClientIssuer) {
+		c.clock = clock
+	}
+}
+
+// WithClientTokenLifetime makes the client issuer expire service tokens after lifetime.
+func WithClientTokenLifetime(lifetime time.Duration) ClientOption {
+	return func(c *ClientIssuer) {
+		c.lifetime = lifetime
+	}
+}
+
+// WithClientIssuerName makes the client issuer stamp its name on every service token.
+func WithClientIssuerName(issuer string) ClientOption {
+	return func(c *ClientIssuer) {
+		c.issuer = issuer
+	}
+}
+
+// ClientIssuer implements the client credentials flow between hwsc services:
+// a registered service exchanges its client secret for a short-lived ServicePrincipal token.
+type ClientIssuer struct {
+	secret   *pbauth.Secret
+	store    ClientStore
+	clock    Clock
+	lifetime time.Duration
+	issuer   string
+}
+
+// NewClientIssuer makes an issuer that signs service tokens with the secret
+// and looks up registered services in the store.
+// Returns an error if the secret is not valid or the store is nil.
+func NewClientIssuer(secret *pbauth.Secret, store ClientStore, opts ...ClientOption) (*ClientIssuer, error) {
+	c := &ClientIssuer{
+		secret:   secret,
+		store:    store,
+		clock:    SystemClock,
+		lifetime: DefaultClientTokenLifetime,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if c.lifetime < time.Second {
+		return nil, consts.ErrInvalidLifetime
+	}
+	if err := validateSecret(secret, c.clock.Now(), 0); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, consts.ErrNilClientStore
+	}
+	if c.issuer != "" && strings.TrimSpace(c.issuer) == "" {
+		return nil, consts.ErrInvalidIssuer
+	}
+	return c, nil
+}
+
+// Register registers the service with the permission, the scopes its tokens can be granted
+// and the audiences it may call.
+// Returns the client secret, which is only available now and must be handed to the service securely.
+func (c *ClientIssuer) Register(service string, permission Permission, scopes []string,
+	audience ...string) (string, error) {
+	if err := validation.ValidateServiceName(service); err != nil {
+		return "", err
+	}
+	if permission < NoPermission || permission > Admin {
+		return "", consts.ErrUnknownPermission
+	}
+	for _, scope := range scopes {
+		if err := validateScope(scope); err != nil {
+			return "", err
+		}
+	}
+	for _, name := range audience {
+		if strings.TrimSpace(name) == "" {
+			return "", consts.ErrInvalidAudience
+		}
+	}
+	clientSecret, err := GenerateSecretKey(SecretByteSize)
+	if err != nil {
+		return "", err
+	}
+	if err := c.store.Register(&ServiceClient{
+		Service:    service,
+		SecretHash: hashClientSecret(clientSecret),
+		Permission: permission,
+		Scopes:     scopes,
+		Audience:   audience,
+	}); err != nil {
+		return "", err
+	}
+	return clientSecret, nil
+}
+
+// Exchange trades the client secret of the service for a service token granted the requested scopes,
+// or every registered scope if none is requested.
+// Returns consts.ErrInvalidClientCredentials if the service is unknown or the secret does not match,
+// or consts.ErrInvalidScope if a scope exceeds the registration.
+func (c *ClientIssuer) Exchange(service string, clientSecret string, scopes ...string) (*pbauth.Identification, error) {
+	client, err := c.store.Client(service)
+	if err == consts.ErrUnknownClient {
+		return nil, consts.ErrInvalidClientCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashClientSecret(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, consts.ErrInvalidClientCredentials
+	}
+	granted := client.Scopes
+	if len(scopes) > 0 {
+		for _, scope := range scopes {
+			if validateScope(scope) != nil || !isScopeGranted(client.Scopes, scope) {
+				return nil, consts.ErrInvalidScope
+			}
+		}
+		granted = scopes
+	}
+
+	now := c.clock.Now()
+	token, err := newToken(
+		&Header{
+			Alg:      AlgorithmMap[client.Permission],
+			TokenTyp: Jwt,
+		},
+		&Body{
+			Principal:           ServicePrincipal,
+			Service:             client.Service,
+			Permission:          client.Permission,
+			ExpirationTimestamp: now.Add(c.lifetime).Unix(),
+			IssuedAt:            now.Unix(),
+			Issuer:              c.issuer,
+			Audience:            client.Audience,
+			Scopes:              granted,
+		},
+		c.secret,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &pbauth.Identification{
+		Token:  token,
+		Secret: c.secret,
+	}, nil
+}
+
+// hashClientSecret hashes the client secret with SHA-256.
+// Client secrets are random keys, so they do not need a slow password hash.
+func hashClientSecret(clientSecret string) string {
+	hash := sha256.Sum256([]byte(clientSecret))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
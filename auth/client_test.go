@@ -0,0 +1,200 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewClientIssuer(t *testing.T) {
+	cases := []struct {
+		desc     string
+		secret   *pbauth.Secret
+		store    ClientStore
+		opts     []ClientOption
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil secret", nil, NewMemoryClientStore(), nil, true, consts.ErrNilSecret},
+		{"test for nil store", validSecret, nil, nil, true, consts.ErrNilClientStore},
+		{"test for nil clock", validSecret, NewMemoryClientStore(), []ClientOption{WithClientClock(nil)},
+			true, consts.ErrNilClock},
+		{"test for zero lifetime", validSecret, NewMemoryClientStore(), []ClientOption{WithClientTokenLifetime(0)},
+			true, consts.ErrInvalidLifetime},
+		{"test for blank issuer", validSecret, NewMemoryClientStore(), []ClientOption{WithClientIssuerName(" ")},
+			true, consts.ErrInvalidIssuer},
+		{"test for valid input", validSecret, NewMemoryClientStore(), nil, false, nil},
+	}
+	for _, c := range cases {
+		issuer, err := NewClientIssuer(c.secret, c.store, c.opts...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, issuer, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, issuer, c.desc)
+		}
+	}
+}
+
+func TestClientIssuerRegister(t *testing.T) {
+	issuer, err := NewClientIssuer(validSecret, NewMemoryClientStore())
+	assert.Nil(t, err)
+	cases := []struct {
+		desc       string
+		service    string
+		permission Permission
+		scopes     []string
+		audience   []string
+		expErr     error
+	}{
+		{"test for invalid service name", "Document Service", User, nil, nil, consts.ErrInvalidServiceName},
+		{"test for unknown permission", "hwsc-document-svc", Admin + 1, nil, nil, consts.ErrUnknownPermission},
+		{"test for invalid scope", "hwsc-document-svc", User, []string{"user read"}, nil, consts.ErrInvalidScope},
+		{"test for blank audience", "hwsc-document-svc", User, nil, []string{" "}, consts.ErrInvalidAudience},
+		{"test for valid registration", "hwsc-document-svc", User, []string{"user:read"}, nil, nil},
+		{"test for duplicate registration", "hwsc-document-svc", User, nil, nil, consts.ErrDuplicateClient},
+	}
+	for _, c := range cases {
+		clientSecret, err := issuer.Register(c.service, c.permission, c.scopes, c.audience...)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Empty(t, clientSecret, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotEmpty(t, clientSecret, c.desc)
+		}
+	}
+}
+
+func TestClientIssuerExchange(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	store := NewMemoryClientStore()
+	issuer, err := NewClientIssuer(validSecret, store, WithClientClock(clock),
+		WithClientIssuerName("hwsc-auth-svc"))
+	assert.Nil(t, err)
+	clientSecret, err := issuer.Register("hwsc-document-svc", User, []string{"user:read", "file:*"},
+		"hwsc-user-svc", "hwsc-file-transaction-svc")
+	assert.Nil(t, err)
+	client, err := store.Client("hwsc-document-svc")
+	assert.Nil(t, err)
+	assert.NotContains(t, client.SecretHash, clientSecret, "test for hashed client secret")
+
+	cases := []struct {
+		desc      string
+		service   string
+		secret    string
+		scopes    []string
+		isExpErr  bool
+		expErr    error
+		expScopes []string
+	}{
+		{"test for unknown service", "hwsc-user-svc", clientSecret, nil, true, consts.ErrInvalidClientCredentials, nil},
+		{"test for wrong secret", "hwsc-document-svc", "wrong", nil, true, consts.ErrInvalidClientCredentials, nil},
+		{"test for scope beyond registration", "hwsc-document-svc", clientSecret, []string{"user:write"},
+			true, consts.ErrInvalidScope, nil},
+		{"test for every registered scope", "hwsc-document-svc", clientSecret, nil, false, nil,
+			[]string{"user:read", "file:*"}},
+		{"test for narrowed scopes", "hwsc-document-svc", clientSecret, []string{"file:read"}, false, nil,
+			[]string{"file:read"}},
+	}
+	for _, c := range cases {
+		id, err := issuer.Exchange(c.service, c.secret, c.scopes...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, id, c.desc)
+			continue
+		}
+		assert.Nil(t, err, c.desc)
+		claims, err := NewVerifier(Jwt, User, WithClock(clock), WithServices()).Verify(id)
+		assert.Nil(t, err, c.desc)
+		body := claims.Body()
+		assert.Equal(t, ServicePrincipal, body.Principal, c.desc)
+		assert.Equal(t, "hwsc-document-svc", body.Service, c.desc)
+		assert.Empty(t, body.UUID, c.desc)
+		assert.Equal(t, "hwsc-auth-svc", body.Issuer, c.desc)
+		assert.Equal(t, []string{"hwsc-user-svc", "hwsc-file-transaction-svc"}, body.Audience, c.desc)
+		assert.Equal(t, c.expScopes, body.Scopes, c.desc)
+		assert.Equal(t, now.Add(DefaultClientTokenLifetime).Unix(), body.ExpirationTimestamp, c.desc)
+	}
+
+	assert.Nil(t, store.Remove("hwsc-document-svc"))
+	_, err = issuer.Exchange("hwsc-document-svc", clientSecret)
+	assert.EqualError(t, err, consts.ErrInvalidClientCredentials.Error(), "test for removed service")
+	assert.EqualError(t, store.Remove("hwsc-document-svc"), consts.ErrUnknownClient.Error(), "test for removed service")
+}
+
+func TestVerifyPrincipal(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	issuer, err := NewClientIssuer(validSecret, NewMemoryClientStore(), WithClientClock(clock))
+	assert.Nil(t, err)
+	clientSecret, err := issuer.Register("hwsc-document-svc", User, nil)
+	assert.Nil(t, err)
+	serviceID, err := issuer.Exchange("hwsc-document-svc", clientSecret)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc     string
+		opts     []VerifierOption
+		id       *pbauth.Identification
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for user token by default", nil, validUserIdentification, false, nil},
+		{"test for service token by default", nil, serviceID, true, consts.ErrPrincipalNotAllowed},
+		{"test for any service", []VerifierOption{WithServices()}, serviceID, false, nil},
+		{"test for user token with services", []VerifierOption{WithServices()}, validUserIdentification, false, nil},
+		{"test for named service", []VerifierOption{WithServices("hwsc-document-svc")}, serviceID, false, nil},
+		{"test for other service", []VerifierOption{WithServices("hwsc-user-svc")}, serviceID,
+			true, consts.ErrServiceNotAllowed},
+		{"test for services only", []VerifierOption{WithPrincipals(ServicePrincipal)}, validUserIdentification,
+			true, consts.ErrPrincipalNotAllowed},
+		{"test for services only with service", []VerifierOption{WithPrincipals(ServicePrincipal)}, serviceID,
+			false, nil},
+	}
+	for _, c := range cases {
+		opts := append([]VerifierOption{WithClock(clock)}, c.opts...)
+		_, err := NewVerifier(Jwt, User, opts...).Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.True(t, isForbidden(err), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestValidatePrincipal(t *testing.T) {
+	cases := []struct {
+		desc      string
+		principal PrincipalType
+		uuid      string
+		service   string
+		expErr    error
+	}{
+		{"test for user", UserPrincipal, validUserBody.UUID, "", nil},
+		{"test for user with service", UserPrincipal, validUserBody.UUID, "hwsc-document-svc",
+			consts.ErrInvalidPrincipal},
+		{"test for user without uuid", UserPrincipal, "", "", consts.ErrInvalidUUID},
+		{"test for service", ServicePrincipal, "", "hwsc-document-svc", nil},
+		{"test for service with uuid", ServicePrincipal, validUserBody.UUID, "hwsc-document-svc",
+			consts.ErrInvalidPrincipal},
+		{"test for service without name", ServicePrincipal, "", "", consts.ErrInvalidServiceName},
+		{"test for unknown principal", ServicePrincipal + 1, "", "hwsc-document-svc", consts.ErrUnknownPrincipal},
+	}
+	for _, c := range cases {
+		body := copyBody(validUserBody)
+		body.Principal = c.principal
+		body.UUID = c.uuid
+		body.Service = c.service
+		err := ValidateBody(body)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time.
+// Every time based check in auth reads the time from a Clock so that it can be replaced in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock backed by the system time.
+type systemClock struct{}
+
+// Now returns the current system time in UTC.
+func (systemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+var (
+	// SystemClock is the Clock used unless another Clock is injected.
+	SystemClock Clock = systemClock{}
+)
+
+// FakeClock is a Clock that only moves when told to, for deterministic tests.
+type FakeClock struct {
+	locker sync.Mutex
+	now    time.Time
+}
+
+// NewFakeClock makes a FakeClock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now: now.UTC(),
+	}
+}
+
+// Now returns the time the clock is stopped at.
+func (c *FakeClock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	return c.now
+}
+
+// Set stops the clock at now.
+func (c *FakeClock) Set(now time.Time) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.now = now.UTC()
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	c.now = c.now.Add(d)
+}
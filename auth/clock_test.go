@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	now := SystemClock.Now()
+	assert.Equal(t, time.UTC, now.Location())
+	assert.False(t, now.Before(before.Truncate(time.Second)))
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.FixedZone("PST", -8*60*60))
+	clock := NewFakeClock(start)
+	assert.Equal(t, time.UTC, clock.Now().Location(), "test for UTC")
+	assert.True(t, start.Equal(clock.Now()), "test for stopped clock")
+	assert.True(t, start.Equal(clock.Now()), "test for stopped clock")
+
+	clock.Advance(time.Hour)
+	assert.True(t, start.Add(time.Hour).Equal(clock.Now()), "test for advance")
+
+	clock.Set(start)
+	assert.True(t, start.Equal(clock.Now()), "test for set")
+
+	// run with -race
+	const count = 50
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			clock.Advance(time.Second)
+			_ = clock.Now()
+		}()
+	}
+	wg.Wait()
+	assert.True(t, start.Add(count*time.Second).Equal(clock.Now()), "test for concurrent advance")
+}
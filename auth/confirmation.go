@@ -0,0 +1,550 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProofHeaderKey is the HTTP header, and the gRPC metadata key in lowercase,
+	// carrying the proof of possession of a key bound token.
+	ProofHeaderKey = "DPoP"
+	// ProofMaxAge is how long a proof of possession is accepted after it was signed.
+	ProofMaxAge = 5 * time.Minute
+	proofType   = "dpop+jwt"
+	strES256    = "ES256"
+	strES384    = "ES384"
+	strES512    = "ES512"
+	strRS256    = "RS256"
+	strEdDSA    = "EdDSA"
+	strRSA      = "RSA"
+	strOKP      = "OKP"
+	strEd25519  = "Ed25519"
+
+	// proofPruneInterval is how often the memory ProofReplayStore discards the expired jti
+	proofPruneInterval = time.Minute
+)
+
+// ProofReplayStore remembers the jti of accepted proofs of possession while they can still be accepted,
+// so that a captured proof cannot be replayed (RFC 9449 11.1).
+type ProofReplayStore interface {
+	// Use records the jti of a proof accepted at now, until expires.
+	// Returns consts.ErrProofReplayed if the jti was already used and has not expired.
+	Use(jti string, now time.Time, expires time.Time) error
+}
+
+// memoryProofReplayStore is an in memory ProofReplayStore.
+type memoryProofReplayStore struct {
+	locker  sync.Mutex
+	expires map[string]time.Time
+	pruneAt time.Time
+}
+
+// NewMemoryProofReplayStore makes an in memory ProofReplayStore.
+// Used proofs are forgotten when the process exits, or once they expire.
+func NewMemoryProofReplayStore() ProofReplayStore {
+	return &memoryProofReplayStore{
+		expires: make(map[string]time.Time),
+	}
+}
+
+// Use implements ProofReplayStore.
+func (s *memoryProofReplayStore) Use(jti string, now time.Time, expires time.Time) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if !now.Before(s.pruneAt) {
+		for used, usedExpires := range s.expires {
+			if !now.Before(usedExpires) {
+				delete(s.expires, used)
+			}
+		}
+		s.pruneAt = now.Add(proofPruneInterval)
+	}
+	if usedExpires, ok := s.expires[jti]; ok && now.Before(usedExpires) {
+		return consts.ErrProofReplayed
+	}
+	s.expires[jti] = expires
+	return nil
+}
+
+// WithProofReplayStore makes the verifier remember the proofs of possession it accepts in the store.
+// Verifiers without a store reject key bound tokens with consts.ErrNilProofReplayStore.
+// The store must outlive the verifier, ie: made once per service rather than for each request,
+// or shared by every instance of the service.
+func WithProofReplayStore(store ProofReplayStore) VerifierOption {
+	return func(v *Verifier) {
+		v.proofReplays = store
+	}
+}
+
+// Confirmation is the cnf claim of RFC 7800 binding a token to a key the client proves it holds,
+// so that a stolen token cannot be replayed by another client.
+// KeyThumbprint is the RFC 7638 thumbprint of the client's public key, proven with a signed proof,
+// and CertificateThumbprint is the SHA-256 hash of the client's TLS certificate, proven by the connection.
+// Exactly one of them is set.
+type Confirmation struct {
+	KeyThumbprint         string `json:",omitempty"`
+	CertificateThumbprint string `json:",omitempty"`
+}
+
+// Presentation is how a token reached the service, to check the proof of possession of bound tokens.
+// Certificates are the verified TLS client certificates, leaf first.
+// Proof is the DPoP-style proof signed by the client for the request's Method and URL.
+// RemoteAddr is the network address of the client, to track its failures.
+type Presentation struct {
+	Certificates []*x509.Certificate
+	Proof        string
+	Method       string
+	URL          string
+	RemoteAddr   string
+}
+
+// PresentationFromRequest extracts the TLS client certificates and the proof of the HTTP request.
+// The URL is rebuilt from the Host header and the TLS state of the connection,
+// services behind a proxy terminating TLS use PresentationFromRequestWithURL.
+func PresentationFromRequest(r *http.Request) *Presentation {
+	p := &Presentation{
+		Proof:      r.Header.Get(ProofHeaderKey),
+		Method:     r.Method,
+		URL:        "http://" + r.Host + r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if r.TLS != nil {
+		p.Certificates = r.TLS.PeerCertificates
+		p.URL = "https://" + r.Host + r.URL.Path
+	}
+	return p
+}
+
+// PresentationFromRequestWithURL is PresentationFromRequest with the URL made of the external base URL
+// of the service, ie: "https://api.hwsc.org", and the path of the request.
+func PresentationFromRequestWithURL(r *http.Request, baseURL string) *Presentation {
+	p := PresentationFromRequest(r)
+	p.URL = strings.TrimSuffix(baseURL, "/") + r.URL.Path
+	return p
+}
+
+// validateExternalURL checks that the base URL is an absolute http or https URL without a query or fragment.
+func validateExternalURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != strHTTPS) || u.Host == "" || u.User != nil ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return consts.ErrInvalidExternalURL
+	}
+	return nil
+}
+
+// KeyThumbprint computes the RFC 7638 SHA-256 thumbprint of an ECDSA, RSA or Ed25519 public key,
+// to set Confirmation.KeyThumbprint.
+// Returns consts.ErrUnsupportedKey for other keys.
+func KeyThumbprint(key crypto.PublicKey) (string, error) {
+	jwk, err := newProofJWK(key)
+	if err != nil {
+		return "", err
+	}
+	var members string
+	switch jwk.Kty {
+	case strEC:
+		members = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
+	case strRSA:
+		members = fmt.Sprintf(`{"e":"%s","kty":"%s","n":"%s"}`, jwk.E, jwk.Kty, jwk.N)
+	default:
+		members = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+	hash := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// CertificateThumbprint computes the SHA-256 hash of the DER encoded certificate,
+// to set Confirmation.CertificateThumbprint.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// NewProof signs a proof of possession of the key for a request to the method and URL carrying the token,
+// ie: to send in the ProofHeaderKey header. Supports ECDSA P-256, P-384, P-521, RSA and Ed25519 keys.
+// The URL must not have a query or fragment. For gRPC, the method is "POST" and the URL the full method name.
+// Returns an error if the key is not supported or signing fails.
+func NewProof(key crypto.Signer, method string, url string, token string, now time.Time) (string, error) {
+	jwk, err := newProofJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+	id, err := generateID(now)
+	if err != nil {
+		return "", err
+	}
+	header := &proofHeader{
+		Typ: proofType,
+		Alg: proofAlgorithm(key.Public()),
+		JWK: jwk,
+	}
+	claims := &proofClaims{
+		JTI: id,
+		HTM: method,
+		HTU: url,
+		IAT: now.Unix(),
+		ATH: accessTokenHash(token),
+	}
+	encodedHeader, err := encodeProofPart(header)
+	if err != nil {
+		return "", err
+	}
+	encodedClaims, err := encodeProofPart(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encodedHeader + "." + encodedClaims
+	signature, err := signProof(key, signingInput)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// validateConfirmation checks that the confirmation claim has exactly one valid thumbprint.
+func validateConfirmation(cnf *Confirmation) error {
+	thumbprint := cnf.KeyThumbprint
+	if (thumbprint == "") == (cnf.CertificateThumbprint == "") {
+		return consts.ErrInvalidConfirmation
+	}
+	if thumbprint == "" {
+		thumbprint = cnf.CertificateThumbprint
+	}
+	decoded, err := base64.RawURLEncoding.Strict().DecodeString(thumbprint)
+	if err != nil || len(decoded) != sha256.Size {
+		return consts.ErrInvalidConfirmation
+	}
+	return nil
+}
+
+// checkPossession checks that the presentation proves possession of the key or certificate
+// the token is bound to, and records the proof in replays so that it cannot be used again.
+func checkPossession(token string, cnf *Confirmation, p *Presentation, replays ProofReplayStore, now time.Time,
+	leeway time.Duration) error {
+	if p == nil {
+		return consts.ErrMissingProofOfPossession
+	}
+	if cnf.CertificateThumbprint != "" {
+		if len(p.Certificates) == 0 {
+			return consts.ErrMissingProofOfPossession
+		}
+		if !constantTimeEqual(CertificateThumbprint(p.Certificates[0]), cnf.CertificateThumbprint) {
+			return consts.ErrProofMismatch
+		}
+		return nil
+	}
+	if replays == nil {
+		return consts.ErrNilProofReplayStore
+	}
+	if p.Proof == "" {
+		return consts.ErrMissingProofOfPossession
+	}
+	thumbprint, claims, err := verifyProof(p.Proof, token, p.Method, p.URL, now, leeway)
+	if err != nil {
+		return err
+	}
+	if !constantTimeEqual(thumbprint, cnf.KeyThumbprint) {
+		return consts.ErrProofMismatch
+	}
+	// a jti is only unique for the key that signed the proof
+	expires := time.Unix(claims.IAT, 0).Add(ProofMaxAge + leeway)
+	return replays.Use(thumbprint+"."+claims.JTI, now, expires)
+}
+
+// verifyProof verifies the signature and claims of the proof for the request carrying the token.
+// Returns the thumbprint of the key that signed the proof and the claims of the proof.
+func verifyProof(proof string, token string, method string, url string, now time.Time,
+	leeway time.Duration) (string, *proofClaims, error) {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return "", nil, consts.ErrInvalidProof
+	}
+	header := &proofHeader{}
+	if err := decodeProofPart(parts[0], header); err != nil {
+		return "", nil, err
+	}
+	if header.Typ != proofType || header.JWK == nil || header.JWK.D != "" {
+		return "", nil, consts.ErrInvalidProof
+	}
+	key, err := header.JWK.publicKey()
+	if err != nil {
+		return "", nil, err
+	}
+	if header.Alg != proofAlgorithm(key) {
+		return "", nil, consts.ErrInvalidProof
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return "", nil, consts.ErrInvalidProof
+	}
+	if !verifyProofSignature(key, parts[0]+"."+parts[1], signature) {
+		return "", nil, consts.ErrInvalidProof
+	}
+
+	claims := &proofClaims{}
+	if err := decodeProofPart(parts[1], claims); err != nil {
+		return "", nil, err
+	}
+	if claims.JTI == "" || claims.HTM != method || claims.HTU != url ||
+		!constantTimeEqual(claims.ATH, accessTokenHash(token)) {
+		return "", nil, consts.ErrInvalidProof
+	}
+	age := now.Sub(time.Unix(claims.IAT, 0))
+	if age > ProofMaxAge+leeway || age < -leeway {
+		return "", nil, consts.ErrInvalidProof
+	}
+	thumbprint, err := KeyThumbprint(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return thumbprint, claims, nil
+}
+
+// proofHeader is the JOSE header of a proof.
+type proofHeader struct {
+	Typ string    `json:"typ"`
+	Alg string    `json:"alg"`
+	JWK *proofJWK `json:"jwk"`
+}
+
+// proofClaims are the claims of a proof: a unique id, the HTTP method and URL,
+// when it was signed and the hash of the access token.
+type proofClaims struct {
+	JTI string `json:"jti"`
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	IAT int64  `json:"iat"`
+	ATH string `json:"ath"`
+}
+
+// proofJWK is the public JSON Web Key of a proof.
+// D is only decoded to reject proofs leaking the private key.
+type proofJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// newProofJWK encodes the public key as a JSON Web Key.
+func newProofJWK(key crypto.PublicKey) (*proofJWK, error) {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		name := curveName(k.Curve)
+		if name == "" {
+			return nil, consts.ErrUnsupportedKey
+		}
+		size := curveByteSize(k.Curve)
+		return &proofJWK{
+			Kty: strEC,
+			Crv: name,
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(k.X.Bytes(), size)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(k.Y.Bytes(), size)),
+		}, nil
+	case *rsa.PublicKey:
+		return &proofJWK{
+			Kty: strRSA,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return &proofJWK{
+			Kty: strOKP,
+			Crv: strEd25519,
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	}
+	return nil, consts.ErrUnsupportedKey
+}
+
+// publicKey decodes the JSON Web Key, rejecting points off the curve and small RSA keys.
+func (j *proofJWK) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) ([]byte, error) {
+		decoded, err := base64.RawURLEncoding.Strict().DecodeString(value)
+		if err != nil || len(decoded) == 0 {
+			return nil, consts.ErrInvalidProof
+		}
+		return decoded, nil
+	}
+	switch j.Kty {
+	case strEC:
+		curve, ok := curves[j.Crv]
+		if !ok {
+			return nil, consts.ErrUnsupportedKey
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, consts.ErrInvalidProof
+		}
+		return key, nil
+	case strRSA:
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil || len(e) > 4 {
+			return nil, consts.ErrInvalidProof
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSAKeyBitSize || key.E < 3 {
+			return nil, consts.ErrUnsupportedKey
+		}
+		return key, nil
+	case strOKP:
+		if j.Crv != strEd25519 {
+			return nil, consts.ErrUnsupportedKey
+		}
+		x, err := decode(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, consts.ErrInvalidProof
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, consts.ErrUnsupportedKey
+}
+
+// proofAlgorithm returns the JWS algorithm used with the public key.
+func proofAlgorithm(key crypto.PublicKey) string {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		switch curveName(k.Curve) {
+		case "P-256":
+			return strES256
+		case "P-384":
+			return strES384
+		case "P-521":
+			return strES512
+		}
+	case *rsa.PublicKey:
+		return strRS256
+	case ed25519.PublicKey:
+		return strEdDSA
+	}
+	return ""
+}
+
+// proofHash returns the hash of the JWS algorithm used with the ECDSA curve or RSA.
+func proofHash(key crypto.PublicKey) crypto.Hash {
+	if k, ok := key.(*ecdsa.PublicKey); ok {
+		switch k.Curve.Params().BitSize {
+		case elliptic.P384().Params().BitSize:
+			return crypto.SHA384
+		case elliptic.P521().Params().BitSize:
+			return crypto.SHA512
+		}
+	}
+	return crypto.SHA256
+}
+
+// signProof signs the signing input with the key, encoding ECDSA signatures as JWS r || s.
+func signProof(key crypto.Signer, signingInput string) ([]byte, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		hash := proofHash(&k.PublicKey)
+		digest := hashOf(hash, signingInput)
+		r, s, err := ecdsa.Sign(cryptorand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := curveByteSize(k.Curve)
+		return append(padBytes(r.Bytes(), size), padBytes(s.Bytes(), size)...), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(cryptorand.Reader, k, crypto.SHA256, hashOf(crypto.SHA256, signingInput))
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, []byte(signingInput)), nil
+	}
+	return nil, consts.ErrUnsupportedKey
+}
+
+// verifyProofSignature verifies the JWS signature of the signing input with the public key.
+func verifyProofSignature(key crypto.PublicKey, signingInput string, signature []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		size := curveByteSize(k.Curve)
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, hashOf(proofHash(k), signingInput), r, s)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hashOf(crypto.SHA256, signingInput), signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, []byte(signingInput), signature)
+	}
+	return false
+}
+
+// constantTimeEqual compares the strings in constant time.
+func constantTimeEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// hashOf hashes the value with the hash function.
+func hashOf(hash crypto.Hash, value string) []byte {
+	h := hash.New()
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// accessTokenHash returns the base64 encoded SHA-256 hash of the token, the ath claim of a proof.
+func accessTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// encodeProofPart encodes the header or claims of a proof.
+func encodeProofPart(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeProofPart decodes the header or claims of a proof, rejecting duplicate keys.
+func decodeProofPart(encoded string, v interface{}) error {
+	data, err := base64.RawURLEncoding.Strict().DecodeString(encoded)
+	if err != nil {
+		return consts.ErrInvalidProof
+	}
+	if err := checkDuplicateKeys(data); err != nil {
+		return consts.ErrInvalidProof
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return consts.ErrInvalidProof
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBoundIdentification mints a user access token bound by the confirmation.
+func newBoundIdentification(t *testing.T, cnf *Confirmation) *pbauth.Identification {
+	token, err := NewToken(&Header{Alg: Hs256, TokenTyp: Jwt}, &Body{
+		UUID:                validUserBody.UUID,
+		Permission:          User,
+		ExpirationTimestamp: time.Now().Add(time.Hour).Unix(),
+		Confirmation:        cnf,
+	}, validSecret)
+	assert.Nil(t, err)
+	return &pbauth.Identification{
+		Token:  token,
+		Secret: validSecret,
+	}
+}
+
+// newTestCertificate makes a self-signed client certificate.
+func newTestCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hwsc-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestKeyThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	n, err := base64.RawURLEncoding.DecodeString(
+		"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6" +
+			"tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMi" +
+			"cAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_" +
+			"xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	assert.Nil(t, err)
+	thumbprint, err := KeyThumbprint(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	assert.Nil(t, err, "test for rfc 7638 example")
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint, "test for rfc 7638 example")
+
+	_, err = KeyThumbprint("not a key")
+	assert.EqualError(t, err, consts.ErrUnsupportedKey.Error(), "test for unsupported key")
+}
+
+func TestValidateConfirmation(t *testing.T) {
+	thumbprint := CertificateThumbprint(newTestCertificate(t))
+	cases := []struct {
+		desc     string
+		cnf      *Confirmation
+		isExpErr bool
+	}{
+		{"test for empty confirmation", &Confirmation{}, true},
+		{"test for both thumbprints", &Confirmation{KeyThumbprint: thumbprint, CertificateThumbprint: thumbprint}, true},
+		{"test for short thumbprint", &Confirmation{KeyThumbprint: "abc"}, true},
+		{"test for padded thumbprint", &Confirmation{KeyThumbprint: thumbprint + "="}, true},
+		{"test for key thumbprint", &Confirmation{KeyThumbprint: thumbprint}, false},
+		{"test for certificate thumbprint", &Confirmation{CertificateThumbprint: thumbprint}, false},
+	}
+	for _, c := range cases {
+		body := copyBody(validUserBody)
+		body.Confirmation = c.cnf
+		err := ValidateBody(body)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrInvalidConfirmation.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	cert := newTestCertificate(t)
+	id := newBoundIdentification(t, &Confirmation{CertificateThumbprint: CertificateThumbprint(cert)})
+	v := NewVerifier(Jwt, User)
+	cases := []struct {
+		desc         string
+		presentation *Presentation
+		expErr       error
+	}{
+		{"test for no presentation", nil, consts.ErrMissingProofOfPossession},
+		{"test for no certificate", &Presentation{}, consts.ErrMissingProofOfPossession},
+		{"test for other certificate", &Presentation{Certificates: []*x509.Certificate{newTestCertificate(t)}},
+			consts.ErrProofMismatch},
+		{"test for bound certificate", &Presentation{Certificates: []*x509.Certificate{cert}}, nil},
+	}
+	for _, c := range cases {
+		claims, err := v.VerifyPresentation(id, c.presentation)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, claims, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, CertificateThumbprint(cert), claims.Body().Confirmation.CertificateThumbprint, c.desc)
+		}
+	}
+
+	_, err := v.Verify(id)
+	assert.EqualError(t, err, consts.ErrMissingProofOfPossession.Error(), "test for verify without presentation")
+}
+
+func TestVerifyKeyBinding(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), cryptorand.Reader)
+	assert.Nil(t, err)
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	assert.Nil(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+
+	const url = "https://hwsc.test/documents"
+	now := time.Now()
+	v := NewVerifier(Jwt, User, WithLeeway(time.Second), WithProofReplayStore(NewMemoryProofReplayStore()))
+	for _, key := range []crypto.Signer{ecKey, rsaKey, edKey} {
+		desc := proofAlgorithm(key.Public())
+		thumbprint, err := KeyThumbprint(key.Public())
+		assert.Nil(t, err, desc)
+		id := newBoundIdentification(t, &Confirmation{KeyThumbprint: thumbprint})
+		proof, err := NewProof(key, http.MethodGet, url, id.GetToken(), now)
+		assert.Nil(t, err, desc)
+
+		_, err = v.VerifyPresentation(id, &Presentation{Proof: proof, Method: http.MethodGet, URL: url})
+		assert.Nil(t, err, desc)
+		_, err = v.VerifyPresentation(id, &Presentation{Proof: proof, Method: http.MethodGet, URL: url})
+		assert.EqualError(t, err, consts.ErrProofReplayed.Error(), desc+" test for replayed proof")
+		_, err = NewVerifier(Jwt, User, WithProofReplayStore(NewMemoryProofReplayStore())).VerifyPresentation(id,
+			&Presentation{Proof: proof, Method: http.MethodGet, URL: url})
+		assert.Nil(t, err, desc+" test for other replay store")
+		_, err = NewVerifier(Jwt, User).VerifyPresentation(id,
+			&Presentation{Proof: proof, Method: http.MethodGet, URL: url})
+		assert.EqualError(t, err, consts.ErrNilProofReplayStore.Error(), desc+" test for no replay store")
+
+		otherProof, err := NewProof(otherKey, http.MethodGet, url, id.GetToken(), now)
+		assert.Nil(t, err, desc)
+		staleProof, err := NewProof(key, http.MethodGet, url, id.GetToken(), now.Add(-ProofMaxAge-time.Minute))
+		assert.Nil(t, err, desc)
+		futureProof, err := NewProof(key, http.MethodGet, url, id.GetToken(), now.Add(time.Minute))
+		assert.Nil(t, err, desc)
+		otherTokenProof, err := NewProof(key, http.MethodGet, url, valid256JWTUserTokenString, now)
+		assert.Nil(t, err, desc)
+		parts := strings.Split(proof, ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		signature[0] ^= 1
+		tamperedProof := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+		cases := []struct {
+			desc         string
+			presentation *Presentation
+			expErr       error
+		}{
+			{"test for missing proof", &Presentation{Method: http.MethodGet, URL: url},
+				consts.ErrMissingProofOfPossession},
+			{"test for other key", &Presentation{Proof: otherProof, Method: http.MethodGet, URL: url},
+				consts.ErrProofMismatch},
+			{"test for other method", &Presentation{Proof: proof, Method: http.MethodPost, URL: url},
+				consts.ErrInvalidProof},
+			{"test for other url", &Presentation{Proof: proof, Method: http.MethodGet, URL: url + "/1"},
+				consts.ErrInvalidProof},
+			{"test for stale proof", &Presentation{Proof: staleProof, Method: http.MethodGet, URL: url},
+				consts.ErrInvalidProof},
+			{"test for future proof", &Presentation{Proof: futureProof, Method: http.MethodGet, URL: url},
+				consts.ErrInvalidProof},
+			{"test for other token", &Presentation{Proof: otherTokenProof, Method: http.MethodGet, URL: url},
+				consts.ErrInvalidProof},
+			{"test for tampered signature", &Presentation{Proof: tamperedProof, Method: http.MethodGet, URL: url},
+				consts.ErrInvalidProof},
+			{"test for malformed proof", &Presentation{Proof: "a.b", Method: http.MethodGet, URL: url},
+				consts.ErrInvalidProof},
+		}
+		for _, c := range cases {
+			_, err := v.VerifyPresentation(id, c.presentation)
+			assert.EqualError(t, err, c.expErr.Error(), desc+" "+c.desc)
+		}
+	}
+}
+
+func TestMemoryProofReplayStore(t *testing.T) {
+	store := NewMemoryProofReplayStore()
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(ProofMaxAge)
+
+	cases := []struct {
+		desc   string
+		jti    string
+		now    time.Time
+		expErr error
+	}{
+		{"test for first use", "key.a", now, nil},
+		{"test for replay", "key.a", now.Add(time.Minute), consts.ErrProofReplayed},
+		{"test for other jti", "key.b", now.Add(time.Minute), nil},
+		{"test for replay after expiry", "key.a", expires, nil},
+		{"test for replay of renewed jti", "key.a", expires.Add(time.Minute), consts.ErrProofReplayed},
+	}
+	for _, c := range cases {
+		err := store.Use(c.jti, c.now, c.now.Add(ProofMaxAge))
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestBearerMiddlewareBinding(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	thumbprint, err := KeyThumbprint(&key.PublicKey)
+	assert.Nil(t, err)
+	keyID := newBoundIdentification(t, &Confirmation{KeyThumbprint: thumbprint})
+	cert := newTestCertificate(t)
+	certID := newBoundIdentification(t, &Confirmation{CertificateThumbprint: CertificateThumbprint(cert)})
+	proof, err := NewProof(key, http.MethodGet, "http://example.com/user", keyID.GetToken(), time.Now())
+	assert.Nil(t, err)
+
+	middleware, err := BearerMiddleware(StaticSecret(validSecret), Requirement{
+		TokenType:  Jwt,
+		Permission: User,
+		Options:    []VerifierOption{WithProofReplayStore(NewMemoryProofReplayStore())},
+	}, nil)
+	assert.Nil(t, err)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cases := []struct {
+		desc    string
+		token   string
+		proof   string
+		tls     *tls.ConnectionState
+		expCode int
+	}{
+		{"test for key bound token without proof", keyID.GetToken(), "", nil, http.StatusUnauthorized},
+		{"test for key bound token with proof", keyID.GetToken(), proof, nil, http.StatusOK},
+		{"test for key bound token with replayed proof", keyID.GetToken(), proof, nil, http.StatusUnauthorized},
+		{"test for certificate bound token without tls", certID.GetToken(), "", nil, http.StatusUnauthorized},
+		{"test for certificate bound token with tls", certID.GetToken(), "",
+			&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/user", nil)
+		r.Header.Set("Authorization", "Bearer "+c.token)
+		if c.proof != "" {
+			r.Header.Set(ProofHeaderKey, c.proof)
+		}
+		r.TLS = c.tls
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, c.expCode, w.Code, c.desc)
+	}
+}
+
+func TestBearerMiddlewareExternalURL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	thumbprint, err := KeyThumbprint(&key.PublicKey)
+	assert.Nil(t, err)
+	id := newBoundIdentification(t, &Confirmation{KeyThumbprint: thumbprint})
+	requirement := Requirement{
+		TokenType:  Jwt,
+		Permission: User,
+		Options:    []VerifierOption{WithProofReplayStore(NewMemoryProofReplayStore())},
+	}
+
+	for _, baseURL := range []string{"api.hwsc.test", "ftp://api.hwsc.test", "https://api.hwsc.test/?v=1"} {
+		_, err := BearerMiddleware(StaticSecret(validSecret), requirement, nil, WithExternalURL(baseURL))
+		assert.EqualError(t, err, consts.ErrInvalidExternalURL.Error(), "test for invalid external url "+baseURL)
+	}
+
+	cases := []struct {
+		desc    string
+		opts    []MiddlewareOption
+		expCode int
+	}{
+		{"test for proof of the external url behind a proxy", nil, http.StatusUnauthorized},
+		{"test for proof of the external url", []MiddlewareOption{WithExternalURL("https://api.hwsc.test/")},
+			http.StatusOK},
+	}
+	for _, c := range cases {
+		middleware, err := BearerMiddleware(StaticSecret(validSecret), requirement, nil, c.opts...)
+		assert.Nil(t, err, c.desc)
+		handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		proof, err := NewProof(key, http.MethodGet, "https://api.hwsc.test/user", id.GetToken(), time.Now())
+		assert.Nil(t, err, c.desc)
+		// the proxy terminated TLS and forwards the request over http to an internal host
+		r := httptest.NewRequest(http.MethodGet, "http://user-svc.internal:8080/user", nil)
+		r.Header.Set("Authorization", "Bearer "+id.GetToken())
+		r.Header.Set(ProofHeaderKey, proof)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, c.expCode, w.Code, c.desc)
+	}
+}
+
+func TestPresentationFromIncoming(t *testing.T) {
+	cert := newTestCertificate(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("dpop", "proof"))
+	ctx = peer.NewContext(ctx, &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	p := presentationFromIncoming(ctx, methodUser)
+	assert.Equal(t, "proof", p.Proof)
+	assert.Equal(t, http.MethodPost, p.Method)
+	assert.Equal(t, methodUser, p.URL)
+	assert.Equal(t, []*x509.Certificate{cert}, p.Certificates)
+
+	p = presentationFromIncoming(context.Background(), methodUser)
+	assert.Empty(t, p.Proof)
+	assert.Empty(t, p.Certificates)
+}
@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"math/big"
+	"strings"
+)
+
+// KeyAlgorithm is how the content encryption key of an encrypted token is agreed on.
+type KeyAlgorithm int32
+
+const (
+	// NoKeyAlg default zero value
+	NoKeyAlg KeyAlgorithm = iota
+	// Dir encrypts with a shared 256 bit key directly
+	Dir
+	// RsaOaep256 wraps a random content key with RSA-OAEP using SHA-256
+	RsaOaep256
+	// EcdhEs derives the content key from an ephemeral ECDH key agreement
+	EcdhEs
+)
+
+const (
+	strDir             = "dir"
+	strRsaOaep256      = "RSA-OAEP-256"
+	strEcdhEs          = "ECDH-ES"
+	strA256GCM         = "A256GCM"
+	strNestedJWT       = "JWT"
+	strEC              = "EC"
+	contentKeyByteSize = 32
+	minRSAKeyBitSize   = 2048
+)
+
+var (
+	// KeyAlgorithmStringMap maps enum KeyAlgorithm to its JWE "alg" value
+	KeyAlgorithmStringMap = map[KeyAlgorithm]string{
+		Dir:        strDir,
+		RsaOaep256: strRsaOaep256,
+		EcdhEs:     strEcdhEs,
+	}
+
+	curves = map[string]elliptic.Curve{
+		"P-256": elliptic.P256(),
+		"P-384": elliptic.P384(),
+		"P-521": elliptic.P521(),
+	}
+)
+
+// encryptionHeader is the JWE protected header of an encrypted token.
+type encryptionHeader struct {
+	Alg string        `json:"alg"`
+	Enc string        `json:"enc"`
+	Cty string        `json:"cty"`
+	Epk *ephemeralJWK `json:"epk,omitempty"`
+}
+
+// ephemeralJWK is the public ephemeral key of ECDH-ES.
+type ephemeralJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Encrypter encrypts signed tokens into JWE compact serialization with A256GCM content encryption,
+// so that only the holders of the decryption key can read the claims (sign-then-encrypt).
+type Encrypter struct {
+	alg       KeyAlgorithm
+	sharedKey []byte
+	rsaKey    *rsa.PublicKey
+	ecKey     *ecdsa.PublicKey
+}
+
+// NewDirectEncrypter makes an encrypter with a shared 256 bit key.
+// Returns an error if the key is not 32 bytes.
+func NewDirectEncrypter(key []byte) (*Encrypter, error) {
+	if len(key) != contentKeyByteSize {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Encrypter{
+		alg:       Dir,
+		sharedKey: append([]byte(nil), key...),
+	}, nil
+}
+
+// NewRSAEncrypter makes an encrypter wrapping content keys with the RSA public key.
+// Returns an error if the key is nil or shorter than 2048 bits.
+func NewRSAEncrypter(key *rsa.PublicKey) (*Encrypter, error) {
+	if key == nil || key.N == nil || key.N.BitLen() < minRSAKeyBitSize {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Encrypter{
+		alg:    RsaOaep256,
+		rsaKey: key,
+	}, nil
+}
+
+// NewECDHEncrypter makes an encrypter agreeing on content keys with the EC public key.
+// Returns an error if the key is nil, not on a P-256, P-384 or P-521 curve.
+func NewECDHEncrypter(key *ecdsa.PublicKey) (*Encrypter, error) {
+	if key == nil || key.X == nil || key.Y == nil || curveName(key.Curve) == "" ||
+		!key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Encrypter{
+		alg:   EcdhEs,
+		ecKey: key,
+	}, nil
+}
+
+// Encrypt encrypts the signed token string.
+// Returns the encrypted token, or an error if the token is not a signed token or encryption fails.
+func (e *Encrypter) Encrypt(tokenString string) (string, error) {
+	if len(strings.Split(tokenString, ".")) != 3 {
+		return "", consts.ErrIncompleteToken
+	}
+	header := &encryptionHeader{
+		Alg: KeyAlgorithmStringMap[e.alg],
+		Enc: strA256GCM,
+		Cty: strNestedJWT,
+	}
+	var contentKey, encryptedKey []byte
+	switch e.alg {
+	case Dir:
+		contentKey = e.sharedKey
+	case RsaOaep256:
+		contentKey = make([]byte, contentKeyByteSize)
+		if _, err := cryptorand.Read(contentKey); err != nil {
+			return "", err
+		}
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), cryptorand.Reader, e.rsaKey, contentKey, nil)
+		if err != nil {
+			return "", err
+		}
+		encryptedKey = wrapped
+	case EcdhEs:
+		ephemeral, err := ecdsa.GenerateKey(e.ecKey.Curve, cryptorand.Reader)
+		if err != nil {
+			return "", err
+		}
+		size := curveByteSize(e.ecKey.Curve)
+		header.Epk = &ephemeralJWK{
+			Kty: strEC,
+			Crv: curveName(e.ecKey.Curve),
+			X:   base64.RawURLEncoding.EncodeToString(padBytes(ephemeral.X.Bytes(), size)),
+			Y:   base64.RawURLEncoding.EncodeToString(padBytes(ephemeral.Y.Bytes(), size)),
+		}
+		contentKey = deriveECDHKey(e.ecKey.Curve, e.ecKey.X, e.ecKey.Y, ephemeral.D.Bytes())
+	default:
+		return "", consts.ErrUnknownKeyAlgorithm
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(headerJSON)
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := cryptorand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(tokenString), []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{
+		encodedHeader,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypter decrypts tokens encrypted by an Encrypter with the matching key.
+type Decrypter struct {
+	alg       KeyAlgorithm
+	sharedKey []byte
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+}
+
+// NewDirectDecrypter makes a decrypter with a shared 256 bit key.
+// Returns an error if the key is not 32 bytes.
+func NewDirectDecrypter(key []byte) (*Decrypter, error) {
+	if len(key) != contentKeyByteSize {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Decrypter{
+		alg:       Dir,
+		sharedKey: append([]byte(nil), key...),
+	}, nil
+}
+
+// NewRSADecrypter makes a decrypter unwrapping content keys with the RSA private key.
+// Returns an error if the key is nil or shorter than 2048 bits.
+func NewRSADecrypter(key *rsa.PrivateKey) (*Decrypter, error) {
+	if key == nil || key.N == nil || key.N.BitLen() < minRSAKeyBitSize {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Decrypter{
+		alg:    RsaOaep256,
+		rsaKey: key,
+	}, nil
+}
+
+// NewECDHDecrypter makes a decrypter agreeing on content keys with the EC private key.
+// Returns an error if the key is nil or not on a P-256, P-384 or P-521 curve.
+func NewECDHDecrypter(key *ecdsa.PrivateKey) (*Decrypter, error) {
+	if key == nil || key.D == nil || curveName(key.Curve) == "" {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	return &Decrypter{
+		alg:   EcdhEs,
+		ecKey: key,
+	}, nil
+}
+
+// Decrypt decrypts the encrypted token string.
+// Returns the signed token, which still has to be verified, or an error if decryption fails.
+func (d *Decrypter) Decrypt(encryptedToken string) (string, error) {
+	parts := strings.Split(encryptedToken, ".")
+	if len(parts) != 5 {
+		return "", consts.ErrInvalidEncryptedToken
+	}
+	header, err := decodeEncryptionHeader(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if header.Alg != KeyAlgorithmStringMap[d.alg] || header.Enc != strA256GCM || header.Cty != strNestedJWT {
+		return "", consts.ErrKeyAlgorithmMismatch
+	}
+	var decoded [4][]byte
+	for i, part := range parts[1:] {
+		value, err := base64.RawURLEncoding.Strict().DecodeString(part)
+		if err != nil {
+			return "", consts.ErrInvalidEncryptedToken
+		}
+		decoded[i] = value
+	}
+	encryptedKey, iv, ciphertext, tag := decoded[0], decoded[1], decoded[2], decoded[3]
+
+	var contentKey []byte
+	switch d.alg {
+	case Dir:
+		if len(encryptedKey) != 0 || header.Epk != nil {
+			return "", consts.ErrInvalidEncryptedToken
+		}
+		contentKey = d.sharedKey
+	case RsaOaep256:
+		if header.Epk != nil {
+			return "", consts.ErrInvalidEncryptedToken
+		}
+		unwrapped, err := rsa.DecryptOAEP(sha256.New(), nil, d.rsaKey, encryptedKey, nil)
+		if err != nil || len(unwrapped) != contentKeyByteSize {
+			return "", consts.ErrDecryptionFailed
+		}
+		contentKey = unwrapped
+	case EcdhEs:
+		if len(encryptedKey) != 0 || header.Epk == nil {
+			return "", consts.ErrInvalidEncryptedToken
+		}
+		x, y, err := decodeEphemeralKey(header.Epk, d.ecKey.Curve)
+		if err != nil {
+			return "", err
+		}
+		contentKey = deriveECDHKey(d.ecKey.Curve, x, y, d.ecKey.D.Bytes())
+	default:
+		return "", consts.ErrUnknownKeyAlgorithm
+	}
+
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return "", consts.ErrInvalidEncryptedToken
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", consts.ErrDecryptionFailed
+	}
+	return string(plaintext), nil
+}
+
+// isEncryptedToken checks if the token string has the five parts of an encrypted token.
+func isEncryptedToken(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 4
+}
+
+// decodeEncryptionHeader parses the protected header strictly,
+// rejecting duplicate and unknown keys like decodeHeader.
+func decodeEncryptionHeader(encodedHeader string) (*encryptionHeader, error) {
+	data, err := base64.RawURLEncoding.Strict().DecodeString(encodedHeader)
+	if err != nil {
+		return nil, consts.ErrInvalidEncryptedToken
+	}
+	if err := checkDuplicateKeys(data); err != nil {
+		return nil, err
+	}
+	header := &encryptionHeader{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(header); err != nil || decoder.More() {
+		return nil, consts.ErrInvalidEncryptedToken
+	}
+	return header, nil
+}
+
+// decodeEphemeralKey parses the ephemeral public key, which must be on the curve of the private key
+// to prevent invalid curve attacks.
+func decodeEphemeralKey(epk *ephemeralJWK, curve elliptic.Curve) (*big.Int, *big.Int, error) {
+	if epk.Kty != strEC || epk.Crv != curveName(curve) {
+		return nil, nil, consts.ErrInvalidEncryptedToken
+	}
+	size := curveByteSize(curve)
+	xBytes, err := base64.RawURLEncoding.Strict().DecodeString(epk.X)
+	if err != nil || len(xBytes) != size {
+		return nil, nil, consts.ErrInvalidEncryptedToken
+	}
+	yBytes, err := base64.RawURLEncoding.Strict().DecodeString(epk.Y)
+	if err != nil || len(yBytes) != size {
+		return nil, nil, consts.ErrInvalidEncryptedToken
+	}
+	x, y := new(big.Int).SetBytes(xBytes), new(big.Int).SetBytes(yBytes)
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, consts.ErrInvalidEncryptedToken
+	}
+	return x, y, nil
+}
+
+// deriveECDHKey computes the shared secret of the public point and private scalar,
+// and derives the A256GCM content key from it with the Concat KDF of RFC 7518 section 4.6.
+func deriveECDHKey(curve elliptic.Curve, x *big.Int, y *big.Int, scalar []byte) []byte {
+	sharedX, _ := curve.ScalarMult(x, y, scalar)
+	z := padBytes(sharedX.Bytes(), curveByteSize(curve))
+
+	otherInfo := &bytes.Buffer{}
+	writeLengthPrefixed(otherInfo, []byte(strA256GCM))
+	writeLengthPrefixed(otherInfo, nil)
+	writeLengthPrefixed(otherInfo, nil)
+	_ = binary.Write(otherInfo, binary.BigEndian, uint32(contentKeyByteSize*8))
+
+	// a single round of SHA-256 yields the 256 bits of the content key
+	hash := sha256.New()
+	_ = binary.Write(hash, binary.BigEndian, uint32(1))
+	hash.Write(z)
+	hash.Write(otherInfo.Bytes())
+	return hash.Sum(nil)
+}
+
+// writeLengthPrefixed writes the value prefixed with its 32 bit big endian length.
+func writeLengthPrefixed(buffer *bytes.Buffer, value []byte) {
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(value)))
+	buffer.Write(value)
+}
+
+// newGCM makes the A256GCM cipher of the content key.
+func newGCM(contentKey []byte) (cipher.AEAD, error) {
+	if len(contentKey) != contentKeyByteSize {
+		return nil, consts.ErrInvalidEncryptionKey
+	}
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// curveName returns the JWK name of the curve, or an empty string if not supported.
+func curveName(curve elliptic.Curve) string {
+	if curve == nil {
+		return ""
+	}
+	for name, c := range curves {
+		if c.Params().Name == curve.Params().Name {
+			return name
+		}
+	}
+	return ""
+}
+
+// curveByteSize returns the number of bytes of a coordinate on the curve.
+func curveByteSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+// padBytes left pads the big endian value with zeros to size bytes.
+func padBytes(value []byte, size int) []byte {
+	if len(value) >= size {
+		return value
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(value):], value)
+	return padded
+}
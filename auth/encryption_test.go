@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var (
+	testSharedKey = []byte("0123456789abcdef0123456789abcdef")
+)
+
+// testEncryptionPair is an encrypter and the decrypter of the matching key.
+type testEncryptionPair struct {
+	desc      string
+	encrypter *Encrypter
+	decrypter *Decrypter
+}
+
+// newTestEncryptionPairs makes a pair for every key algorithm.
+func newTestEncryptionPairs(t *testing.T) []testEncryptionPair {
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+
+	directEncrypter, err := NewDirectEncrypter(testSharedKey)
+	assert.Nil(t, err)
+	directDecrypter, err := NewDirectDecrypter(testSharedKey)
+	assert.Nil(t, err)
+	rsaEncrypter, err := NewRSAEncrypter(&rsaKey.PublicKey)
+	assert.Nil(t, err)
+	rsaDecrypter, err := NewRSADecrypter(rsaKey)
+	assert.Nil(t, err)
+	ecEncrypter, err := NewECDHEncrypter(&ecKey.PublicKey)
+	assert.Nil(t, err)
+	ecDecrypter, err := NewECDHDecrypter(ecKey)
+	assert.Nil(t, err)
+	return []testEncryptionPair{
+		{"test for dir", directEncrypter, directDecrypter},
+		{"test for RSA-OAEP-256", rsaEncrypter, rsaDecrypter},
+		{"test for ECDH-ES", ecEncrypter, ecDecrypter},
+	}
+}
+
+func TestNewEncrypter(t *testing.T) {
+	smallRSAKey, err := rsa.GenerateKey(cryptorand.Reader, 1024)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	offCurveKey := ecKey.PublicKey
+	offCurveKey.X = offCurveKey.X.Add(offCurveKey.X, offCurveKey.X)
+
+	_, err = NewDirectEncrypter([]byte("too short"))
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for short shared key")
+	_, err = NewDirectDecrypter(nil)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for nil shared key")
+	_, err = NewRSAEncrypter(nil)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for nil rsa key")
+	_, err = NewRSAEncrypter(&smallRSAKey.PublicKey)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for small rsa public key")
+	_, err = NewRSADecrypter(smallRSAKey)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for small rsa private key")
+	_, err = NewECDHEncrypter(nil)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for nil ec key")
+	_, err = NewECDHEncrypter(&offCurveKey)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for point off the curve")
+	_, err = NewECDHDecrypter(nil)
+	assert.EqualError(t, err, consts.ErrInvalidEncryptionKey.Error(), "test for nil ec private key")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	token := validUserIdentification.GetToken()
+	for _, c := range newTestEncryptionPairs(t) {
+		encrypted, err := c.encrypter.Encrypt(token)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, 5, len(strings.Split(encrypted, ".")), c.desc)
+		assert.NotContains(t, encrypted, strings.Split(token, ".")[1], c.desc)
+
+		decrypted, err := c.decrypter.Decrypt(encrypted)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, token, decrypted, c.desc)
+
+		// every encryption uses a fresh iv
+		again, err := c.encrypter.Encrypt(token)
+		assert.Nil(t, err, c.desc)
+		assert.NotEqual(t, encrypted, again, c.desc)
+	}
+
+	_, err := newTestEncryptionPairs(t)[0].encrypter.Encrypt("not a token")
+	assert.EqualError(t, err, consts.ErrIncompleteToken.Error(), "test for unsigned plaintext")
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	pairs := newTestEncryptionPairs(t)
+	token := validUserIdentification.GetToken()
+	encrypted, err := pairs[0].encrypter.Encrypt(token)
+	assert.Nil(t, err)
+	parts := strings.Split(encrypted, ".")
+	otherDecrypter, err := NewDirectDecrypter([]byte("fedcba9876543210fedcba9876543210"))
+	assert.Nil(t, err)
+
+	tampered := func(index int) string {
+		altered := append([]string(nil), parts...)
+		data, _ := base64.RawURLEncoding.DecodeString(altered[index])
+		data[0] ^= 1
+		altered[index] = base64.RawURLEncoding.EncodeToString(data)
+		return strings.Join(altered, ".")
+	}
+	withHeader := func(header string) string {
+		altered := append([]string(nil), parts...)
+		altered[0] = base64.RawURLEncoding.EncodeToString([]byte(header))
+		return strings.Join(altered, ".")
+	}
+
+	cases := []struct {
+		desc      string
+		decrypter *Decrypter
+		encrypted string
+		expErr    error
+	}{
+		{"test for signed token", pairs[0].decrypter, token, consts.ErrInvalidEncryptedToken},
+		{"test for wrong key", otherDecrypter, encrypted, consts.ErrDecryptionFailed},
+		{"test for other algorithm", pairs[1].decrypter, encrypted, consts.ErrKeyAlgorithmMismatch},
+		{"test for tampered iv", pairs[0].decrypter, tampered(2), consts.ErrDecryptionFailed},
+		{"test for tampered ciphertext", pairs[0].decrypter, tampered(3), consts.ErrDecryptionFailed},
+		{"test for tampered tag", pairs[0].decrypter, tampered(4), consts.ErrDecryptionFailed},
+		{"test for tampered header", pairs[0].decrypter,
+			withHeader(`{"alg":"dir","enc":"A256GCM","cty":"JWT" }`), consts.ErrDecryptionFailed},
+		{"test for unknown header field", pairs[0].decrypter,
+			withHeader(`{"alg":"dir","enc":"A256GCM","cty":"JWT","zip":"DEF"}`), consts.ErrInvalidEncryptedToken},
+		{"test for duplicate header key", pairs[0].decrypter,
+			withHeader(`{"alg":"dir","alg":"dir","enc":"A256GCM","cty":"JWT"}`), consts.ErrDuplicateJSONKey},
+		{"test for other content encryption", pairs[0].decrypter,
+			withHeader(`{"alg":"dir","enc":"A128GCM","cty":"JWT"}`), consts.ErrKeyAlgorithmMismatch},
+		{"test for encrypted key with dir", pairs[0].decrypter,
+			parts[0] + ".AAAA." + strings.Join(parts[2:], "."), consts.ErrInvalidEncryptedToken},
+		{"test for invalid encoding", pairs[0].decrypter,
+			strings.Join(parts[:4], ".") + ".!!!", consts.ErrInvalidEncryptedToken},
+	}
+	for _, c := range cases {
+		decrypted, err := c.decrypter.Decrypt(c.encrypted)
+		assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		assert.Empty(t, decrypted, c.desc)
+	}
+}
+
+func TestDecryptInvalidEphemeralKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	decrypter, err := NewECDHDecrypter(ecKey)
+	assert.Nil(t, err)
+	x := base64.RawURLEncoding.EncodeToString(padBytes(ecKey.X.Bytes(), 32))
+	y := base64.RawURLEncoding.EncodeToString(padBytes(ecKey.Y.Bytes(), 32))
+	offCurveY := base64.RawURLEncoding.EncodeToString(padBytes([]byte{1}, 32))
+	rest := "..AAAAAAAAAAAAAAAA.AAAA.AAAAAAAAAAAAAAAAAAAAAA"
+
+	cases := []struct {
+		desc   string
+		header string
+	}{
+		{"test for missing ephemeral key", `{"alg":"ECDH-ES","enc":"A256GCM","cty":"JWT"}`},
+		{"test for other curve", `{"alg":"ECDH-ES","enc":"A256GCM","cty":"JWT","epk":{"kty":"EC","crv":"P-384","x":"` +
+			x + `","y":"` + y + `"}}`},
+		{"test for point off the curve", `{"alg":"ECDH-ES","enc":"A256GCM","cty":"JWT","epk":{"kty":"EC","crv":"P-256","x":"` +
+			x + `","y":"` + offCurveY + `"}}`},
+	}
+	for _, c := range cases {
+		encrypted := base64.RawURLEncoding.EncodeToString([]byte(c.header)) + rest
+		_, err := decrypter.Decrypt(encrypted)
+		assert.EqualError(t, err, consts.ErrInvalidEncryptedToken.Error(), c.desc)
+	}
+}
+
+func TestVerifyEncrypted(t *testing.T) {
+	for _, pair := range newTestEncryptionPairs(t) {
+		encrypted, err := pair.encrypter.Encrypt(validUserIdentification.GetToken())
+		assert.Nil(t, err, pair.desc)
+		encryptedID := &pbauth.Identification{
+			Token:  encrypted,
+			Secret: validSecret,
+		}
+
+		claims, err := NewVerifier(Jwt, User, WithDecrypter(pair.decrypter)).Verify(encryptedID)
+		assert.Nil(t, err, pair.desc)
+		assert.Equal(t, validUserBody.UUID, claims.Body().UUID, pair.desc)
+
+		// plain signed tokens are rejected once encryption is required
+		_, err = NewVerifier(Jwt, User, WithDecrypter(pair.decrypter)).Verify(validUserIdentification)
+		assert.EqualError(t, err, consts.ErrTokenNotEncrypted.Error(), pair.desc)
+
+		// encrypted tokens cannot be verified without decrypting
+		_, err = NewVerifier(Jwt, User).Verify(encryptedID)
+		assert.EqualError(t, err, consts.ErrIncompleteToken.Error(), pair.desc)
+
+		// claims are still validated after decrypting
+		_, err = NewVerifier(Jwt, Admin, WithDecrypter(pair.decrypter)).Verify(encryptedID)
+		assert.EqualError(t, err, consts.ErrInvalidPermission.Error(), pair.desc)
+
+		// the authority decrypts before validating
+		authority := NewAuthority(Jwt, User, WithDecrypter(pair.decrypter))
+		assert.Nil(t, authority.Authorize(encryptedID), pair.desc)
+	}
+}
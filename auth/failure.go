@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFailures is how many failures within DefaultFailureWindow lock a key out
+	DefaultMaxFailures = 5
+	// DefaultFailureWindow is the sliding window failures are counted in
+	DefaultFailureWindow = 15 * time.Minute
+	// DefaultLockout is how long the first lockout of a key lasts, consecutive lockouts double it
+	DefaultLockout = time.Minute
+	// DefaultMaxLockout caps the duration of a lockout
+	DefaultMaxLockout = time.Hour
+
+	ipFailureKeyPrefix   = "ip:"
+	uuidFailureKeyPrefix = "uuid:"
+)
+
+// FailureRecord is the state of a key tracked by a FailureTracker.
+// Failures are the times of the failures within the sliding window,
+// Lockouts counts the consecutive lockouts and LockedUntil is when the last lockout ends.
+type FailureRecord struct {
+	Failures    []time.Time
+	Lockouts    int
+	LockedUntil time.Time
+}
+
+// isZero checks if the record holds no state.
+func (r *FailureRecord) isZero() bool {
+	return len(r.Failures) == 0 && r.Lockouts == 0 && r.LockedUntil.IsZero()
+}
+
+// FailureStore keeps the failure records of a FailureTracker.
+type FailureStore interface {
+	// Get returns a copy of the record of key, or nil if the key has no record.
+	Get(key string) (*FailureRecord, error)
+	// Update applies update to a copy of the record of key, or to an empty record if none,
+	// and saves the result atomically. Records left empty are removed.
+	Update(key string, update func(record *FailureRecord)) error
+	// Delete removes the record of key.
+	Delete(key string) error
+}
+
+// memoryFailureStore is an in memory FailureStore.
+type memoryFailureStore struct {
+	locker  sync.Mutex
+	records map[string]*FailureRecord
+}
+
+// NewMemoryFailureStore makes an in memory FailureStore.
+// Records are lost when the process exits, and are not shared between replicas of a service.
+func NewMemoryFailureStore() FailureStore {
+	return &memoryFailureStore{
+		records: make(map[string]*FailureRecord),
+	}
+}
+
+// Get implements FailureStore.
+func (s *memoryFailureStore) Get(key string) (*FailureRecord, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyFailureRecord(record), nil
+}
+
+// Update implements FailureStore.
+func (s *memoryFailureStore) Update(key string, update func(record *FailureRecord)) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	record := &FailureRecord{}
+	if stored, ok := s.records[key]; ok {
+		record = copyFailureRecord(stored)
+	}
+	update(record)
+	if record.isZero() {
+		delete(s.records, key)
+		return nil
+	}
+	s.records[key] = record
+	return nil
+}
+
+// Delete implements FailureStore.
+func (s *memoryFailureStore) Delete(key string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// copyFailureRecord makes a deep copy of the record.
+func copyFailureRecord(record *FailureRecord) *FailureRecord {
+	return &FailureRecord{
+		Failures:    append([]time.Time(nil), record.Failures...),
+		Lockouts:    record.Lockouts,
+		LockedUntil: record.LockedUntil,
+	}
+}
+
+// FailureOption configures a FailureTracker.
+type FailureOption func(*FailureTracker)
+
+// WithFailureClock makes the failure tracker read the current time from the clock.
+func WithFailureClock(clock Clock) FailureOption {
+	return func(f *FailureTracker) {
+		f.clock = clock
+	}
+}
+
+// WithFailureLimit makes the failure tracker lock a key out after maxFailures failures within window.
+func WithFailureLimit(maxFailures int, window time.Duration) FailureOption {
+	return func(f *FailureTracker) {
+		f.maxFailures = maxFailures
+		f.window = window
+	}
+}
+
+// WithLockout makes the failure tracker lock keys out for lockout, doubling for every consecutive lockout
+// up to maxLockout.
+func WithLockout(lockout time.Duration, maxLockout time.Duration) FailureOption {
+	return func(f *FailureTracker) {
+		f.lockout = lockout
+		f.maxLockout = maxLockout
+	}
+}
+
+// FailureTracker counts verification failures per key, ie: a client IP or user UUID,
+// in a sliding window, and locks keys out with an exponential backoff when they fail too often.
+// Lockouts are consecutive while a key fails again within the window after its last lockout ended.
+// Check and Failure are not atomic together: concurrent attempts checked before a failure is recorded
+// are not locked out, so a burst can exceed the limit by the number of attempts in flight.
+type FailureTracker struct {
+	store       FailureStore
+	clock       Clock
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	maxLockout  time.Duration
+}
+
+// NewFailureTracker makes a failure tracker keeping its records in the store.
+// Returns an error if the store is nil or the limits are not positive.
+func NewFailureTracker(store FailureStore, opts ...FailureOption) (*FailureTracker, error) {
+	f := &FailureTracker{
+		store:       store,
+		clock:       SystemClock,
+		maxFailures: DefaultMaxFailures,
+		window:      DefaultFailureWindow,
+		lockout:     DefaultLockout,
+		maxLockout:  DefaultMaxLockout,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if store == nil {
+		return nil, consts.ErrNilFailureStore
+	}
+	if f.maxFailures < 1 || f.window <= 0 {
+		return nil, consts.ErrInvalidFailureLimit
+	}
+	if f.lockout <= 0 || f.maxLockout < f.lockout {
+		return nil, consts.ErrInvalidLockout
+	}
+	return f, nil
+}
+
+// Check checks that none of the keys is locked out.
+// Returns consts.ErrLockedOut if one is, or the error of the store.
+func (f *FailureTracker) Check(keys ...string) error {
+	now := f.clock.Now()
+	for _, key := range keys {
+		record, err := f.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if record != nil && now.Before(record.LockedUntil) {
+			return consts.ErrLockedOut
+		}
+	}
+	return nil
+}
+
+// Failure records a failure of the keys, locking out the keys that failed too often.
+// Failures of a locked out key are not counted, so that retrying does not extend the lockout.
+func (f *FailureTracker) Failure(keys ...string) error {
+	now := f.clock.Now()
+	for _, key := range keys {
+		if err := f.store.Update(key, func(record *FailureRecord) {
+			f.fail(record, now)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Success clears the failures and lockouts of the keys.
+func (f *FailureTracker) Success(keys ...string) error {
+	for _, key := range keys {
+		if err := f.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail counts a failure at now in the record.
+func (f *FailureTracker) fail(record *FailureRecord, now time.Time) {
+	if now.Before(record.LockedUntil) {
+		return
+	}
+	if !record.LockedUntil.IsZero() && now.Sub(record.LockedUntil) > f.window {
+		record.Lockouts = 0
+		record.LockedUntil = time.Time{}
+	}
+	start := now.Add(-f.window)
+	failures := record.Failures[:0]
+	for _, failure := range record.Failures {
+		if failure.After(start) {
+			failures = append(failures, failure)
+		}
+	}
+	record.Failures = append(failures, now)
+	if len(record.Failures) < f.maxFailures {
+		return
+	}
+	record.Lockouts++
+	lockout := f.lockout
+	for i := 1; i < record.Lockouts && lockout < f.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > f.maxLockout {
+		lockout = f.maxLockout
+	}
+	record.LockedUntil = now.Add(lockout)
+	record.Failures = nil
+}
+
+// isTrackedFailure checks if the verification error hints at forged, tampered or replayed tokens.
+// Expired tokens are not tracked: honest clients retry with them until they refresh.
+func isTrackedFailure(err error) bool {
+	switch err {
+	case consts.ErrInvalidSignature, consts.ErrIncompleteToken,
+		consts.ErrInvalidEncodedHeader, consts.ErrInvalidEncodedBody, consts.ErrDuplicateJSONKey,
+		consts.ErrUnknownHeaderField, consts.ErrDecryptionFailed, consts.ErrInvalidProof, consts.ErrProofMismatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// failureKeys returns the keys a verification is tracked by:
+// the given keys, the IP of the client if known, and the UUID claimed by the token if enabled.
+func (v *Verifier) failureKeys(token string, p *Presentation) []string {
+	keys := append([]string(nil), v.trackedKeys...)
+	if p != nil && p.RemoteAddr != "" {
+		host, _, err := net.SplitHostPort(p.RemoteAddr)
+		if err != nil {
+			host = p.RemoteAddr
+		}
+		keys = append(keys, ipFailureKeyPrefix+host)
+	}
+	if v.trackUUID {
+		if _, body, err := DecodeToken(token); err == nil && validation.ValidateUserUUID(body.UUID) == nil {
+			keys = append(keys, uuidFailureKeyPrefix+body.UUID)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// forgedIdentification is the token of validUserIdentification verified with another secret.
+func forgedIdentification(t *testing.T) *pbauth.Identification {
+	key, err := GenerateSecretKey(SecretByteSize)
+	assert.Nil(t, err)
+	return &pbauth.Identification{
+		Token: validUserIdentification.GetToken(),
+		Secret: &pbauth.Secret{
+			Key:                 key,
+			CreatedTimestamp:    validSecret.GetCreatedTimestamp(),
+			ExpirationTimestamp: validSecret.GetExpirationTimestamp(),
+		},
+	}
+}
+
+func TestNewFailureTracker(t *testing.T) {
+	cases := []struct {
+		desc     string
+		store    FailureStore
+		opts     []FailureOption
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil store", nil, nil, true, consts.ErrNilFailureStore},
+		{"test for nil clock", NewMemoryFailureStore(), []FailureOption{WithFailureClock(nil)}, true, consts.ErrNilClock},
+		{"test for zero failures", NewMemoryFailureStore(), []FailureOption{WithFailureLimit(0, time.Minute)},
+			true, consts.ErrInvalidFailureLimit},
+		{"test for zero window", NewMemoryFailureStore(), []FailureOption{WithFailureLimit(3, 0)},
+			true, consts.ErrInvalidFailureLimit},
+		{"test for zero lockout", NewMemoryFailureStore(), []FailureOption{WithLockout(0, time.Hour)},
+			true, consts.ErrInvalidLockout},
+		{"test for max lockout below lockout", NewMemoryFailureStore(),
+			[]FailureOption{WithLockout(time.Hour, time.Minute)}, true, consts.ErrInvalidLockout},
+		{"test for valid input", NewMemoryFailureStore(), nil, false, nil},
+	}
+	for _, c := range cases {
+		tracker, err := NewFailureTracker(c.store, c.opts...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, tracker, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, tracker, c.desc)
+		}
+	}
+}
+
+func TestFailureTracker(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	store := NewMemoryFailureStore()
+	tracker, err := NewFailureTracker(store, WithFailureClock(clock), WithFailureLimit(3, 10*time.Minute),
+		WithLockout(time.Minute, 3*time.Minute))
+	assert.Nil(t, err)
+	const key = "ip:10.0.0.1"
+	fail := func(times int) {
+		for i := 0; i < times; i++ {
+			assert.Nil(t, tracker.Failure(key))
+		}
+	}
+
+	fail(2)
+	assert.Nil(t, tracker.Check(key), "test for failures below the limit")
+	clock.Advance(11 * time.Minute)
+	fail(2)
+	assert.Nil(t, tracker.Check(key), "test for failures sliding out of the window")
+	fail(1)
+	assert.EqualError(t, tracker.Check(key), consts.ErrLockedOut.Error(), "test for failures reaching the limit")
+	assert.EqualError(t, tracker.Check("ip:10.0.0.2", key), consts.ErrLockedOut.Error(), "test for any key locked")
+	assert.Nil(t, tracker.Check("ip:10.0.0.2"), "test for other key")
+
+	fail(5)
+	clock.Advance(time.Minute)
+	assert.Nil(t, tracker.Check(key), "test for lockout ending despite failures while locked out")
+
+	cases := []struct {
+		desc       string
+		expLockout time.Duration
+	}{
+		{"test for second lockout doubling", 2 * time.Minute},
+		{"test for third lockout capped", 3 * time.Minute},
+	}
+	for _, c := range cases {
+		fail(3)
+		record, err := store.Get(key)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, clock.Now().Add(c.expLockout), record.LockedUntil, c.desc)
+		clock.Advance(c.expLockout)
+	}
+
+	desc := "test for lockouts reset after a quiet window"
+	clock.Advance(11 * time.Minute)
+	fail(3)
+	record, err := store.Get(key)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, 1, record.Lockouts, desc)
+	assert.Equal(t, clock.Now().Add(time.Minute), record.LockedUntil, desc)
+
+	desc = "test for success clearing the key"
+	assert.Nil(t, tracker.Success(key), desc)
+	assert.Nil(t, tracker.Check(key), desc)
+	record, err = store.Get(key)
+	assert.Nil(t, err, desc)
+	assert.Nil(t, record, desc)
+}
+
+func TestVerifyWithFailureTracker(t *testing.T) {
+	tracker, err := NewFailureTracker(NewMemoryFailureStore(), WithFailureLimit(2, time.Minute))
+	assert.Nil(t, err)
+	forged := forgedIdentification(t)
+	attacker := &Presentation{RemoteAddr: "10.0.0.1:5000"}
+	verifier := NewVerifier(Jwt, User, WithFailureTracker(tracker))
+
+	for i := 0; i < 2; i++ {
+		_, err := verifier.VerifyPresentation(forged, attacker)
+		assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), "test for forged token")
+	}
+	_, err = verifier.VerifyPresentation(validUserIdentification, attacker)
+	assert.EqualError(t, err, consts.ErrLockedOut.Error(), "test for locked out client")
+	_, err = verifier.VerifyPresentation(validUserIdentification, &Presentation{RemoteAddr: "10.0.0.2:5000"})
+	assert.Nil(t, err, "test for other client")
+	_, err = verifier.Verify(validUserIdentification)
+	assert.Nil(t, err, "test for unknown client")
+
+	desc := "test for valid tokens not clearing the client"
+	client := &Presentation{RemoteAddr: "10.0.0.7:5000"}
+	_, err = verifier.VerifyPresentation(forged, client)
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(validUserIdentification, client)
+	assert.Nil(t, err, desc)
+	_, err = verifier.VerifyPresentation(forged, client)
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(validUserIdentification, client)
+	assert.EqualError(t, err, consts.ErrLockedOut.Error(), desc)
+
+	desc = "test for expired tokens not tracked"
+	now := time.Now()
+	expired, err := newToken(&Header{Alg: Hs256, TokenTyp: Jwt}, &Body{
+		UUID:                testUserUUID,
+		Permission:          User,
+		ExpirationTimestamp: now.Add(time.Hour).Unix(),
+	}, validSecret, now)
+	assert.Nil(t, err, desc)
+	later := NewVerifier(Jwt, User, WithFailureTracker(tracker), WithClock(NewFakeClock(now.Add(2*time.Hour))))
+	for i := 0; i < 3; i++ {
+		_, err := later.VerifyPresentation(&pbauth.Identification{Token: expired, Secret: validSecret},
+			&Presentation{RemoteAddr: "10.0.0.12:5000"})
+		assert.EqualError(t, err, consts.ErrExpiredBody.Error(), desc)
+	}
+
+	desc = "test for authorization errors not tracked"
+	admin := NewVerifier(Jwt, Admin, WithFailureTracker(tracker))
+	for i := 0; i < 3; i++ {
+		_, err := admin.VerifyPresentation(validUserIdentification, &Presentation{RemoteAddr: "10.0.0.3:5000"})
+		assert.EqualError(t, err, consts.ErrInvalidPermission.Error(), desc)
+	}
+
+	desc = "test for uuid tracked across clients"
+	verifier = NewVerifier(Jwt, User, WithFailureTracker(tracker), WithUUIDFailureTracking())
+	_, err = verifier.VerifyPresentation(forged, &Presentation{RemoteAddr: "10.0.0.4:5000"})
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(forged, &Presentation{RemoteAddr: "10.0.0.5:5000"})
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(validUserIdentification, &Presentation{RemoteAddr: "10.0.0.6:5000"})
+	assert.EqualError(t, err, consts.ErrLockedOut.Error(), desc)
+
+	desc = "test for success clearing the uuid"
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	uuidTracker, err := NewFailureTracker(NewMemoryFailureStore(), WithFailureClock(clock),
+		WithFailureLimit(2, time.Minute))
+	assert.Nil(t, err, desc)
+	verifier = NewVerifier(Jwt, User, WithFailureTracker(uuidTracker), WithUUIDFailureTracking())
+	_, err = verifier.VerifyPresentation(forged, &Presentation{RemoteAddr: "10.0.0.8:5000"})
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(validUserIdentification, &Presentation{RemoteAddr: "10.0.0.9:5000"})
+	assert.Nil(t, err, desc)
+	_, err = verifier.VerifyPresentation(forged, &Presentation{RemoteAddr: "10.0.0.10:5000"})
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+	_, err = verifier.VerifyPresentation(validUserIdentification, &Presentation{RemoteAddr: "10.0.0.11:5000"})
+	assert.Nil(t, err, desc)
+
+	desc = "test for authority keyed by caller"
+	authority := NewAuthority(Jwt, User, WithFailureTracker(tracker, "ip:10.0.0.1"))
+	assert.EqualError(t, authority.Authorize(validUserIdentification), consts.ErrLockedOut.Error(), desc)
+
+	desc = "test for interceptor status"
+	assert.Equal(t, codes.ResourceExhausted, status.Code(statusFromError(consts.ErrLockedOut)), desc)
+}
+
+func TestBearerMiddlewareLockout(t *testing.T) {
+	tracker, err := NewFailureTracker(NewMemoryFailureStore(), WithFailureLimit(1, time.Minute))
+	assert.Nil(t, err)
+	middleware, err := BearerMiddleware(StaticSecret(validSecret), Requirement{
+		TokenType:  Jwt,
+		Permission: User,
+		Options:    []VerifierOption{WithFailureTracker(tracker)},
+	}, nil)
+	assert.Nil(t, err)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/documents", nil)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(fakeToken), "test for forged token")
+	assert.Equal(t, http.StatusTooManyRequests, serve(valid256JWTUserTokenString), "test for locked out client")
+}
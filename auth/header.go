@@ -22,6 +22,14 @@ const (
 	Jwt
 	// Jet JSON Email Token
 	Jet
+	// Jrt JSON Refresh Token
+	Jrt
+	// Jpt JSON Password reset Token
+	Jpt
+	// Jct JSON email Change Token
+	Jct
+	// Jmt JSON Magic link Token for passwordless login
+	Jmt
 )
 
 // Header contains the algorithm and token type used to sign the token.
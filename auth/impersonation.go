@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"fmt"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/logger"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"strings"
+	"time"
+)
+
+const (
+	// maxActorChainLength bounds the actors a token can carry, regardless of the impersonation policy
+	maxActorChainLength = 8
+)
+
+var (
+	// DefaultImpersonationPolicy lets Admin users act as users of lower permissions for an hour,
+	// without delegating further. Services may not impersonate.
+	DefaultImpersonationPolicy = &ImpersonationPolicy{
+		permissions: map[PrincipalType]map[Permission]map[Permission]bool{
+			UserPrincipal: {
+				Admin: {NoPermission: true, UserRegistration: true, User: true},
+			},
+		},
+		maxChain: 1,
+		lifetime: time.Hour,
+	}
+)
+
+// Actor is the principal acting as the user or service of a token, the act claim of RFC 8693.
+// Actor is the previous actor of a delegation chain, the outermost actor is the one acting now.
+type Actor struct {
+	Principal  PrincipalType `json:",omitempty"`
+	UUID       string        `json:",omitempty"`
+	Service    string        `json:",omitempty"`
+	Permission Permission
+	Actor      *Actor `json:",omitempty"`
+}
+
+// String describes the actor and the previous actors, ie: for audit logs.
+func (a *Actor) String() string {
+	var actors []string
+	for actor := a; actor != nil; actor = actor.Actor {
+		actors = append(actors, describePrincipal(actor.Principal, actor.UUID, actor.Service, actor.Permission))
+	}
+	return strings.Join(actors, " delegated by ")
+}
+
+// Actor extracts a copy of the verified actor, or nil if no one is acting as the principal of the token.
+func (c *Claims) Actor() *Actor {
+	return copyActor(c.body.Actor)
+}
+
+// ImpersonationConfig describes who may act as whom.
+// Permissions maps the permission of a user actor to the permissions of the principals it may act as,
+// and ServicePermissions does the same for service actors, so that a service token is never mistaken for a user's.
+// MaxChain is how many actors a token may carry, 1 forbids delegating an impersonation further.
+// Lifetime is how long impersonation tokens are valid, they never outlive the token of the actor.
+type ImpersonationConfig struct {
+	Permissions        map[Permission][]Permission
+	ServicePermissions map[Permission][]Permission
+	MaxChain           int
+	Lifetime           time.Duration
+}
+
+// ImpersonationPolicy controls which principal types and permissions may impersonate which permissions.
+// Every link of a delegation chain is checked against the principal it directly acts as.
+type ImpersonationPolicy struct {
+	permissions map[PrincipalType]map[Permission]map[Permission]bool
+	maxChain    int
+	lifetime    time.Duration
+}
+
+// NewImpersonationPolicy makes an impersonation policy from the config.
+// Returns an error if a permission is unknown, or the chain or lifetime are not positive.
+func NewImpersonationPolicy(config *ImpersonationConfig) (*ImpersonationPolicy, error) {
+	if config == nil {
+		return nil, consts.ErrNilImpersonationConfig
+	}
+	if config.MaxChain < 1 || config.MaxChain > maxActorChainLength {
+		return nil, consts.ErrInvalidImpersonationPolicy
+	}
+	if config.Lifetime < time.Second {
+		return nil, consts.ErrInvalidLifetime
+	}
+	permissions := make(map[PrincipalType]map[Permission]map[Permission]bool)
+	for principal, actors := range map[PrincipalType]map[Permission][]Permission{
+		UserPrincipal:    config.Permissions,
+		ServicePrincipal: config.ServicePermissions,
+	} {
+		permissions[principal] = make(map[Permission]map[Permission]bool)
+		for actor, subjects := range actors {
+			if actor < NoPermission || actor > Admin {
+				return nil, consts.ErrUnknownPermission
+			}
+			permissions[principal][actor] = make(map[Permission]bool)
+			for _, subject := range subjects {
+				if subject < NoPermission || subject > Admin {
+					return nil, consts.ErrUnknownPermission
+				}
+				permissions[principal][actor][subject] = true
+			}
+		}
+	}
+	return &ImpersonationPolicy{
+		permissions: permissions,
+		maxChain:    config.MaxChain,
+		lifetime:    config.Lifetime,
+	}, nil
+}
+
+// Authorize checks that every actor of the body may act as the principal it directly acts as:
+// the outermost actor as the body, and every previous actor as the actor it delegated to.
+// Bodies without actors are always authorized.
+// Returns consts.ErrImpersonationNotAllowed otherwise.
+func (p *ImpersonationPolicy) Authorize(body *Body) error {
+	depth := 0
+	subject := body.Permission
+	for actor := body.Actor; actor != nil; actor = actor.Actor {
+		depth++
+		if depth > p.maxChain || !p.permissions[actor.Principal][actor.Permission][subject] {
+			return consts.ErrImpersonationNotAllowed
+		}
+		subject = actor.Permission
+	}
+	return nil
+}
+
+// WithIssuerImpersonationPolicy makes the issuer authorize impersonation tokens with the policy.
+func WithIssuerImpersonationPolicy(policy *ImpersonationPolicy) IssuerOption {
+	return func(i *TokenIssuer) {
+		i.impersonation = policy
+	}
+}
+
+// Impersonate issues an access token for the user's uuid and permission on behalf of the verified actor,
+// ie: for support staff to act as a user without sharing the user's credentials.
+// The token records the actor, expires with the policy's lifetime or the actor's token if sooner,
+// and cannot be refreshed. It carries the session and authentication methods of the actor.
+// The actor must be verified from an access token, an actor that is itself an impersonation token
+// only delegates further if the MaxChain of the policy allows it.
+// Returns consts.ErrInvalidActor if the actor is not an access token,
+// or consts.ErrImpersonationNotAllowed if the policy does not let the actor act as the user.
+func (i *TokenIssuer) Impersonate(actor *Claims, uuid string, permission Permission) (*pbauth.Identification, error) {
+	if actor == nil || actor.Header().TokenTyp != Jwt {
+		return nil, consts.ErrInvalidActor
+	}
+	if err := validation.ValidateUserUUID(uuid); err != nil {
+		return nil, err
+	}
+	if permission < NoPermission || permission > Admin {
+		return nil, consts.ErrUnknownPermission
+	}
+	actorBody := actor.Body()
+	now := i.clock.Now()
+	expiration := now.Add(i.impersonation.lifetime).Unix()
+	if actorBody.ExpirationTimestamp < expiration {
+		expiration = actorBody.ExpirationTimestamp
+	}
+	body := &Body{
+		UUID:                uuid,
+		Permission:          permission,
+		ExpirationTimestamp: expiration,
+		IssuedAt:            now.Unix(),
+		Issuer:              i.issuer,
+		Audience:            i.audience,
+		Session:             actorBody.Session,
+		AuthMethods:         actorBody.AuthMethods,
+		Actor: &Actor{
+			Principal:  actorBody.Principal,
+			UUID:       actorBody.UUID,
+			Service:    actorBody.Service,
+			Permission: actorBody.Permission,
+			Actor:      actorBody.Actor,
+		},
+	}
+	if err := i.impersonation.Authorize(body); err != nil {
+		return nil, err
+	}
+	token, err := newToken(
+		&Header{
+			Alg:      AlgorithmMap[permission],
+			TokenTyp: Jwt,
+		},
+		body,
+		i.secret,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	logger.Audit(body.Actor.String(), "issued a token to act as", describeSubject(body))
+	return &pbauth.Identification{
+		Token:  token,
+		Secret: i.secret,
+	}, nil
+}
+
+// validateActor checks the principal and permission of every actor of the chain.
+func validateActor(actor *Actor) error {
+	depth := 0
+	for ; actor != nil; actor = actor.Actor {
+		depth++
+		if depth > maxActorChainLength {
+			return consts.ErrInvalidActor
+		}
+		if err := validatePrincipal(actor.Principal, actor.UUID, actor.Service); err != nil {
+			return consts.ErrInvalidActor
+		}
+		if actor.Permission < NoPermission || actor.Permission > Admin {
+			return consts.ErrInvalidActor
+		}
+	}
+	return nil
+}
+
+// copyActor makes a deep copy of the actor chain.
+// Returns nil if the actor is nil.
+func copyActor(actor *Actor) *Actor {
+	if actor == nil {
+		return nil
+	}
+	return &Actor{
+		Principal:  actor.Principal,
+		UUID:       actor.UUID,
+		Service:    actor.Service,
+		Permission: actor.Permission,
+		Actor:      copyActor(actor.Actor),
+	}
+}
+
+// auditImpersonation records the real actor of an operation performed with an impersonation token.
+func auditImpersonation(body *Body, operation string) {
+	if body == nil || body.Actor == nil {
+		return
+	}
+	logger.Audit(body.Actor.String(), "acting as", describeSubject(body), "performed", operation)
+}
+
+// describeSubject describes the user or service of the body.
+func describeSubject(body *Body) string {
+	return describePrincipal(body.Principal, body.UUID, body.Service, body.Permission)
+}
+
+// describePrincipal describes a user or service and its permission, ie: "user 01d3x3wm2nnrdfzp0tka2vw9dx (ADMIN)".
+func describePrincipal(principal PrincipalType, uuid string, service string, permission Permission) string {
+	if principal == ServicePrincipal {
+		return fmt.Sprintf("service %s (%s)", service, PermissionStringMap[permission])
+	}
+	return fmt.Sprintf("user %s (%s)", uuid, PermissionStringMap[permission])
+}
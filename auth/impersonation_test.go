@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"bytes"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	testAdminUUID = "01d3x3wm2nnrdfzp0tka2vw9dx"
+	testUserUUID  = "01d1na5ekzr7p98hragv5fmvxa"
+)
+
+func TestNewImpersonationPolicy(t *testing.T) {
+	cases := []struct {
+		desc     string
+		config   *ImpersonationConfig
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil config", nil, true, consts.ErrNilImpersonationConfig},
+		{"test for zero chain", &ImpersonationConfig{Lifetime: time.Hour}, true,
+			consts.ErrInvalidImpersonationPolicy},
+		{"test for long chain", &ImpersonationConfig{MaxChain: maxActorChainLength + 1, Lifetime: time.Hour}, true,
+			consts.ErrInvalidImpersonationPolicy},
+		{"test for zero lifetime", &ImpersonationConfig{MaxChain: 1}, true, consts.ErrInvalidLifetime},
+		{"test for unknown actor permission", &ImpersonationConfig{
+			Permissions: map[Permission][]Permission{Admin + 1: {User}}, MaxChain: 1, Lifetime: time.Hour,
+		}, true, consts.ErrUnknownPermission},
+		{"test for unknown subject permission", &ImpersonationConfig{
+			Permissions: map[Permission][]Permission{Admin: {Admin + 1}}, MaxChain: 1, Lifetime: time.Hour,
+		}, true, consts.ErrUnknownPermission},
+		{"test for valid config", &ImpersonationConfig{
+			Permissions: map[Permission][]Permission{Admin: {User}}, MaxChain: 2, Lifetime: time.Hour,
+		}, false, nil},
+	}
+	for _, c := range cases {
+		policy, err := NewImpersonationPolicy(c.config)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, policy, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, policy, c.desc)
+		}
+	}
+}
+
+func TestTokenIssuerImpersonate(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithLifetimePolicy(DefaultLifetimePolicy))
+	assert.Nil(t, err)
+	verify := func(tokenType TokenType, permission Permission, pair *TokenPair, opts ...VerifierOption) *Claims {
+		claims, err := NewVerifier(tokenType, permission, append(opts, WithClock(clock))...).Verify(pair.Access)
+		assert.Nil(t, err)
+		return claims
+	}
+	adminPair, err := issuer.Issue(testAdminUUID, Admin)
+	assert.Nil(t, err)
+	admin := verify(Jwt, Admin, adminPair)
+	userPair, err := issuer.Issue(testUserUUID, User)
+	assert.Nil(t, err)
+	user := verify(Jwt, User, userPair)
+	refresh, err := NewVerifier(Jrt, Admin, WithClock(clock)).Verify(adminPair.Refresh)
+	assert.Nil(t, err)
+	serviceToken, err := newToken(valid512JWT, &Body{
+		Principal:           ServicePrincipal,
+		Service:             "hwsc-support-svc",
+		Permission:          Admin,
+		ExpirationTimestamp: now.Add(time.Hour).Unix(),
+	}, validSecret, now)
+	assert.Nil(t, err)
+	service, err := NewVerifier(Jwt, Admin, WithClock(clock), WithServices()).Verify(&pbauth.Identification{
+		Token:  serviceToken,
+		Secret: validSecret,
+	})
+	assert.Nil(t, err)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	cases := []struct {
+		desc       string
+		actor      *Claims
+		uuid       string
+		permission Permission
+		expErr     error
+	}{
+		{"test for nil actor", nil, testUserUUID, User, consts.ErrInvalidActor},
+		{"test for refresh token actor", refresh, testUserUUID, User, consts.ErrInvalidActor},
+		{"test for admin service actor", service, testUserUUID, User, consts.ErrImpersonationNotAllowed},
+		{"test for invalid uuid", admin, "", User, consts.ErrInvalidUUID},
+		{"test for unknown permission", admin, testUserUUID, Admin + 1, consts.ErrUnknownPermission},
+		{"test for user acting as user", user, testAdminUUID, User, consts.ErrImpersonationNotAllowed},
+		{"test for admin acting as admin", admin, testUserUUID, Admin, consts.ErrImpersonationNotAllowed},
+	}
+	for _, c := range cases {
+		id, err := issuer.Impersonate(c.actor, c.uuid, c.permission)
+		assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		assert.Nil(t, id, c.desc)
+	}
+
+	desc := "test for admin acting as user"
+	id, err := issuer.Impersonate(admin, testUserUUID, User)
+	assert.Nil(t, err, desc)
+	claims, err := NewVerifier(Jwt, User, WithClock(clock)).Verify(id)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, testUserUUID, claims.Body().UUID, desc)
+	assert.Equal(t, User, claims.Body().Permission, desc)
+	assert.Equal(t, &Actor{UUID: testAdminUUID, Permission: Admin}, claims.Actor(), desc)
+	assert.Equal(t, now.Add(time.Hour).Unix(), claims.Body().ExpirationTimestamp, desc)
+	assert.Contains(t, logs.String(), "[AUDIT] user "+testAdminUUID+" (ADMIN) issued a token to act as user "+
+		testUserUUID+" (USER)", desc)
+
+	desc = "test for impersonation not outliving the actor token"
+	clock.Set(time.Unix(admin.Body().ExpirationTimestamp, 0).Add(-time.Minute))
+	id, err = issuer.Impersonate(admin, testUserUUID, User)
+	assert.Nil(t, err, desc)
+	claims, err = NewVerifier(Jwt, User, WithClock(clock)).Verify(id)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, admin.Body().ExpirationTimestamp, claims.Body().ExpirationTimestamp, desc)
+
+	desc = "test for delegating an impersonation"
+	_, err = issuer.Impersonate(claims, testUserUUID, User)
+	assert.EqualError(t, err, consts.ErrImpersonationNotAllowed.Error(), desc)
+
+	desc = "test for operation rejecting impersonation"
+	_, err = NewVerifier(Jwt, User, WithClock(clock), WithoutImpersonation()).Verify(id)
+	assert.EqualError(t, err, consts.ErrImpersonationNotAllowed.Error(), desc)
+	assert.True(t, isForbidden(err), desc)
+
+	desc = "test for stricter policy"
+	policy, err := NewImpersonationPolicy(&ImpersonationConfig{MaxChain: 1, Lifetime: time.Hour})
+	assert.Nil(t, err, desc)
+	_, err = NewVerifier(Jwt, User, WithClock(clock), WithImpersonation(policy)).Verify(id)
+	assert.EqualError(t, err, consts.ErrImpersonationNotAllowed.Error(), desc)
+
+	desc = "test for policy allowing services"
+	clock.Set(now)
+	policy, err = NewImpersonationPolicy(&ImpersonationConfig{
+		ServicePermissions: map[Permission][]Permission{Admin: {User}},
+		MaxChain:           1,
+		Lifetime:           time.Hour,
+	})
+	assert.Nil(t, err, desc)
+	serviceIssuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithIssuerImpersonationPolicy(policy))
+	assert.Nil(t, err, desc)
+	_, err = serviceIssuer.Impersonate(service, testUserUUID, User)
+	assert.Nil(t, err, desc)
+	_, err = serviceIssuer.Impersonate(admin, testUserUUID, User)
+	assert.EqualError(t, err, consts.ErrImpersonationNotAllowed.Error(), desc)
+
+	desc = "test for nil issuer policy"
+	_, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerImpersonationPolicy(nil))
+	assert.EqualError(t, err, consts.ErrNilImpersonationPolicy.Error(), desc)
+}
+
+func TestImpersonationPolicyChain(t *testing.T) {
+	policy, err := NewImpersonationPolicy(&ImpersonationConfig{
+		Permissions: map[Permission][]Permission{Admin: {User}, User: {User}},
+		MaxChain:    2,
+		Lifetime:    time.Hour,
+	})
+	assert.Nil(t, err)
+	admin := func(previous *Actor) *Actor {
+		return &Actor{UUID: testAdminUUID, Permission: Admin, Actor: previous}
+	}
+	user := func(previous *Actor) *Actor {
+		return &Actor{UUID: testUserUUID, Permission: User, Actor: previous}
+	}
+
+	cases := []struct {
+		desc     string
+		body     *Body
+		isExpErr bool
+	}{
+		{"test for no actor", &Body{Permission: Admin}, false},
+		{"test for admin acting as user", &Body{Permission: User, Actor: admin(nil)}, false},
+		{"test for user delegating to user", &Body{Permission: User, Actor: user(user(nil))}, false},
+		{"test for admin delegating to user", &Body{Permission: User, Actor: user(admin(nil))}, false},
+		{"test for user escalating through admin", &Body{Permission: User, Actor: admin(user(nil))}, true},
+		{"test for service actor", &Body{Permission: User, Actor: &Actor{
+			Principal: ServicePrincipal, Service: "hwsc-support-svc", Permission: Admin}}, true},
+		{"test for chain too long", &Body{Permission: User, Actor: user(user(user(nil)))}, true},
+	}
+	for _, c := range cases {
+		err := policy.Authorize(c.body)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrImpersonationNotAllowed.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestBearerMiddlewareAuditsImpersonation(t *testing.T) {
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore())
+	assert.Nil(t, err)
+	adminPair, err := issuer.Issue(testAdminUUID, Admin)
+	assert.Nil(t, err)
+	admin, err := NewVerifier(Jwt, Admin).Verify(adminPair.Access)
+	assert.Nil(t, err)
+	id, err := issuer.Impersonate(admin, testUserUUID, User)
+	assert.Nil(t, err)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	middleware, err := BearerMiddleware(StaticSecret(validSecret), Requirement{TokenType: Jwt, Permission: User}, nil)
+	assert.Nil(t, err)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(http.MethodDelete, "/documents/1", nil)
+	r.Header.Set("Authorization", "Bearer "+id.GetToken())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, logs.String(), "[AUDIT] user "+testAdminUUID+" (ADMIN) acting as user "+testUserUUID+
+		" (USER) performed DELETE /documents/1")
+
+	logs.Reset()
+	r = httptest.NewRequest(http.MethodGet, "/documents/1", nil)
+	r.Header.Set("Authorization", "Bearer "+adminPair.Access.GetToken())
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Empty(t, logs.String(), "test for no audit without impersonation")
+}
+
+func TestValidateActor(t *testing.T) {
+	deep := &Actor{UUID: testAdminUUID, Permission: Admin}
+	for i := 0; i < maxActorChainLength; i++ {
+		deep = &Actor{UUID: testAdminUUID, Permission: Admin, Actor: deep}
+	}
+	cases := []struct {
+		desc     string
+		actor    *Actor
+		isExpErr bool
+	}{
+		{"test for user actor", &Actor{UUID: testAdminUUID, Permission: Admin}, false},
+		{"test for service actor", &Actor{Principal: ServicePrincipal, Service: "hwsc-support-svc", Permission: Admin},
+			false},
+		{"test for actor without uuid", &Actor{Permission: Admin}, true},
+		{"test for unknown permission", &Actor{UUID: testAdminUUID, Permission: Admin + 1}, true},
+		{"test for invalid previous actor", &Actor{UUID: testAdminUUID, Permission: Admin, Actor: &Actor{}}, true},
+		{"test for chain too long", deep, true},
+	}
+	for _, c := range cases {
+		body := copyBody(validUserBody)
+		body.Actor = c.actor
+		err := ValidateBody(body)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrInvalidActor.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestActorString(t *testing.T) {
+	actor := &Actor{
+		UUID:       testAdminUUID,
+		Permission: Admin,
+		Actor:      &Actor{Principal: ServicePrincipal, Service: "hwsc-support-svc", Permission: Admin},
+	}
+	assert.Equal(t, "user "+testAdminUUID+" (ADMIN) delegated by service hwsc-support-svc (ADMIN)", actor.String())
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+const (
+	// IdentificationMetadataKey is the gRPC metadata key carrying the marshalled identification.
+	// The -bin suffix makes gRPC transport the value as binary.
+	IdentificationMetadataKey = "hwsc-identification-bin"
+)
+
+// UnaryServerInterceptor authorizes unary calls using the requirement registered for each full method name.
+// Calls to methods without a registered requirement are denied.
+// The authorized body is available to the handler through FromContext.
+// Returns an error if a requirement is neither public nor names a token type.
+func UnaryServerInterceptor(requirements map[string]Requirement) (grpc.UnaryServerInterceptor, error) {
+	if err := validateRequirements(requirements); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		authorizedCtx, err := authorizeMethod(ctx, info.FullMethod, requirements)
+		if err != nil {
+			return nil, err
+		}
+		return handler(authorizedCtx, req)
+	}, nil
+}
+
+// StreamServerInterceptor authorizes streams using the requirement registered for each full method name.
+// Streams of methods without a registered requirement are denied.
+// The authorized body is available to the handler through FromContext on the stream's context.
+// Returns an error if a requirement is neither public nor names a token type.
+func StreamServerInterceptor(requirements map[string]Requirement) (grpc.StreamServerInterceptor, error) {
+	if err := validateRequirements(requirements); err != nil {
+		return nil, err
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		authorizedCtx, err := authorizeMethod(ss.Context(), info.FullMethod, requirements)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedServerStream{ServerStream: ss, ctx: authorizedCtx})
+	}, nil
+}
+
+// UnaryClientInterceptor attaches the identification to outgoing unary calls.
+// Calls that already carry an identification through AppendIdentification are left untouched.
+func UnaryClientInterceptor(id *pbauth.Identification) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		outgoingCtx, err := attachIdentification(ctx, id)
+		if err != nil {
+			return err
+		}
+		return invoker(outgoingCtx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor attaches the identification to outgoing streams.
+// Streams that already carry an identification through AppendIdentification are left untouched.
+func StreamClientInterceptor(id *pbauth.Identification) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		outgoingCtx, err := attachIdentification(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(outgoingCtx, desc, cc, method, opts...)
+	}
+}
+
+// AppendIdentification returns a copy of ctx with the identification added to the outgoing metadata.
+// Returns an error if the identification cannot be marshalled.
+func AppendIdentification(ctx context.Context, id *pbauth.Identification) (context.Context, error) {
+	if id == nil {
+		return nil, consts.ErrNilIdentification
+	}
+	marshalledID, err := proto.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, IdentificationMetadataKey, string(marshalledID)), nil
+}
+
+// attachIdentification adds the identification to ctx unless ctx already carries one.
+func attachIdentification(ctx context.Context, id *pbauth.Identification) (context.Context, error) {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(IdentificationMetadataKey)) > 0 {
+		return ctx, nil
+	}
+	return AppendIdentification(ctx, id)
+}
+
+// identificationFromIncoming extracts the identification from the incoming metadata.
+// Returns an error if there is not exactly one identification, or it cannot be unmarshalled.
+func identificationFromIncoming(ctx context.Context) (*pbauth.Identification, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, consts.ErrNilIdentification
+	}
+	values := md.Get(IdentificationMetadataKey)
+	if len(values) != 1 {
+		return nil, consts.ErrNilIdentification
+	}
+	id := &pbauth.Identification{}
+	if err := proto.Unmarshal([]byte(values[0]), id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// presentationFromIncoming extracts the TLS client certificates of the peer and the proof in the incoming metadata.
+// Proofs of gRPC calls are signed for the method "POST" and the full method name as URL.
+func presentationFromIncoming(ctx context.Context, fullMethod string) *Presentation {
+	p := &Presentation{
+		Method: http.MethodPost,
+		URL:    fullMethod,
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(strings.ToLower(ProofHeaderKey)); len(values) == 1 {
+			p.Proof = values[0]
+		}
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		if pr.Addr != nil {
+			p.RemoteAddr = pr.Addr.String()
+		}
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			p.Certificates = info.State.PeerCertificates
+		}
+	}
+	return p
+}
+
+// authorizeMethod authorizes the identification in ctx against the requirement of the full method name.
+// Calls made with impersonation tokens are audit logged with the real actor.
+// Returns a copy of ctx carrying the authorized body, or a gRPC status error if not authorized.
+func authorizeMethod(ctx context.Context, fullMethod string, requirements map[string]Requirement) (context.Context, error) {
+	requirement, ok := requirements[fullMethod]
+	if !ok {
+		return nil, statusFromError(consts.ErrUnregisteredOperation)
+	}
+	if requirement.isPublic() {
+		return ctx, nil
+	}
+	id, err := identificationFromIncoming(ctx)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	body, err := requirement.authorize(id, presentationFromIncoming(ctx, fullMethod))
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	auditImpersonation(body, fullMethod)
+	return NewContext(ctx, body), nil
+}
+
+// statusFromError maps an authorization error to a gRPC status error.
+// Errors about the identity of the caller map to codes.Unauthenticated,
+// and errors about what the caller is allowed to do map to codes.PermissionDenied.
+// Locked out callers get codes.ResourceExhausted.
+func statusFromError(err error) error {
+	if err == consts.ErrLockedOut {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if isForbidden(err) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Unauthenticated, err.Error())
+}
+
+// authorizedServerStream overrides the context of a grpc.ServerStream with the authorized context.
+type authorizedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the authorized context of the stream.
+func (s *authorizedServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+const (
+	methodPublic   = "/hwsc.Svc/Public"
+	methodUser     = "/hwsc.Svc/User"
+	methodAdmin    = "/hwsc.Svc/Admin"
+	methodUnknown  = "/hwsc.Svc/Unknown"
+	invalidIDBytes = "\xff\xff\xff"
+)
+
+var (
+	testRequirements = map[string]Requirement{
+		methodPublic: {Public: true},
+		methodUser:   {TokenType: Jwt, Permission: User},
+		methodAdmin:  {TokenType: Jwt, Permission: Admin},
+	}
+	validUserIdentification = &pbauth.Identification{
+		Token:  valid256JWTUserTokenString,
+		Secret: validSecret,
+	}
+	validAdminIdentification = &pbauth.Identification{
+		Token:  valid512JWTAdminTokenString,
+		Secret: validSecret,
+	}
+)
+
+// incomingContext builds a server side context carrying the identification.
+func incomingContext(t *testing.T, id *pbauth.Identification) context.Context {
+	if id == nil {
+		return context.Background()
+	}
+	marshalledID, err := proto.Marshal(id)
+	assert.Nil(t, err)
+	return metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(IdentificationMetadataKey, string(marshalledID)))
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	cases := []struct {
+		desc    string
+		method  string
+		ctx     context.Context
+		expCode codes.Code
+		expUUID string
+	}{
+		{"test for unregistered method", methodUnknown, incomingContext(t, validAdminIdentification),
+			codes.PermissionDenied, ""},
+		{"test for public method without identification", methodPublic, incomingContext(t, nil), codes.OK, ""},
+		{"test for missing identification", methodUser, incomingContext(t, nil), codes.Unauthenticated, ""},
+		{"test for malformed identification", methodUser,
+			metadata.NewIncomingContext(context.Background(), metadata.Pairs(IdentificationMetadataKey, invalidIDBytes)),
+			codes.Unauthenticated, ""},
+		{"test for fake token", methodUser,
+			incomingContext(t, &pbauth.Identification{Token: fakeToken, Secret: validSecret}),
+			codes.Unauthenticated, ""},
+		{"test for user token versus admin method", methodAdmin, incomingContext(t, validUserIdentification),
+			codes.PermissionDenied, ""},
+		{"test for user token versus user method", methodUser, incomingContext(t, validUserIdentification),
+			codes.OK, validUserBody.UUID},
+		{"test for admin token versus user method", methodUser, incomingContext(t, validAdminIdentification),
+			codes.OK, validAdminBody.UUID},
+	}
+	interceptor, err := UnaryServerInterceptor(testRequirements)
+	assert.Nil(t, err)
+	for _, c := range cases {
+		var handlerCtx context.Context
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			handlerCtx = ctx
+			return req, nil
+		}
+		resp, err := interceptor(c.ctx, "req", &grpc.UnaryServerInfo{FullMethod: c.method}, handler)
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		if c.expCode != codes.OK {
+			assert.Nil(t, resp, c.desc)
+			assert.Nil(t, handlerCtx, c.desc)
+			continue
+		}
+		assert.Equal(t, "req", resp, c.desc)
+		body, ok := FromContext(handlerCtx)
+		assert.Equal(t, c.expUUID != "", ok, c.desc)
+		if ok {
+			assert.Equal(t, c.expUUID, body.UUID, c.desc)
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	cases := []struct {
+		desc    string
+		method  string
+		ctx     context.Context
+		expCode codes.Code
+	}{
+		{"test for unregistered method", methodUnknown, incomingContext(t, validAdminIdentification), codes.PermissionDenied},
+		{"test for missing identification", methodUser, incomingContext(t, nil), codes.Unauthenticated},
+		{"test for user token versus admin method", methodAdmin, incomingContext(t, validUserIdentification),
+			codes.PermissionDenied},
+		{"test for admin token versus admin method", methodAdmin, incomingContext(t, validAdminIdentification), codes.OK},
+	}
+	interceptor, err := StreamServerInterceptor(testRequirements)
+	assert.Nil(t, err)
+	for _, c := range cases {
+		called := false
+		handler := func(srv interface{}, ss grpc.ServerStream) error {
+			called = true
+			body, ok := FromContext(ss.Context())
+			assert.True(t, ok, c.desc)
+			assert.Equal(t, validAdminBody.UUID, body.UUID, c.desc)
+			return nil
+		}
+		err := interceptor(nil, &testServerStream{ctx: c.ctx}, &grpc.StreamServerInfo{FullMethod: c.method}, handler)
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		assert.Equal(t, c.expCode == codes.OK, called, c.desc)
+	}
+}
+
+func TestServerInterceptorRequirements(t *testing.T) {
+	cases := []struct {
+		desc     string
+		required Requirement
+		isExpErr bool
+	}{
+		{"test for public requirement", Requirement{Public: true}, false},
+		{"test for token type requirement", Requirement{TokenType: Jwt}, false},
+		{"test for zero requirement", Requirement{}, true},
+		{"test for missing token type", Requirement{Permission: Admin}, true},
+		{"test for scopes without token type", Requirement{Scopes: []string{"docs:read"}}, true},
+		{"test for options without token type", Requirement{Options: []VerifierOption{WithMFA()}}, true},
+		{"test for restricted public requirement", Requirement{Public: true, TokenType: Jwt, Permission: User}, true},
+	}
+	for _, c := range cases {
+		requirements := map[string]Requirement{methodUser: c.required}
+		unary, err := UnaryServerInterceptor(requirements)
+		stream, streamErr := StreamServerInterceptor(requirements)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrInvalidRequirement.Error(), c.desc)
+			assert.EqualError(t, streamErr, consts.ErrInvalidRequirement.Error(), c.desc)
+			assert.Nil(t, unary, c.desc)
+			assert.Nil(t, stream, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Nil(t, streamErr, c.desc)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		opts ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	desc := "test for nil identification"
+	err := UnaryClientInterceptor(nil)(context.Background(), methodUser, nil, nil, nil, invoker)
+	assert.EqualError(t, err, consts.ErrNilIdentification.Error(), desc)
+
+	desc = "test for attaching identification"
+	err = UnaryClientInterceptor(validUserIdentification)(context.Background(), methodUser, nil, nil, nil, invoker)
+	assert.Nil(t, err, desc)
+	values := outgoing.Get(IdentificationMetadataKey)
+	assert.Len(t, values, 1, desc)
+	id := &pbauth.Identification{}
+	assert.Nil(t, proto.Unmarshal([]byte(values[0]), id), desc)
+	assert.True(t, proto.Equal(validUserIdentification, id), desc)
+
+	desc = "test for keeping per call identification"
+	ctx, err := AppendIdentification(context.Background(), validAdminIdentification)
+	assert.Nil(t, err, desc)
+	err = UnaryClientInterceptor(validUserIdentification)(ctx, methodUser, nil, nil, nil, invoker)
+	assert.Nil(t, err, desc)
+	values = outgoing.Get(IdentificationMetadataKey)
+	assert.Len(t, values, 1, desc)
+	assert.Nil(t, proto.Unmarshal([]byte(values[0]), id), desc)
+	assert.Equal(t, valid512JWTAdminTokenString, id.GetToken(), desc)
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	var outgoing metadata.MD
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	}
+	_, err := StreamClientInterceptor(validUserIdentification)(context.Background(), &grpc.StreamDesc{}, nil,
+		methodUser, streamer)
+	assert.Nil(t, err)
+	assert.Len(t, outgoing.Get(IdentificationMetadataKey), 1)
+}
+
+func TestClientServerRoundTrip(t *testing.T) {
+	// metadata attached by the client interceptor is authorized by the server interceptor
+	var serverCtx context.Context
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		serverCtx = metadata.NewIncomingContext(context.Background(), md)
+		return nil
+	}
+	err := UnaryClientInterceptor(validUserIdentification)(context.Background(), methodUser, nil, nil, nil, invoker)
+	assert.Nil(t, err)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		body, ok := FromContext(ctx)
+		assert.True(t, ok)
+		return body, nil
+	}
+	interceptor, err := UnaryServerInterceptor(testRequirements)
+	assert.Nil(t, err)
+	resp, err := interceptor(serverCtx, nil, &grpc.UnaryServerInfo{FullMethod: methodUser}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, validUserBody.UUID, resp.(*Body).UUID)
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"time"
+)
+
+// Alignment moves the expiration of a token to a wall-clock boundary,
+// so that tokens issued around the same time expire together.
+type Alignment int32
+
+const (
+	// NoAlignment expires the token exactly after its duration
+	NoAlignment Alignment = iota
+	// HourAlignment expires the token at the top of the hour
+	HourAlignment
+	// ClockAlignment expires the token at a fixed wall-clock time of the day
+	ClockAlignment
+)
+
+var (
+	// DefaultLifetimePolicy expires access tokens the next day,
+	// and email and refresh tokens two weeks later, at 3 AM UTC, and at least 3 hours after they are issued.
+	// Password reset tokens expire after an hour, email change tokens after a day,
+	// and magic link tokens after 15 minutes.
+	DefaultLifetimePolicy = &LifetimePolicy{
+		tokenTypes: map[TokenType]*lifetime{
+			Jwt: {duration: 3 * time.Hour, alignment: ClockAlignment, hour: 3, location: time.UTC},
+			Jet: {duration: 13*24*time.Hour + 3*time.Hour, alignment: ClockAlignment, hour: 3, location: time.UTC},
+			Jrt: {duration: 13*24*time.Hour + 3*time.Hour, alignment: ClockAlignment, hour: 3, location: time.UTC},
+			Jpt: {duration: time.Hour, location: time.UTC},
+			Jct: {duration: 24 * time.Hour, location: time.UTC},
+			Jmt: {duration: 15 * time.Minute, location: time.UTC},
+		},
+		permissions: map[TokenType]map[Permission]*lifetime{},
+	}
+	// DefaultIssuerLifetimePolicy is the default policy of a TokenIssuer: DefaultLifetimePolicy
+	// with access tokens expiring after 15 minutes, since the refresh token renews them.
+	DefaultIssuerLifetimePolicy = DefaultLifetimePolicy.with(Jwt, &lifetime{duration: 15 * time.Minute,
+		location: time.UTC})
+)
+
+// Lifetime describes how long a token lives.
+// With HourAlignment or ClockAlignment, the expiration is moved forward to the first boundary at or after it,
+// so that the token lives at least Duration.
+// Hour and Minute are the wall-clock time used by ClockAlignment.
+// Boundaries are in the IANA time zone named by Location, ie: "America/Los_Angeles", which defaults to UTC.
+type Lifetime struct {
+	Duration  time.Duration
+	Alignment Alignment
+	Hour      int
+	Minute    int
+	Location  string
+}
+
+// LifetimeConfig sets the lifetime of every TokenType,
+// and optionally of a Permission level within a TokenType.
+// Token types missing from the config keep the lifetime of DefaultLifetimePolicy.
+type LifetimeConfig struct {
+	TokenTypes  map[TokenType]Lifetime
+	Permissions map[TokenType]map[Permission]Lifetime
+}
+
+// LifetimePolicy decides when issued tokens expire.
+// Lifetimes are resolved once from the LifetimeConfig, later changes to the config do not affect the policy.
+type LifetimePolicy struct {
+	tokenTypes  map[TokenType]*lifetime
+	permissions map[TokenType]map[Permission]*lifetime
+}
+
+// lifetime is a validated Lifetime with its time zone loaded.
+type lifetime struct {
+	duration  time.Duration
+	alignment Alignment
+	hour      int
+	minute    int
+	location  *time.Location
+}
+
+// NewLifetimePolicy makes a lifetime policy from the config.
+// Returns an error if a token type, permission or lifetime is not valid.
+func NewLifetimePolicy(config *LifetimeConfig) (*LifetimePolicy, error) {
+	if config == nil {
+		return nil, consts.ErrNilLifetimeConfig
+	}
+	policy := &LifetimePolicy{
+		tokenTypes:  make(map[TokenType]*lifetime),
+		permissions: make(map[TokenType]map[Permission]*lifetime),
+	}
+	for tokenType, l := range DefaultLifetimePolicy.tokenTypes {
+		policy.tokenTypes[tokenType] = l
+	}
+	for tokenType, l := range config.TokenTypes {
+		if !isSupportedTokenType(tokenType) {
+			return nil, consts.ErrUnknownTokenType
+		}
+		resolved, err := newLifetime(l)
+		if err != nil {
+			return nil, err
+		}
+		policy.tokenTypes[tokenType] = resolved
+	}
+	for tokenType, permissions := range config.Permissions {
+		if !isSupportedTokenType(tokenType) {
+			return nil, consts.ErrUnknownTokenType
+		}
+		policy.permissions[tokenType] = make(map[Permission]*lifetime, len(permissions))
+		for permission, l := range permissions {
+			if permission < NoPermission || permission > Admin {
+				return nil, consts.ErrUnknownPermission
+			}
+			resolved, err := newLifetime(l)
+			if err != nil {
+				return nil, err
+			}
+			policy.permissions[tokenType][permission] = resolved
+		}
+	}
+	return policy, nil
+}
+
+// with returns a copy of the policy with the lifetime of the token type replaced,
+// and the permission lifetimes of the token type dropped.
+func (p *LifetimePolicy) with(tokenType TokenType, l *lifetime) *LifetimePolicy {
+	policy := &LifetimePolicy{
+		tokenTypes:  map[TokenType]*lifetime{tokenType: l},
+		permissions: make(map[TokenType]map[Permission]*lifetime),
+	}
+	for t, l := range p.tokenTypes {
+		if t != tokenType {
+			policy.tokenTypes[t] = l
+		}
+	}
+	for t, permissions := range p.permissions {
+		if t != tokenType {
+			policy.permissions[t] = permissions
+		}
+	}
+	return policy
+}
+
+// newLifetime validates the lifetime and loads its time zone.
+func newLifetime(l Lifetime) (*lifetime, error) {
+	if l.Duration <= 0 {
+		return nil, consts.ErrInvalidLifetime
+	}
+	if l.Alignment < NoAlignment || l.Alignment > ClockAlignment {
+		return nil, consts.ErrUnknownAlignment
+	}
+	if l.Hour < 0 || l.Hour > 23 || l.Minute < 0 || l.Minute > 59 {
+		return nil, consts.ErrInvalidLifetime
+	}
+	location := time.UTC
+	if l.Location != "" {
+		loaded, err := time.LoadLocation(l.Location)
+		if err != nil {
+			return nil, consts.ErrInvalidTimeZone
+		}
+		location = loaded
+	}
+	return &lifetime{
+		duration:  l.Duration,
+		alignment: l.Alignment,
+		hour:      l.Hour,
+		minute:    l.Minute,
+		location:  location,
+	}, nil
+}
+
+// Expiration returns when a token of the type and permission level issued at issued expires, in UTC.
+// Returns an error if issued is zero or the token type has no lifetime.
+func (p *LifetimePolicy) Expiration(tokenType TokenType, permission Permission, issued time.Time) (time.Time, error) {
+	if issued.IsZero() {
+		return time.Time{}, consts.ErrInvalidTimeStamp
+	}
+	l, ok := p.permissions[tokenType][permission]
+	if !ok {
+		l, ok = p.tokenTypes[tokenType]
+	}
+	if !ok {
+		return time.Time{}, consts.ErrUnknownTokenType
+	}
+	return l.expiration(issued), nil
+}
+
+// expiration adds the duration to issued and rounds the result up to the next boundary.
+func (l *lifetime) expiration(issued time.Time) time.Time {
+	expiration := issued.Add(l.duration).In(l.location)
+	switch l.alignment {
+	case HourAlignment:
+		aligned := time.Date(expiration.Year(), expiration.Month(), expiration.Day(),
+			expiration.Hour(), 0, 0, 0, l.location)
+		if aligned.Before(expiration) {
+			aligned = aligned.Add(time.Hour)
+		}
+		expiration = aligned
+	case ClockAlignment:
+		aligned := time.Date(expiration.Year(), expiration.Month(), expiration.Day(),
+			l.hour, l.minute, 0, 0, l.location)
+		if aligned.Before(expiration) {
+			aligned = time.Date(aligned.Year(), aligned.Month(), aligned.Day()+1,
+				l.hour, l.minute, 0, 0, l.location)
+		}
+		expiration = aligned
+	}
+	return expiration.UTC()
+}
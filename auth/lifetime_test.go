@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewLifetimePolicy(t *testing.T) {
+	cases := []struct {
+		desc     string
+		config   *LifetimeConfig
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil config", nil, true, consts.ErrNilLifetimeConfig},
+		{"test for empty config", &LifetimeConfig{}, false, nil},
+		{"test for no type",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{NoType: {Duration: time.Hour}}},
+			true, consts.ErrUnknownTokenType,
+		},
+		{"test for no type permission",
+			&LifetimeConfig{Permissions: map[TokenType]map[Permission]Lifetime{
+				Jmt + 1: {Admin: {Duration: time.Hour}},
+			}}, true, consts.ErrUnknownTokenType,
+		},
+		{"test for unknown permission",
+			&LifetimeConfig{Permissions: map[TokenType]map[Permission]Lifetime{
+				Jwt: {Admin + 1: {Duration: time.Hour}},
+			}}, true, consts.ErrUnknownPermission,
+		},
+		{"test for zero duration",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{Jwt: {}}},
+			true, consts.ErrInvalidLifetime,
+		},
+		{"test for negative duration",
+			&LifetimeConfig{Permissions: map[TokenType]map[Permission]Lifetime{
+				Jwt: {Admin: {Duration: -time.Hour}},
+			}}, true, consts.ErrInvalidLifetime,
+		},
+		{"test for unknown alignment",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{Jwt: {Duration: time.Hour, Alignment: ClockAlignment + 1}}},
+			true, consts.ErrUnknownAlignment,
+		},
+		{"test for invalid hour",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{
+				Jwt: {Duration: time.Hour, Alignment: ClockAlignment, Hour: 24},
+			}}, true, consts.ErrInvalidLifetime,
+		},
+		{"test for invalid minute",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{
+				Jwt: {Duration: time.Hour, Alignment: ClockAlignment, Minute: -1},
+			}}, true, consts.ErrInvalidLifetime,
+		},
+		{"test for unknown time zone",
+			&LifetimeConfig{TokenTypes: map[TokenType]Lifetime{
+				Jwt: {Duration: time.Hour, Alignment: ClockAlignment, Location: "Mars/Olympus_Mons"},
+			}}, true, consts.ErrInvalidTimeZone,
+		},
+		{"test for valid config",
+			&LifetimeConfig{
+				TokenTypes: map[TokenType]Lifetime{
+					Jwt: {Duration: 15 * time.Minute},
+					Jrt: {Duration: 30 * 24 * time.Hour, Alignment: ClockAlignment, Hour: 4, Location: "America/Los_Angeles"},
+				},
+				Permissions: map[TokenType]map[Permission]Lifetime{
+					Jwt: {Admin: {Duration: time.Hour, Alignment: HourAlignment}},
+				},
+			}, false, nil,
+		},
+	}
+	for _, c := range cases {
+		policy, err := NewLifetimePolicy(c.config)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, policy, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, policy, c.desc)
+		}
+	}
+}
+
+func TestLifetimePolicyExpiration(t *testing.T) {
+	policy, err := NewLifetimePolicy(&LifetimeConfig{
+		TokenTypes: map[TokenType]Lifetime{
+			Jwt: {Duration: 15 * time.Minute},
+			Jet: {Duration: 90 * time.Minute, Alignment: HourAlignment},
+			Jrt: {Duration: 7 * 24 * time.Hour, Alignment: ClockAlignment, Hour: 4, Minute: 30,
+				Location: "America/Los_Angeles"},
+		},
+		Permissions: map[TokenType]map[Permission]Lifetime{
+			Jwt: {Admin: {Duration: 20 * time.Minute, Alignment: HourAlignment}},
+		},
+	})
+	assert.Nil(t, err)
+	issued := time.Date(2019, 6, 1, 12, 10, 30, 0, time.UTC)
+	cases := []struct {
+		desc       string
+		policy     *LifetimePolicy
+		tokenType  TokenType
+		permission Permission
+		issued     time.Time
+		isExpErr   bool
+		expErr     error
+		expOutput  time.Time
+	}{
+		{"test for zero issue time", policy, Jwt, User, time.Time{}, true, consts.ErrInvalidTimeStamp, time.Time{}},
+		{"test for no type", policy, NoType, User, issued, true, consts.ErrUnknownTokenType, time.Time{}},
+		{"test for no alignment", policy, Jwt, User, issued, false, nil,
+			time.Date(2019, 6, 1, 12, 25, 30, 0, time.UTC)},
+		{"test for hour alignment", policy, Jet, User, issued, false, nil,
+			time.Date(2019, 6, 1, 14, 0, 0, 0, time.UTC)},
+		{"test for hour alignment within the hour", policy, Jwt, Admin, issued, false, nil,
+			time.Date(2019, 6, 1, 13, 0, 0, 0, time.UTC)},
+		{"test for hour alignment just before the hour", policy, Jwt, Admin,
+			time.Date(2019, 6, 1, 10, 59, 59, 0, time.UTC), false, nil,
+			time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"test for hour alignment on the hour", policy, Jwt, Admin,
+			time.Date(2019, 6, 1, 10, 40, 0, 0, time.UTC), false, nil,
+			time.Date(2019, 6, 1, 11, 0, 0, 0, time.UTC)},
+		{"test for clock alignment in time zone", policy, Jrt, User, issued, false, nil,
+			time.Date(2019, 6, 9, 11, 30, 0, 0, time.UTC)},
+		{"test for clock alignment in time zone on next day", policy, Jrt, User,
+			time.Date(2019, 6, 1, 6, 0, 0, 0, time.UTC), false, nil,
+			time.Date(2019, 6, 8, 11, 30, 0, 0, time.UTC)},
+		{"test for default access token", DefaultLifetimePolicy, Jwt, User, issued, false, nil,
+			time.Date(2019, 6, 2, 3, 0, 0, 0, time.UTC)},
+		{"test for default email token", DefaultLifetimePolicy, Jet, UserRegistration, issued, false, nil,
+			time.Date(2019, 6, 15, 3, 0, 0, 0, time.UTC)},
+		{"test for default refresh token", DefaultLifetimePolicy, Jrt, Admin, issued, false, nil,
+			time.Date(2019, 6, 15, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		expiration, err := c.policy.Expiration(c.tokenType, c.permission, c.issued)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, c.expOutput, expiration, c.desc)
+		}
+	}
+}
+
+func TestDefaultLifetimePolicy(t *testing.T) {
+	// the default policy keeps the expirations of GenerateExpirationTimestamp
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for hours := 0; hours < 48; hours++ {
+		issued := start.Add(time.Duration(hours)*time.Hour + 17*time.Minute)
+		expiration, err := DefaultLifetimePolicy.Expiration(Jwt, User, issued)
+		assert.Nil(t, err)
+		expOutput, err := GenerateExpirationTimestamp(issued, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, *expOutput, expiration, issued.String())
+
+		expiration, err = DefaultLifetimePolicy.Expiration(Jrt, User, issued)
+		assert.Nil(t, err)
+		expOutput, err = GenerateExpirationTimestamp(issued, daysInTwoWeeks)
+		assert.Nil(t, err)
+		assert.Equal(t, *expOutput, expiration, issued.String())
+	}
+}
@@ -7,12 +7,22 @@ const (
 	strAdmin            = "ADMIN"
 	strJWT              = "JWT"
 	strJET              = "JET"
+	strJRT              = "JRT"
+	strJPT              = "JPT"
+	strJCT              = "JCT"
+	strJMT              = "JMT"
 	strNoType           = "NO_TYPE"
 	strNoAlg            = "NO_ALG"
 	strHs256            = "HS256"
 	strHs512            = "HS512"
+	strUserPrincipal    = "USER"
+	strServicePrincipal = "SERVICE"
+	strActiveSession    = "ACTIVE"
+	strRevokedSession   = "REVOKED"
 	// SecretByteSize bytes used to generate secret key
 	SecretByteSize = 32
+	// MaxTokenSize maximum number of bytes of a token string
+	MaxTokenSize = 8 * 1024
 )
 
 var (
@@ -45,6 +55,22 @@ var (
 		NoType: strNoType,
 		Jwt:    strJWT,
 		Jet:    strJET,
+		Jrt:    strJRT,
+		Jpt:    strJPT,
+		Jct:    strJCT,
+		Jmt:    strJMT,
+	}
+
+	// PrincipalTypeStringMap maps enum PrincipalType to its string value
+	PrincipalTypeStringMap = map[PrincipalType]string{
+		UserPrincipal:    strUserPrincipal,
+		ServicePrincipal: strServicePrincipal,
+	}
+
+	// SessionStateStringMap maps enum SessionState to its string value
+	SessionStateStringMap = map[SessionState]string{
+		ActiveSession:  strActiveSession,
+		RevokedSession: strRevokedSession,
 	}
 
 	// AlgorithmStringMap maps enum Algorithm to its string value
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+)
+
+// Authentication methods of the amr claim, from RFC 8176.
+const (
+	// AuthMethodPassword is a password the user knows
+	AuthMethodPassword = "pwd"
+	// AuthMethodOTP is a one-time password, ie: a TOTP code or a recovery code
+	AuthMethodOTP = "otp"
+	// AuthMethodHardwareKey is a proof of possession of a hardware key
+	AuthMethodHardwareKey = "hwk"
+	// AuthMethodMFA is set when the user authenticated with more than one factor
+	AuthMethodMFA = "mfa"
+)
+
+// WithMFA makes the verifier reject tokens of users that did not authenticate with a second factor,
+// ie: WithMFA(Admin) for the Admin tokens, or every token if no permission is given.
+// A token proves its second factor with AuthMethodMFA in its AuthMethods.
+// Service tokens authenticate with client credentials and are exempt.
+func WithMFA(permissions ...Permission) VerifierOption {
+	return func(v *Verifier) {
+		if len(permissions) == 0 {
+			v.mfa = true
+			return
+		}
+		if v.mfaPermissions == nil {
+			v.mfaPermissions = make(map[Permission]bool)
+		}
+		for _, permission := range permissions {
+			v.mfaPermissions[permission] = true
+		}
+	}
+}
+
+// validateMFA checks that the body has a second factor if its permission requires one.
+func (v *Verifier) validateMFA(body *Body) error {
+	if !v.mfa && !v.mfaPermissions[body.Permission] {
+		return nil
+	}
+	if body.Principal == ServicePrincipal {
+		return nil
+	}
+	for _, method := range body.AuthMethods {
+		if method == AuthMethodMFA {
+			return nil
+		}
+	}
+	return consts.ErrMFARequired
+}
+
+// validateAuthMethods checks that every authentication method is a single word.
+func validateAuthMethods(methods []string) error {
+	for _, method := range methods {
+		if method == "" || strings.ContainsAny(method, " \t\r\n") {
+			return consts.ErrInvalidAuthMethod
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVerifyMFA(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock))
+	assert.Nil(t, err)
+	password, err := issuer.IssueSession(testAdminUUID, Admin, "", AuthMethodPassword)
+	assert.Nil(t, err)
+	mfa, err := issuer.IssueSession(testAdminUUID, Admin, "", AuthMethodPassword, AuthMethodOTP, AuthMethodMFA)
+	assert.Nil(t, err)
+	user, err := issuer.IssueSession(testUserUUID, User, "", AuthMethodPassword)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc       string
+		permission Permission
+		opts       []VerifierOption
+		id         *pbauth.Identification
+		isExpErr   bool
+	}{
+		{"test for no requirement", Admin, nil, password.Access, false},
+		{"test for admin without second factor", Admin, []VerifierOption{WithMFA(Admin)}, password.Access, true},
+		{"test for admin with second factor", Admin, []VerifierOption{WithMFA(Admin)}, mfa.Access, false},
+		{"test for user exempt", User, []VerifierOption{WithMFA(Admin)}, user.Access, false},
+		{"test for every permission", User, []VerifierOption{WithMFA()}, user.Access, true},
+	}
+	for _, c := range cases {
+		opts := append([]VerifierOption{WithClock(clock)}, c.opts...)
+		_, err := NewVerifier(Jwt, c.permission, opts...).Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrMFARequired.Error(), c.desc)
+			assert.False(t, isForbidden(err), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+
+	desc := "test for refresh keeping the second factor"
+	rotated, err := issuer.Exchange(mfa.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	claims, err := NewVerifier(Jwt, Admin, WithClock(clock), WithMFA(Admin)).Verify(rotated.Access)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, []string{AuthMethodPassword, AuthMethodOTP, AuthMethodMFA}, claims.Body().AuthMethods, desc)
+
+	desc = "test for authority requiring a second factor"
+	authority := NewAuthority(Jwt, Admin, WithClock(clock), WithMFA())
+	assert.EqualError(t, authority.Authorize(password.Access), consts.ErrMFARequired.Error(), desc)
+	authority = NewAuthority(Jwt, Admin, WithClock(clock), WithMFA())
+	assert.Nil(t, authority.Authorize(mfa.Access), desc)
+
+	desc = "test for invalid method"
+	_, err = issuer.IssueSession(testAdminUUID, Admin, "", "one time password")
+	assert.EqualError(t, err, consts.ErrInvalidAuthMethod.Error(), desc)
+	body := copyBody(validUserBody)
+	body.AuthMethods = []string{""}
+	assert.EqualError(t, ValidateBody(body), consts.ErrInvalidAuthMethod.Error(), desc)
+}
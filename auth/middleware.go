@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"fmt"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"net/http"
+	"strings"
+)
+
+const (
+	// BearerRealm is the realm reported in WWW-Authenticate challenges.
+	BearerRealm       = "hwsc"
+	authorizationKey  = "Authorization"
+	authenticateKey   = "WWW-Authenticate"
+	bearerScheme      = "bearer"
+	errInvalidRequest = "invalid_request"
+	errInvalidToken   = "invalid_token"
+	errInsufficient   = "insufficient_scope"
+	// errDescription is the error description of failures whose details are not for the client,
+	// ie: errors of the SecretFunc or of the stores
+	errDescription = "token cannot be verified"
+)
+
+// describedErrors are the errors about the request or its token that are safe to report to the client.
+var describedErrors = map[error]bool{
+	consts.ErrMalformedAuthorization:   true,
+	consts.ErrEmptyToken:               true,
+	consts.ErrIncompleteToken:          true,
+	consts.ErrTokenTooLarge:            true,
+	consts.ErrInvalidTokenSize:         true,
+	consts.ErrInvalidEncodedHeader:     true,
+	consts.ErrInvalidEncodedBody:       true,
+	consts.ErrDuplicateJSONKey:         true,
+	consts.ErrUnknownHeaderField:       true,
+	consts.ErrUnknownTokenType:         true,
+	consts.ErrUnknownAlgorithm:         true,
+	consts.ErrUnknownPermission:        true,
+	consts.ErrInvalidSignature:         true,
+	consts.ErrInvalidEncryptedToken:    true,
+	consts.ErrTokenNotEncrypted:        true,
+	consts.ErrExpiredBody:              true,
+	consts.ErrInvalidTimeStamp:         true,
+	consts.ErrInvalidIssuedAt:          true,
+	consts.ErrInvalidNotBefore:         true,
+	consts.ErrTokenNotYetValid:         true,
+	consts.ErrTokenTooOld:              true,
+	consts.ErrInvalidUUID:              true,
+	consts.ErrInvalidIssuer:            true,
+	consts.ErrInvalidAudience:          true,
+	consts.ErrIssuerMismatch:           true,
+	consts.ErrAudienceMismatch:         true,
+	consts.ErrInvalidScope:             true,
+	consts.ErrInvalidAuthMethod:        true,
+	consts.ErrInvalidPrincipal:         true,
+	consts.ErrInvalidActor:             true,
+	consts.ErrInvalidConfirmation:      true,
+	consts.ErrMissingProofOfPossession: true,
+	consts.ErrInvalidProof:             true,
+	consts.ErrProofMismatch:            true,
+	consts.ErrProofReplayed:            true,
+	consts.ErrUnsupportedKey:           true,
+	consts.ErrInvalidTokenBinding:      true,
+	consts.ErrTokenBindingMismatch:     true,
+	consts.ErrMissingSession:           true,
+	consts.ErrRevokedSession:           true,
+	consts.ErrMFARequired:              true,
+	consts.ErrInvalidPermission:        true,
+	consts.ErrInvalidRequiredTokenType: true,
+	consts.ErrInsufficientScope:        true,
+	consts.ErrPrincipalNotAllowed:      true,
+	consts.ErrServiceNotAllowed:        true,
+	consts.ErrImpersonationNotAllowed:  true,
+}
+
+// SecretFunc looks up the secret used to verify the bearer token of a request.
+type SecretFunc func(r *http.Request) (*pbauth.Secret, error)
+
+// MiddlewareOption configures a BearerMiddleware.
+type MiddlewareOption func(*middlewareConfig)
+
+// middlewareConfig is the configuration of a BearerMiddleware.
+type middlewareConfig struct {
+	externalURL string
+}
+
+// WithExternalURL makes the middleware check proofs of possession against the external base URL of the service,
+// ie: "https://api.hwsc.org" behind a proxy terminating TLS, instead of the URL the request reached it with.
+func WithExternalURL(baseURL string) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.externalURL = baseURL
+	}
+}
+
+// BearerMiddleware authorizes HTTP requests carrying an "Authorization: Bearer" token.
+// Requests are checked against the requirement of their path in routes,
+// or against requirement if routes does not contain the path.
+// Failures are answered with RFC 6750 WWW-Authenticate challenges,
+// or 429 Too Many Requests while the client is locked out by a FailureTracker.
+// The authorized body is available to the next handler through FromContext.
+// Requests made with impersonation tokens are audit logged with the real actor.
+// Returns an error if a requirement is neither public nor names a token type, or an option is not valid.
+func BearerMiddleware(secret SecretFunc, requirement Requirement, routes map[string]Requirement,
+	opts ...MiddlewareOption) (func(http.Handler) http.Handler, error) {
+	if err := requirement.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateRequirements(routes); err != nil {
+		return nil, err
+	}
+	config := &middlewareConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.externalURL != "" {
+		if err := validateExternalURL(config.externalURL); err != nil {
+			return nil, err
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			required, ok := routes[r.URL.Path]
+			if !ok {
+				required = requirement
+			}
+			if required.isPublic() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, err := extractBearerToken(r)
+			if err != nil {
+				if err == consts.ErrEmptyToken {
+					// RFC 6750 3.1: no error code when the request lacks authentication information
+					writeChallenge(w, http.StatusUnauthorized, "", nil)
+					return
+				}
+				writeChallenge(w, http.StatusBadRequest, errInvalidRequest, err)
+				return
+			}
+			key, err := secret(r)
+			if err != nil {
+				writeChallenge(w, http.StatusUnauthorized, errInvalidToken, err)
+				return
+			}
+			presentation := PresentationFromRequest(r)
+			if config.externalURL != "" {
+				presentation = PresentationFromRequestWithURL(r, config.externalURL)
+			}
+			body, err := required.authorize(&pbauth.Identification{
+				Token:  token,
+				Secret: key,
+			}, presentation)
+			if err == consts.ErrLockedOut {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			if err != nil {
+				if isForbidden(err) {
+					writeChallenge(w, http.StatusForbidden, errInsufficient, err)
+					return
+				}
+				writeChallenge(w, http.StatusUnauthorized, errInvalidToken, err)
+				return
+			}
+			auditImpersonation(body, r.Method+" "+r.URL.Path)
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), body)))
+		})
+	}, nil
+}
+
+// StaticSecret makes a SecretFunc that always returns the same secret.
+func StaticSecret(secret *pbauth.Secret) SecretFunc {
+	return func(r *http.Request) (*pbauth.Secret, error) {
+		if secret == nil {
+			return nil, consts.ErrNilSecret
+		}
+		return secret, nil
+	}
+}
+
+// extractBearerToken extracts the token from the Authorization header.
+// Returns consts.ErrEmptyToken if the request has no bearer token,
+// or consts.ErrMalformedAuthorization if the header cannot be parsed.
+func extractBearerToken(r *http.Request) (string, error) {
+	values := r.Header[authorizationKey]
+	if len(values) == 0 {
+		return "", consts.ErrEmptyToken
+	}
+	if len(values) > 1 {
+		return "", consts.ErrMalformedAuthorization
+	}
+	parts := strings.SplitN(values[0], " ", 2)
+	if !strings.EqualFold(parts[0], bearerScheme) {
+		// other authentication schemes are not for us
+		return "", consts.ErrEmptyToken
+	}
+	if len(parts) != 2 {
+		return "", consts.ErrMalformedAuthorization
+	}
+	token := strings.TrimSpace(parts[1])
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", consts.ErrMalformedAuthorization
+	}
+	return token, nil
+}
+
+// writeChallenge answers the request with the status code and a Bearer WWW-Authenticate challenge.
+// The challenge only carries an error code and description if errCode is not empty.
+// Errors other than describedErrors get a generic description, so that internal details do not leak.
+func writeChallenge(w http.ResponseWriter, code int, errCode string, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", BearerRealm)
+	if errCode != "" {
+		challenge = fmt.Sprintf("%s, error=%q", challenge, errCode)
+		if err != nil {
+			description := errDescription
+			if describedErrors[err] {
+				description = err.Error()
+			}
+			challenge = fmt.Sprintf("%s, error_description=%q", challenge, description)
+		}
+	}
+	w.Header().Set(authenticateKey, challenge)
+	http.Error(w, http.StatusText(code), code)
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerMiddleware(t *testing.T) {
+	routes := map[string]Requirement{
+		"/public": {Public: true},
+		"/admin":  {TokenType: Jwt, Permission: Admin},
+	}
+	cases := []struct {
+		desc          string
+		path          string
+		authorization []string
+		expCode       int
+		expChallenge  string
+		expUUID       string
+	}{
+		{"test for public route", "/public", nil, http.StatusOK, "", ""},
+		{"test for missing authorization", "/user", nil, http.StatusUnauthorized,
+			`Bearer realm="hwsc"`, ""},
+		{"test for other authentication scheme", "/user", []string{"Basic dXNlcjpwYXNz"}, http.StatusUnauthorized,
+			`Bearer realm="hwsc"`, ""},
+		{"test for bearer without token", "/user", []string{"Bearer"}, http.StatusBadRequest,
+			`Bearer realm="hwsc", error="invalid_request", error_description="malformed authorization header"`, ""},
+		{"test for multiple authorization headers", "/user",
+			[]string{"Bearer " + valid256JWTUserTokenString, "Bearer " + valid256JWTUserTokenString},
+			http.StatusBadRequest,
+			`Bearer realm="hwsc", error="invalid_request", error_description="malformed authorization header"`, ""},
+		{"test for fake token", "/user", []string{"Bearer " + fakeToken}, http.StatusUnauthorized,
+			`Bearer realm="hwsc", error="invalid_token", error_description="invalid signature"`, ""},
+		{"test for expired token", "/user", []string{"Bearer " + expiredUserToken}, http.StatusUnauthorized,
+			`Bearer realm="hwsc", error="invalid_token", error_description="expired token"`, ""},
+		{"test for user token versus admin route", "/admin", []string{"Bearer " + valid256JWTUserTokenString},
+			http.StatusForbidden,
+			`Bearer realm="hwsc", error="insufficient_scope", error_description="unauthorized permission"`, ""},
+		{"test for user token versus default requirement", "/user", []string{"bearer " + valid256JWTUserTokenString},
+			http.StatusOK, "", validUserBody.UUID},
+		{"test for admin token versus admin route", "/admin", []string{"Bearer " + valid512JWTAdminTokenString},
+			http.StatusOK, "", validAdminBody.UUID},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := FromContext(r.Context()); ok {
+			_, _ = w.Write([]byte(body.UUID))
+		}
+	})
+	middleware, err := BearerMiddleware(StaticSecret(validSecret), Requirement{TokenType: Jwt, Permission: User}, routes)
+	assert.Nil(t, err)
+	handler := middleware(next)
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		for _, value := range c.authorization {
+			r.Header.Add("Authorization", value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, c.expCode, w.Code, c.desc)
+		assert.Equal(t, c.expChallenge, w.Header().Get("WWW-Authenticate"), c.desc)
+		if c.expCode == http.StatusOK {
+			assert.Equal(t, c.expUUID, w.Body.String(), c.desc)
+		}
+	}
+}
+
+func TestBearerMiddlewareSecret(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	requirement := Requirement{TokenType: Jwt, Permission: User}
+
+	desc := "test for failing secret lookup"
+	middleware, err := BearerMiddleware(func(r *http.Request) (*pbauth.Secret, error) {
+		return nil, errors.New("unknown key")
+	}, requirement, nil)
+	assert.Nil(t, err)
+	handler := middleware(next)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+valid256JWTUserTokenString)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, desc)
+	assert.Equal(t, `Bearer realm="hwsc", error="invalid_token", error_description="token cannot be verified"`,
+		w.Header().Get("WWW-Authenticate"), desc)
+
+	desc = "test for requirement missing its token type"
+	middleware, err = BearerMiddleware(StaticSecret(validSecret), Requirement{Permission: Admin}, nil)
+	assert.EqualError(t, err, consts.ErrInvalidRequirement.Error(), desc)
+	assert.Nil(t, middleware, desc)
+	middleware, err = BearerMiddleware(StaticSecret(validSecret), requirement,
+		map[string]Requirement{"/admin": {Scopes: []string{"docs:read"}}})
+	assert.EqualError(t, err, consts.ErrInvalidRequirement.Error(), desc)
+	assert.Nil(t, middleware, desc)
+
+	desc = "test for nil static secret"
+	_, err = StaticSecret(nil)(r)
+	assert.EqualError(t, err, consts.ErrNilSecret.Error(), desc)
+}
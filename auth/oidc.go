@@ -0,0 +1,553 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OIDCDiscoveryPath is where an OpenID provider publishes its metadata, relative to its issuer URL
+	OIDCDiscoveryPath = "/.well-known/openid-configuration"
+	// JWKSRefreshInterval is how often the keys of a provider may be reloaded when a token is signed with
+	// an unknown key, so that forged key IDs cannot flood the provider with requests
+	JWKSRefreshInterval = time.Minute
+	// DefaultOIDCTimeout bounds the requests made to a provider, by the default client and when reloading keys
+	DefaultOIDCTimeout = 10 * time.Second
+	// maxOIDCResponseSize bounds the metadata and key set documents read from a provider
+	maxOIDCResponseSize = 1 << 20
+	strHTTPS            = "https"
+)
+
+// IDTokenClaims are the verified claims of an OpenID Connect ID token.
+// Claims holds every claim of the token, ie: for provider specific claims such as groups.
+type IDTokenClaims struct {
+	Issuer          string
+	Subject         string
+	Audience        []string
+	ExpiresAt       int64
+	IssuedAt        int64
+	NotBefore       int64
+	Nonce           string
+	AuthorizedParty string
+	Email           string
+	EmailVerified   bool
+	AuthMethods     []string
+	Claims          map[string]interface{}
+}
+
+// SubjectMapper maps the external user of verified ID token claims to the body of an hwsc token,
+// ie: by looking up the user linked to the issuer and subject, or registering a new user with a ULID.
+// The body must have the user's UUID and Permission, other fields are kept.
+type SubjectMapper func(claims *IDTokenClaims) (*Body, error)
+
+// oidcMetadata is the part of the provider metadata the verifier uses.
+type oidcMetadata struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jsonWebKeySet is the key set published by a provider at its jwks_uri.
+type jsonWebKeySet struct {
+	Keys []struct {
+		proofJWK
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+	} `json:"keys"`
+}
+
+// idTokenHeader is the JOSE header of an ID token.
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// idTokenClaims are the registered claims of an ID token as encoded.
+// The audience is a string or an array of strings.
+type idTokenClaims struct {
+	Iss           string          `json:"iss"`
+	Sub           string          `json:"sub"`
+	Aud           json.RawMessage `json:"aud"`
+	Exp           int64           `json:"exp"`
+	Iat           int64           `json:"iat"`
+	Nbf           int64           `json:"nbf"`
+	Nonce         string          `json:"nonce"`
+	Azp           string          `json:"azp"`
+	Email         string          `json:"email"`
+	EmailVerified bool            `json:"email_verified"`
+	Amr           []string        `json:"amr"`
+}
+
+// OIDCOption configures an OIDCVerifier.
+type OIDCOption func(*OIDCVerifier)
+
+// WithOIDCClock makes the OIDC verifier read the current time from the clock.
+func WithOIDCClock(clock Clock) OIDCOption {
+	return func(o *OIDCVerifier) {
+		o.clock = clock
+	}
+}
+
+// WithOIDCLeeway makes the OIDC verifier tolerate a clock skew of leeway with the provider.
+// Negative leeways are ignored.
+func WithOIDCLeeway(leeway time.Duration) OIDCOption {
+	return func(o *OIDCVerifier) {
+		if leeway > 0 {
+			o.leeway = leeway
+		}
+	}
+}
+
+// WithOIDCHTTPClient makes the OIDC verifier load the provider's metadata and keys with the client,
+// ie: to set timeouts or trust a private certificate authority.
+func WithOIDCHTTPClient(client *http.Client) OIDCOption {
+	return func(o *OIDCVerifier) {
+		o.client = client
+	}
+}
+
+// OIDCVerifier verifies the ID tokens of an external OpenID Connect provider,
+// to let users log in with it and convert the login into hwsc tokens with a TokenIssuer.
+// The keys of the provider are reloaded when a token is signed with an unknown key, so key rotations are followed,
+// without blocking the verification of tokens signed with known keys.
+type OIDCVerifier struct {
+	issuer    string
+	clientID  string
+	mapper    SubjectMapper
+	clock     Clock
+	leeway    time.Duration
+	client    *http.Client
+	jwksURI   string
+	locker    sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	reload    *keyReload
+}
+
+// keyReload is a reload of the key set of a provider, shared by the lookups waiting for it.
+// Its err is set before done is closed.
+type keyReload struct {
+	done chan struct{}
+	err  error
+}
+
+// NewOIDCVerifier makes a verifier of the ID tokens the provider at the issuer URL issues to the client ID,
+// loading the provider's metadata and keys.
+// The mapper converts verified logins into hwsc token bodies.
+// Returns an error if the issuer is not an https URL, the mapper is nil, or the provider cannot be loaded.
+func NewOIDCVerifier(ctx context.Context, issuer string, clientID string, mapper SubjectMapper,
+	opts ...OIDCOption) (*OIDCVerifier, error) {
+	o := &OIDCVerifier{
+		issuer:   issuer,
+		clientID: clientID,
+		mapper:   mapper,
+		clock:    SystemClock,
+		client:   &http.Client{Timeout: DefaultOIDCTimeout},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if !isHTTPSURL(issuer) {
+		return nil, consts.ErrInvalidIssuer
+	}
+	if strings.TrimSpace(clientID) == "" {
+		return nil, consts.ErrInvalidAudience
+	}
+	if mapper == nil {
+		return nil, consts.ErrNilSubjectMapper
+	}
+	if o.client == nil {
+		return nil, consts.ErrNilInterface
+	}
+	metadata := &oidcMetadata{}
+	if err := o.fetch(ctx, strings.TrimSuffix(issuer, "/")+OIDCDiscoveryPath, metadata); err != nil {
+		return nil, err
+	}
+	// OpenID Connect Discovery 4.3: the metadata must be of the issuer it was requested from
+	if metadata.Issuer != issuer || !isHTTPSURL(metadata.JWKSURI) {
+		return nil, consts.ErrOIDCDiscovery
+	}
+	o.jwksURI = metadata.JWKSURI
+	keys, err := o.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	o.keys = keys
+	o.fetchedAt = o.clock.Now()
+	return o, nil
+}
+
+// Verify checks the signature and claims of the ID token, and that it carries the nonce
+// sent with the authentication request.
+// Returns the verified claims, or consts.ErrInvalidNonce if the nonce is empty or not the one of the token.
+func (o *OIDCVerifier) Verify(ctx context.Context, idToken string, nonce string) (*IDTokenClaims, error) {
+	if nonce == "" {
+		return nil, consts.ErrInvalidNonce
+	}
+	claims, err := o.verify(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if !constantTimeEqual(claims.Nonce, nonce) {
+		return nil, consts.ErrInvalidNonce
+	}
+	return claims, nil
+}
+
+// VerifyWithoutNonce checks the signature and claims of the ID token, but not its nonce,
+// ie: for an authorization code flow whose request did not send one.
+// Returns the verified claims, or an error if not valid.
+func (o *OIDCVerifier) VerifyWithoutNonce(ctx context.Context, idToken string) (*IDTokenClaims, error) {
+	return o.verify(ctx, idToken)
+}
+
+// verify checks the signature and claims of the ID token, except for its nonce.
+func (o *OIDCVerifier) verify(ctx context.Context, idToken string) (*IDTokenClaims, error) {
+	if len(idToken) > MaxTokenSize {
+		return nil, consts.ErrTokenTooLarge
+	}
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, consts.ErrIncompleteToken
+	}
+	header := &idTokenHeader{}
+	if err := decodeIDTokenPart(parts[0], header); err != nil {
+		return nil, err
+	}
+	key, err := o.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	// the algorithm must be the one of the key, so that a token cannot pick a weaker one such as "none"
+	if header.Alg != proofAlgorithm(key) {
+		return nil, consts.ErrInvalidSignature
+	}
+	signature, err := base64.RawURLEncoding.Strict().DecodeString(parts[2])
+	if err != nil || !verifyProofSignature(key, parts[0]+"."+parts[1], signature) {
+		return nil, consts.ErrInvalidSignature
+	}
+
+	encoded := &idTokenClaims{}
+	if err := decodeIDTokenPart(parts[1], encoded); err != nil {
+		return nil, err
+	}
+	all, err := decodeIDTokenClaims(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := &IDTokenClaims{
+		Issuer:          encoded.Iss,
+		Subject:         encoded.Sub,
+		ExpiresAt:       encoded.Exp,
+		IssuedAt:        encoded.Iat,
+		NotBefore:       encoded.Nbf,
+		Nonce:           encoded.Nonce,
+		AuthorizedParty: encoded.Azp,
+		Email:           encoded.Email,
+		EmailVerified:   encoded.EmailVerified,
+		AuthMethods:     encoded.Amr,
+		Claims:          all,
+	}
+	if claims.Audience, err = decodeAudience(encoded.Aud); err != nil {
+		return nil, err
+	}
+	if err := o.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Login verifies the ID token and its nonce, see Verify, and maps its user to the body of an hwsc token,
+// to issue with TokenIssuer.IssueSession.
+// Returns the body, or an error if the token is not valid or the mapper fails.
+func (o *OIDCVerifier) Login(ctx context.Context, idToken string, nonce string) (*Body, error) {
+	claims, err := o.Verify(ctx, idToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return o.mapBody(claims)
+}
+
+// LoginWithoutNonce is Login for an ID token verified without its nonce, see VerifyWithoutNonce.
+func (o *OIDCVerifier) LoginWithoutNonce(ctx context.Context, idToken string) (*Body, error) {
+	claims, err := o.VerifyWithoutNonce(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	return o.mapBody(claims)
+}
+
+// mapBody maps the user of the verified claims to the body of an hwsc token.
+func (o *OIDCVerifier) mapBody(claims *IDTokenClaims) (*Body, error) {
+	body, err := o.mapper(claims)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, consts.ErrNilBody
+	}
+	if err := validation.ValidateUserUUID(body.UUID); err != nil {
+		return nil, err
+	}
+	if body.Permission < NoPermission || body.Permission > Admin {
+		return nil, consts.ErrUnknownPermission
+	}
+	return body, nil
+}
+
+// validateClaims checks the issuer, audience and timestamps of the claims,
+// following OpenID Connect Core 3.1.3.7. The nonce is checked by Verify.
+func (o *OIDCVerifier) validateClaims(claims *IDTokenClaims) error {
+	if claims.Issuer != o.issuer {
+		return consts.ErrInvalidIssuer
+	}
+	if strings.TrimSpace(claims.Subject) == "" {
+		return consts.ErrInvalidIDToken
+	}
+	audience := false
+	for _, name := range claims.Audience {
+		if name == o.clientID {
+			audience = true
+		}
+	}
+	if !audience {
+		return consts.ErrInvalidAudience
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != o.clientID {
+		return consts.ErrInvalidAudience
+	}
+	now := o.clock.Now()
+	if claims.ExpiresAt == 0 || isExpired(claims.ExpiresAt, now, o.leeway) {
+		return consts.ErrExpiredBody
+	}
+	if claims.IssuedAt == 0 || claims.IssuedAt > now.Add(o.leeway).Unix() {
+		return consts.ErrInvalidIssuedAt
+	}
+	if claims.NotBefore > now.Add(o.leeway).Unix() {
+		return consts.ErrInvalidNotBefore
+	}
+	return nil
+}
+
+// key looks up the public key of the key ID, or the only key of the provider if the token has no key ID.
+// Unknown keys reload the key set, at most once per JWKSRefreshInterval.
+// The reload runs without holding the locker, lookups of unknown keys wait for it until ctx is done.
+func (o *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, reload, err := o.cachedKey(kid)
+	if key != nil || err != nil {
+		return key, err
+	}
+	select {
+	case <-reload.done:
+	case <-ctx.Done():
+		return nil, consts.ErrOIDCDiscovery
+	}
+	if reload.err != nil {
+		return nil, reload.err
+	}
+
+	o.locker.Lock()
+	defer o.locker.Unlock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, consts.ErrUnknownSigningKey
+}
+
+// cachedKey looks up the key of the key ID in the loaded keys.
+// If it is not loaded, returns the reload of the key set in progress, starting one if the refresh interval allows.
+func (o *OIDCVerifier) cachedKey(kid string) (crypto.PublicKey, *keyReload, error) {
+	o.locker.Lock()
+	defer o.locker.Unlock()
+
+	if key, ok := o.lookupKey(kid); ok {
+		return key, nil, nil
+	}
+	if o.reload != nil {
+		return nil, o.reload, nil
+	}
+	if o.clock.Now().Sub(o.fetchedAt) < JWKSRefreshInterval {
+		return nil, nil, consts.ErrUnknownSigningKey
+	}
+	o.fetchedAt = o.clock.Now()
+	o.reload = &keyReload{done: make(chan struct{})}
+	go o.reloadKeys(o.reload)
+	return nil, o.reload, nil
+}
+
+// reloadKeys loads the key set of the provider for the reload.
+// It is not bound to the context of the lookup that started it, so a cancelled request does not fail the others.
+func (o *OIDCVerifier) reloadKeys(reload *keyReload) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOIDCTimeout)
+	defer cancel()
+	keys, err := o.fetchKeys(ctx)
+
+	o.locker.Lock()
+	if err == nil {
+		o.keys = keys
+	}
+	o.reload = nil
+	o.locker.Unlock()
+
+	reload.err = err
+	close(reload.done)
+}
+
+// lookupKey finds the key of the key ID in the loaded keys, the caller holds the locker.
+func (o *OIDCVerifier) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// fetchKeys loads the key set of the provider.
+// Keys that are not for signatures or of an unsupported type are skipped.
+func (o *OIDCVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	set := &jsonWebKeySet{}
+	if err := o.fetch(ctx, o.jwksURI, set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if (jwk.Use != "" && jwk.Use != "sig") || jwk.D != "" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, consts.ErrOIDCDiscovery
+	}
+	return keys, nil
+}
+
+// fetch gets the JSON document at the URL into v.
+// Returns consts.ErrOIDCDiscovery if the document cannot be loaded.
+func (o *OIDCVerifier) fetch(ctx context.Context, uri string, v interface{}) error {
+	r, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return consts.ErrOIDCDiscovery
+	}
+	r.Header.Set("Accept", "application/json")
+	resp, err := o.client.Do(r.WithContext(ctx))
+	if err != nil {
+		return consts.ErrOIDCDiscovery
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return consts.ErrOIDCDiscovery
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOIDCResponseSize))
+	if err != nil {
+		return consts.ErrOIDCDiscovery
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return consts.ErrOIDCDiscovery
+	}
+	return nil
+}
+
+// decodeIDTokenPart decodes the registered fields of the header or claims of an ID token into v.
+// Provider keys are case sensitive, but encoding/json matches fields case insensitively,
+// so only keys spelled as registered names are decoded: {"sub":"a","Sub":"b"} has the subject "a".
+func decodeIDTokenPart(encoded string, v interface{}) error {
+	data, err := decodeIDTokenJSON(encoded)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return consts.ErrInvalidIDToken
+	}
+	for key := range fields {
+		if !isRegisteredName(key) {
+			delete(fields, key)
+		}
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return consts.ErrInvalidIDToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return consts.ErrInvalidIDToken
+	}
+	return nil
+}
+
+// decodeIDTokenClaims decodes every claim of an ID token, keyed as the provider spelled them.
+func decodeIDTokenClaims(encoded string) (map[string]interface{}, error) {
+	data, err := decodeIDTokenJSON(encoded)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]interface{})
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, consts.ErrInvalidIDToken
+	}
+	return all, nil
+}
+
+// decodeIDTokenJSON decodes a part of an ID token to JSON, rejecting keys repeated exactly.
+func decodeIDTokenJSON(encoded string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.Strict().DecodeString(encoded)
+	if err != nil {
+		return nil, consts.ErrInvalidIDToken
+	}
+	if err := checkExactDuplicateKeys(data); err != nil {
+		return nil, consts.ErrInvalidIDToken
+	}
+	return data, nil
+}
+
+// isRegisteredName checks if the key is spelled as the names of registered header parameters and claims,
+// with lowercase ASCII letters, digits and underscores only.
+func isRegisteredName(key string) bool {
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAudience decodes the aud claim, a single audience or an array of audiences.
+func decodeAudience(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, consts.ErrInvalidAudience
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var audience []string
+	if err := json.Unmarshal(raw, &audience); err != nil {
+		return nil, consts.ErrInvalidAudience
+	}
+	return audience, nil
+}
+
+// isHTTPSURL checks that the URL is an absolute https URL.
+func isHTTPSURL(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err == nil && parsed.Scheme == strHTTPS && parsed.Host != ""
+}
@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testClientID = "hwsc-app"
+
+// fakeProvider is an OpenID provider serving its metadata and keys over TLS.
+type fakeProvider struct {
+	t       *testing.T
+	server  *httptest.Server
+	locker  sync.Mutex
+	keys    map[string]crypto.Signer
+	fetches int
+	issuer  string
+	// stall, if set, holds key set requests until it is closed, after signaling stalled
+	stall   chan struct{}
+	stalled chan struct{}
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{
+		t:    t,
+		keys: make(map[string]crypto.Signer),
+	}
+	p.server = httptest.NewTLSServer(http.HandlerFunc(p.serveHTTP))
+	p.issuer = p.server.URL
+	p.addKey("rsa-1", newTestRSAKey(t))
+	return p
+}
+
+func newTestRSAKey(t *testing.T) crypto.Signer {
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	assert.Nil(t, err)
+	return key
+}
+
+func (p *fakeProvider) addKey(kid string, key crypto.Signer) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.keys[kid] = key
+}
+
+func (p *fakeProvider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.locker.Lock()
+	stall, stalled := p.stall, p.stalled
+	p.locker.Unlock()
+	if stall != nil && r.URL.Path == "/keys" {
+		stalled <- struct{}{}
+		<-stall
+	}
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	var document interface{}
+	switch r.URL.Path {
+	case OIDCDiscoveryPath:
+		document = map[string]string{"issuer": p.issuer, "jwks_uri": p.server.URL + "/keys"}
+	case "/keys":
+		p.fetches++
+		var keys []map[string]string
+		for kid, key := range p.keys {
+			jwk, err := newProofJWK(key.Public())
+			assert.Nil(p.t, err)
+			keys = append(keys, map[string]string{
+				"kid": kid, "use": "sig", "kty": jwk.Kty, "crv": jwk.Crv, "x": jwk.X, "y": jwk.Y, "n": jwk.N, "e": jwk.E,
+			})
+		}
+		document = map[string]interface{}{"keys": keys}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	assert.Nil(p.t, json.NewEncoder(w).Encode(document))
+}
+
+// sign signs the claims with the key of kid, with the algorithm of the key unless alg is given.
+func (p *fakeProvider) sign(kid string, alg string, claims map[string]interface{}) string {
+	p.locker.Lock()
+	key := p.keys[kid]
+	p.locker.Unlock()
+	if key == nil {
+		key = newTestRSAKey(p.t)
+	}
+	if alg == "" {
+		alg = proofAlgorithm(key.Public())
+	}
+	header, err := encodeProofPart(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	assert.Nil(p.t, err)
+	body, err := encodeProofPart(claims)
+	assert.Nil(p.t, err)
+	return p.signEncoded(key, header, body)
+}
+
+// signJSON signs the claims as given with the key of kid, ie: with repeated keys.
+func (p *fakeProvider) signJSON(kid string, claims string) string {
+	p.locker.Lock()
+	key := p.keys[kid]
+	p.locker.Unlock()
+	header, err := encodeProofPart(map[string]string{"alg": proofAlgorithm(key.Public()), "kid": kid, "typ": "JWT"})
+	assert.Nil(p.t, err)
+	return p.signEncoded(key, header, base64.RawURLEncoding.EncodeToString([]byte(claims)))
+}
+
+// signEncoded signs the encoded header and claims with the key.
+func (p *fakeProvider) signEncoded(key crypto.Signer, header string, body string) string {
+	signature, err := signProof(key, header+"."+body)
+	assert.Nil(p.t, err)
+	return header + "." + body + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// claims returns valid claims at now, with the overrides applied and nil overrides removed.
+func (p *fakeProvider) claims(now time.Time, overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":   p.issuer,
+		"sub":   "248289761001",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": "n-0S6_WzA2Mj",
+		"email": "jane@hwsc.org",
+		"amr":   []string{"pwd", "otp"},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func testSubjectMapper(claims *IDTokenClaims) (*Body, error) {
+	if claims.Subject != "248289761001" {
+		return &Body{}, nil
+	}
+	return &Body{UUID: testUserUUID, Permission: User, AuthMethods: claims.AuthMethods}, nil
+}
+
+func TestNewOIDCVerifier(t *testing.T) {
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	client := WithOIDCHTTPClient(provider.server.Client())
+	mismatch := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer":"https://accounts.example.com","jwks_uri":"https://accounts.example.com/keys"}`))
+	}))
+	defer mismatch.Close()
+
+	cases := []struct {
+		desc     string
+		issuer   string
+		clientID string
+		mapper   SubjectMapper
+		opts     []OIDCOption
+		expErr   error
+	}{
+		{"test for http issuer", "http://accounts.example.com", testClientID, testSubjectMapper,
+			[]OIDCOption{client}, consts.ErrInvalidIssuer},
+		{"test for blank client id", provider.issuer, " ", testSubjectMapper, []OIDCOption{client},
+			consts.ErrInvalidAudience},
+		{"test for nil mapper", provider.issuer, testClientID, nil, []OIDCOption{client}, consts.ErrNilSubjectMapper},
+		{"test for nil clock", provider.issuer, testClientID, testSubjectMapper,
+			[]OIDCOption{client, WithOIDCClock(nil)}, consts.ErrNilClock},
+		{"test for untrusted certificate", provider.issuer, testClientID, testSubjectMapper, nil,
+			consts.ErrOIDCDiscovery},
+		{"test for metadata of another issuer", mismatch.URL, testClientID, testSubjectMapper,
+			[]OIDCOption{WithOIDCHTTPClient(mismatch.Client())}, consts.ErrOIDCDiscovery},
+		{"test for valid provider", provider.issuer, testClientID, testSubjectMapper, []OIDCOption{client}, nil},
+	}
+	for _, c := range cases {
+		verifier, err := NewOIDCVerifier(context.Background(), c.issuer, c.clientID, c.mapper, c.opts...)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, verifier, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, verifier, c.desc)
+		}
+	}
+}
+
+func TestOIDCVerifierVerify(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	verifier, err := NewOIDCVerifier(context.Background(), provider.issuer, testClientID, testSubjectMapper,
+		WithOIDCHTTPClient(provider.server.Client()), WithOIDCClock(clock))
+	assert.Nil(t, err)
+	const nonce = "n-0S6_WzA2Mj"
+	valid := provider.sign("rsa-1", "", provider.claims(now, nil))
+
+	cases := []struct {
+		desc     string
+		token    string
+		nonce    string
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for valid token", valid, nonce, false, nil},
+		{"test for empty nonce", valid, "", true, consts.ErrInvalidNonce},
+		{"test for incomplete token", "a.b", nonce, true, consts.ErrIncompleteToken},
+		{"test for unknown key", provider.sign("rsa-2", "", provider.claims(now, nil)), nonce,
+			true, consts.ErrUnknownSigningKey},
+		{"test for algorithm of another key type", provider.sign("rsa-1", "ES256", provider.claims(now, nil)), nonce,
+			true, consts.ErrInvalidSignature},
+		{"test for tampered claims", valid[:len(valid)/2] + "x" + valid[len(valid)/2+1:], nonce, true, nil},
+		{"test for other issuer", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"iss": "https://accounts.example.com"})), nonce, true, consts.ErrInvalidIssuer},
+		{"test for other audience", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"aud": "other-app"})), nonce, true, consts.ErrInvalidAudience},
+		{"test for audience array", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"aud": []string{"other-app", testClientID}, "azp": testClientID})), nonce, false, nil},
+		{"test for audience array without azp", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"aud": []string{"other-app", testClientID}})), nonce, true, consts.ErrInvalidAudience},
+		{"test for expired token", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"exp": now.Add(-time.Second).Unix()})), nonce, true, consts.ErrExpiredBody},
+		{"test for missing expiration", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"exp": nil})), nonce, true, consts.ErrExpiredBody},
+		{"test for token issued in the future", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"iat": now.Add(time.Minute).Unix()})), nonce, true, consts.ErrInvalidIssuedAt},
+		{"test for missing subject", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"sub": ""})), nonce, true, consts.ErrInvalidIDToken},
+		{"test for wrong nonce", valid, "other-nonce", true, consts.ErrInvalidNonce},
+		{"test for missing nonce", provider.sign("rsa-1", "", provider.claims(now, map[string]interface{}{
+			"nonce": nil})), nonce, true, consts.ErrInvalidNonce},
+	}
+	for _, c := range cases {
+		claims, err := verifier.Verify(context.Background(), c.token, c.nonce)
+		if c.isExpErr {
+			if c.expErr != nil {
+				assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			} else {
+				assert.NotNil(t, err, c.desc)
+			}
+			assert.Nil(t, claims, c.desc)
+			continue
+		}
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, "248289761001", claims.Subject, c.desc)
+		assert.Equal(t, "jane@hwsc.org", claims.Email, c.desc)
+		assert.Equal(t, "jane@hwsc.org", claims.Claims["email"], c.desc)
+	}
+
+	desc := "test for token verified without nonce"
+	claims, err := verifier.VerifyWithoutNonce(context.Background(), provider.sign("rsa-1", "",
+		provider.claims(now, map[string]interface{}{"nonce": nil})))
+	assert.Nil(t, err, desc)
+	assert.Equal(t, "248289761001", claims.Subject, desc)
+
+	desc = "test for invalid token verified without nonce"
+	claims, err = verifier.VerifyWithoutNonce(context.Background(), provider.sign("rsa-1", "",
+		provider.claims(now, map[string]interface{}{"aud": "other-app"})))
+	assert.EqualError(t, err, consts.ErrInvalidAudience.Error(), desc)
+	assert.Nil(t, claims, desc)
+}
+
+func TestOIDCVerifierClaimKeys(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	verifier, err := NewOIDCVerifier(context.Background(), provider.issuer, testClientID, testSubjectMapper,
+		WithOIDCHTTPClient(provider.server.Client()), WithOIDCClock(NewFakeClock(now)))
+	assert.Nil(t, err)
+	const nonce = "n-0S6_WzA2Mj"
+	encoded, err := json.Marshal(provider.claims(now, map[string]interface{}{
+		"groups": []string{"staff"}, "Groups": []string{"admins"}}))
+	assert.Nil(t, err)
+	claims := strings.TrimSuffix(string(encoded), "}")
+
+	desc := "test for claims differing by case"
+	verified, err := verifier.Verify(context.Background(), provider.signJSON("rsa-1", string(encoded)), nonce)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, []interface{}{"staff"}, verified.Claims["groups"], desc)
+	assert.Equal(t, []interface{}{"admins"}, verified.Claims["Groups"], desc)
+
+	desc = "test for registered claim spelled with another case"
+	verified, err = verifier.Verify(context.Background(),
+		provider.signJSON("rsa-1", claims+`,"SUB":"attacker"}`), nonce)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, "248289761001", verified.Subject, desc)
+
+	desc = "test for repeated claim"
+	verified, err = verifier.Verify(context.Background(),
+		provider.signJSON("rsa-1", claims+`,"sub":"attacker"}`), nonce)
+	assert.EqualError(t, err, consts.ErrInvalidIDToken.Error(), desc)
+	assert.Nil(t, verified, desc)
+}
+
+func TestOIDCVerifierKeyRotation(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	verifier, err := NewOIDCVerifier(context.Background(), provider.issuer, testClientID, testSubjectMapper,
+		WithOIDCHTTPClient(provider.server.Client()), WithOIDCClock(clock))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, provider.fetches)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.Nil(t, err)
+	provider.addKey("ec-1", ecKey)
+	token := provider.sign("ec-1", "", provider.claims(now, nil))
+
+	desc := "test for keys not reloaded within the refresh interval"
+	_, err = verifier.VerifyWithoutNonce(context.Background(), token)
+	assert.EqualError(t, err, consts.ErrUnknownSigningKey.Error(), desc)
+	assert.Equal(t, 1, provider.fetches, desc)
+
+	desc = "test for rotated key loaded after the refresh interval"
+	clock.Advance(JWKSRefreshInterval)
+	_, err = verifier.VerifyWithoutNonce(context.Background(), token)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, 2, provider.fetches, desc)
+	_, err = verifier.VerifyWithoutNonce(context.Background(), token)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, 2, provider.fetches, desc)
+}
+
+func TestOIDCVerifierSlowKeyReload(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	verifier, err := NewOIDCVerifier(context.Background(), provider.issuer, testClientID, testSubjectMapper,
+		WithOIDCHTTPClient(provider.server.Client()), WithOIDCClock(clock))
+	assert.Nil(t, err)
+
+	provider.addKey("rsa-2", newTestRSAKey(t))
+	provider.locker.Lock()
+	provider.stall, provider.stalled = make(chan struct{}), make(chan struct{}, 1)
+	provider.locker.Unlock()
+	known := provider.sign("rsa-1", "", provider.claims(now, nil))
+	rotated := provider.sign("rsa-2", "", provider.claims(now, nil))
+	clock.Advance(JWKSRefreshInterval)
+
+	reloaded := make(chan error, 1)
+	go func() {
+		_, err := verifier.VerifyWithoutNonce(context.Background(), rotated)
+		reloaded <- err
+	}()
+	<-provider.stalled
+
+	desc := "test for known key verified during a reload"
+	verified := make(chan error, 1)
+	go func() {
+		_, err := verifier.VerifyWithoutNonce(context.Background(), known)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		assert.Nil(t, err, desc)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "verification blocked by the reload", desc)
+	}
+
+	desc = "test for cancelled lookup waiting for a reload"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = verifier.VerifyWithoutNonce(ctx, rotated)
+	assert.EqualError(t, err, consts.ErrOIDCDiscovery.Error(), desc)
+
+	desc = "test for rotated key loaded once the reload ends"
+	close(provider.stall)
+	assert.Nil(t, <-reloaded, desc)
+	_, err = verifier.VerifyWithoutNonce(context.Background(), rotated)
+	assert.Nil(t, err, desc)
+	provider.locker.Lock()
+	assert.Equal(t, 2, provider.fetches, desc)
+	provider.locker.Unlock()
+}
+
+func TestOIDCVerifierLogin(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	provider := newFakeProvider(t)
+	defer provider.server.Close()
+	verifier, err := NewOIDCVerifier(context.Background(), provider.issuer, testClientID, testSubjectMapper,
+		WithOIDCHTTPClient(provider.server.Client()), WithOIDCClock(clock))
+	assert.Nil(t, err)
+	const nonce = "n-0S6_WzA2Mj"
+
+	body, err := verifier.Login(context.Background(), provider.sign("rsa-1", "", provider.claims(now, nil)), nonce)
+	assert.Nil(t, err, "test for mapped subject")
+	assert.Equal(t, testUserUUID, body.UUID, "test for mapped subject")
+	assert.Equal(t, User, body.Permission, "test for mapped subject")
+
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock))
+	assert.Nil(t, err)
+	pair, err := issuer.IssueSession(body.UUID, body.Permission, "", body.AuthMethods...)
+	assert.Nil(t, err, "test for hwsc token from external login")
+	claims, err := NewVerifier(Jwt, User, WithClock(clock)).Verify(pair.Access)
+	assert.Nil(t, err, "test for hwsc token from external login")
+	assert.Equal(t, []string{"pwd", "otp"}, claims.Body().AuthMethods, "test for hwsc token from external login")
+
+	_, err = verifier.Login(context.Background(), provider.sign("rsa-1", "", provider.claims(now,
+		map[string]interface{}{"sub": "unlinked"})), nonce)
+	assert.EqualError(t, err, consts.ErrInvalidUUID.Error(), "test for mapper without uuid")
+
+	_, err = verifier.Login(context.Background(), provider.sign("rsa-1", "", provider.claims(now, nil)), "")
+	assert.EqualError(t, err, consts.ErrInvalidNonce.Error(), "test for login with empty nonce")
+
+	body, err = verifier.LoginWithoutNonce(context.Background(), provider.sign("rsa-1", "",
+		provider.claims(now, map[string]interface{}{"nonce": nil})))
+	assert.Nil(t, err, "test for login without nonce")
+	assert.Equal(t, testUserUUID, body.UUID, "test for login without nonce")
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+)
+
+// OwnerResolver looks up the uuid of the user owning the resource.
+type OwnerResolver func(resourceID string) (string, error)
+
+// BatchOwnerResolver looks up the uuids of the users owning the resources.
+// Resources without a known owner are left out of the returned map.
+type BatchOwnerResolver func(resourceIDs []string) (map[string]string, error)
+
+// AuthorizeOwner checks if the body is allowed to use a resource owned by ownerUUID.
+// Users may only use the resources they own, and admins may use every resource.
+// Returns consts.ErrNotResourceOwner if not allowed.
+func AuthorizeOwner(body *Body, ownerUUID string) error {
+	if err := validateOwnershipBody(body); err != nil {
+		return err
+	}
+	if body.Permission == Admin {
+		return nil
+	}
+	if err := validation.ValidateUserUUID(ownerUUID); err != nil {
+		return err
+	}
+	if body.UUID != ownerUUID {
+		return consts.ErrNotResourceOwner
+	}
+	return nil
+}
+
+// AuthorizeResource resolves the owner of the resource and checks if the body is allowed to use it.
+// Returns an error if the owner cannot be resolved, or consts.ErrNotResourceOwner if not allowed.
+func AuthorizeResource(body *Body, resourceID string, resolve OwnerResolver) error {
+	if err := validateOwnershipBody(body); err != nil {
+		return err
+	}
+	if resolve == nil {
+		return consts.ErrNilOwnerResolver
+	}
+	if body.Permission == Admin {
+		return nil
+	}
+	ownerUUID, err := resolve(resourceID)
+	if err != nil {
+		return err
+	}
+	return AuthorizeOwner(body, ownerUUID)
+}
+
+// AuthorizeResources checks if the body is allowed to use every resource.
+// Returns an error if the owners cannot be resolved,
+// or consts.ErrNotResourceOwner if any resource is not allowed or has no known owner.
+func AuthorizeResources(body *Body, resourceIDs []string, resolve BatchOwnerResolver) error {
+	allowed, err := FilterResources(body, resourceIDs, resolve)
+	if err != nil {
+		return err
+	}
+	if len(allowed) != len(resourceIDs) {
+		return consts.ErrNotResourceOwner
+	}
+	return nil
+}
+
+// FilterResources keeps the resources that the body is allowed to use, in their original order.
+// Resources without a known owner are left out for everyone but admins.
+// Returns an error if the owners cannot be resolved.
+func FilterResources(body *Body, resourceIDs []string, resolve BatchOwnerResolver) ([]string, error) {
+	if err := validateOwnershipBody(body); err != nil {
+		return nil, err
+	}
+	if resolve == nil {
+		return nil, consts.ErrNilOwnerResolver
+	}
+	if body.Permission == Admin {
+		return copyStrings(resourceIDs), nil
+	}
+	if len(resourceIDs) == 0 {
+		return nil, nil
+	}
+	owners, err := resolve(resourceIDs)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0, len(resourceIDs))
+	for _, resourceID := range resourceIDs {
+		if ownerUUID, ok := owners[resourceID]; ok && ownerUUID == body.UUID {
+			allowed = append(allowed, resourceID)
+		}
+	}
+	return allowed, nil
+}
+
+// validateOwnershipBody checks if the body can own resources.
+// Returns an error if the body is nil or its uuid is not valid.
+func validateOwnershipBody(body *Body) error {
+	if body == nil {
+		return consts.ErrNilBody
+	}
+	return validation.ValidateUserUUID(body.UUID)
+}
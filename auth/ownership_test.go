@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const (
+	ownerUUID = "01d3x3wm2nnrdfzp0tka2vw9dx"
+	otherUUID = "22d3x3wm2nnrdfzp0tka2vw9dx"
+)
+
+var (
+	ownerBody = &Body{UUID: ownerUUID, Permission: User}
+	otherBody = &Body{UUID: otherUUID, Permission: User}
+	adminBody = &Body{UUID: otherUUID, Permission: Admin}
+	errLookup = errors.New("lookup failed")
+	resources = map[string]string{
+		"doc-1": ownerUUID,
+		"doc-2": otherUUID,
+		"doc-3": ownerUUID,
+	}
+)
+
+func resolveOwner(resourceID string) (string, error) {
+	if resourceID == "broken" {
+		return "", errLookup
+	}
+	return resources[resourceID], nil
+}
+
+func resolveOwners(resourceIDs []string) (map[string]string, error) {
+	owners := make(map[string]string)
+	for _, resourceID := range resourceIDs {
+		if resourceID == "broken" {
+			return nil, errLookup
+		}
+		if owner, ok := resources[resourceID]; ok {
+			owners[resourceID] = owner
+		}
+	}
+	return owners, nil
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	cases := []struct {
+		desc      string
+		body      *Body
+		ownerUUID string
+		isExpErr  bool
+		expErr    error
+	}{
+		{"test for nil body", nil, ownerUUID, true, consts.ErrNilBody},
+		{"test for invalid body uuid", &Body{Permission: User}, ownerUUID, true, consts.ErrInvalidUUID},
+		{"test for invalid owner uuid", ownerBody, "abcd", true, consts.ErrInvalidUUID},
+		{"test for other user", otherBody, ownerUUID, true, consts.ErrNotResourceOwner},
+		{"test for owner", ownerBody, ownerUUID, false, nil},
+		{"test for admin", adminBody, ownerUUID, false, nil},
+	}
+	for _, c := range cases {
+		err := AuthorizeOwner(c.body, c.ownerUUID)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestAuthorizeResource(t *testing.T) {
+	cases := []struct {
+		desc       string
+		body       *Body
+		resourceID string
+		resolve    OwnerResolver
+		isExpErr   bool
+		expErr     error
+	}{
+		{"test for nil body", nil, "doc-1", resolveOwner, true, consts.ErrNilBody},
+		{"test for nil resolver", ownerBody, "doc-1", nil, true, consts.ErrNilOwnerResolver},
+		{"test for failing resolver", ownerBody, "broken", resolveOwner, true, errLookup},
+		{"test for unknown resource", ownerBody, "doc-4", resolveOwner, true, consts.ErrInvalidUUID},
+		{"test for other user", ownerBody, "doc-2", resolveOwner, true, consts.ErrNotResourceOwner},
+		{"test for owner", ownerBody, "doc-1", resolveOwner, false, nil},
+		{"test for admin", adminBody, "doc-1", resolveOwner, false, nil},
+	}
+	for _, c := range cases {
+		err := AuthorizeResource(c.body, c.resourceID, c.resolve)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestFilterResources(t *testing.T) {
+	cases := []struct {
+		desc        string
+		body        *Body
+		resourceIDs []string
+		resolve     BatchOwnerResolver
+		isExpErr    bool
+		expErr      error
+		expOutput   []string
+	}{
+		{"test for nil body", nil, []string{"doc-1"}, resolveOwners, true, consts.ErrNilBody, nil},
+		{"test for nil resolver", ownerBody, []string{"doc-1"}, nil, true, consts.ErrNilOwnerResolver, nil},
+		{"test for failing resolver", ownerBody, []string{"doc-1", "broken"}, resolveOwners, true, errLookup, nil},
+		{"test for empty list", ownerBody, nil, resolveOwners, false, nil, nil},
+		{"test for owner", ownerBody, []string{"doc-3", "doc-2", "doc-4", "doc-1"}, resolveOwners, false, nil,
+			[]string{"doc-3", "doc-1"}},
+		{"test for other user", otherBody, []string{"doc-1", "doc-2"}, resolveOwners, false, nil, []string{"doc-2"}},
+		{"test for admin", adminBody, []string{"doc-1", "doc-4"}, resolveOwners, false, nil, []string{"doc-1", "doc-4"}},
+	}
+	for _, c := range cases {
+		output, err := FilterResources(c.body, c.resourceIDs, c.resolve)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, output, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, c.expOutput, output, c.desc)
+		}
+	}
+}
+
+func TestAuthorizeResources(t *testing.T) {
+	cases := []struct {
+		desc        string
+		body        *Body
+		resourceIDs []string
+		isExpErr    bool
+		expErr      error
+	}{
+		{"test for failing resolver", ownerBody, []string{"broken"}, true, errLookup},
+		{"test for one resource of other user", ownerBody, []string{"doc-1", "doc-2"}, true, consts.ErrNotResourceOwner},
+		{"test for unknown resource", ownerBody, []string{"doc-1", "doc-4"}, true, consts.ErrNotResourceOwner},
+		{"test for all owned", ownerBody, []string{"doc-1", "doc-3"}, false, nil},
+		{"test for admin", adminBody, []string{"doc-1", "doc-2", "doc-4"}, false, nil},
+	}
+	for _, c := range cases {
+		err := AuthorizeResources(c.body, c.resourceIDs, resolveOwners)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	scopeSeparator = ":"
+	scopeWildcard  = "*"
+)
+
+var (
+	// DefaultPolicy grants every scope to the Admin role and nothing to the other roles.
+	DefaultPolicy = &Policy{
+		roles: map[string][]string{
+			strAdmin: {scopeWildcard},
+		},
+	}
+)
+
+// PolicyConfig maps role names to the scopes they grant.
+// Every Permission level implies the role named after it in PermissionStringMap, ie: "USER",
+// so existing tokens are granted the scopes of their permission level's role.
+type PolicyConfig struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// Policy expands roles into scopes and decides if a body holds the scopes required by an operation.
+// Scopes are colon separated segments, ie: "document:read".
+// A "*" segment matches any one segment, and a trailing "*" matches one or more segments,
+// so "document:*" grants "document:read" and "document:read:own", and "*" grants everything.
+type Policy struct {
+	roles map[string][]string
+}
+
+// NewPolicy makes a policy from the config.
+// Returns an error if a role or scope is not valid.
+func NewPolicy(config *PolicyConfig) (*Policy, error) {
+	if config == nil {
+		return nil, consts.ErrNilPolicyConfig
+	}
+	roles := make(map[string][]string, len(config.Roles))
+	for role, scopes := range config.Roles {
+		if strings.TrimSpace(role) == "" {
+			return nil, consts.ErrInvalidRole
+		}
+		if err := validateScopes(scopes); err != nil {
+			return nil, err
+		}
+		roles[role] = copyStrings(scopes)
+	}
+	return &Policy{
+		roles: roles,
+	}, nil
+}
+
+// LoadPolicy reads a JSON encoded PolicyConfig from the file at path and makes a policy from it.
+// Returns an error if the file cannot be read or parsed, or the config is not valid.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &PolicyConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return NewPolicy(config)
+}
+
+// Roles returns the roles of the body, including the role implied by its permission level.
+func (p *Policy) Roles(body *Body) []string {
+	if body == nil {
+		return nil
+	}
+	roles := make([]string, 0, len(body.Roles)+1)
+	if role, ok := PermissionStringMap[body.Permission]; ok {
+		roles = append(roles, role)
+	}
+	return append(roles, body.Roles...)
+}
+
+// Scopes returns the scopes granted to the body, directly or through its roles.
+func (p *Policy) Scopes(body *Body) []string {
+	if body == nil {
+		return nil
+	}
+	scopes := copyStrings(body.Scopes)
+	for _, role := range p.Roles(body) {
+		scopes = append(scopes, p.roles[role]...)
+	}
+	return scopes
+}
+
+// Authorize checks if the body is granted every required scope.
+// Returns consts.ErrInvalidScope if a required scope is not valid,
+// or consts.ErrInsufficientScope if a required scope is not granted.
+func (p *Policy) Authorize(body *Body, required ...string) error {
+	if body == nil {
+		return consts.ErrNilBody
+	}
+	if err := validateScopes(required); err != nil {
+		return err
+	}
+	granted := p.Scopes(body)
+	for _, scope := range required {
+		if !isScopeGranted(granted, scope) {
+			return consts.ErrInsufficientScope
+		}
+	}
+	return nil
+}
+
+// Require makes a Requirement that is evaluated by this policy.
+// Returns an error if the token type is missing or a required scope is not valid.
+func (p *Policy) Require(tokenType TokenType, permission Permission, scopes ...string) (Requirement, error) {
+	requirement := Requirement{
+		TokenType:  tokenType,
+		Permission: permission,
+		Scopes:     copyStrings(scopes),
+		Policy:     p,
+	}
+	if err := requirement.validate(); err != nil {
+		return Requirement{}, err
+	}
+	return requirement, nil
+}
+
+// isScopeGranted checks if any of the granted scopes matches the required scope.
+func isScopeGranted(granted []string, required string) bool {
+	for _, scope := range granted {
+		if matchScope(scope, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchScope checks if the granted scope, possibly containing wildcards, matches the required scope.
+func matchScope(granted string, required string) bool {
+	grantedSegments := strings.Split(granted, scopeSeparator)
+	requiredSegments := strings.Split(required, scopeSeparator)
+	for i, segment := range grantedSegments {
+		if segment == scopeWildcard && i == len(grantedSegments)-1 {
+			return len(requiredSegments) > i
+		}
+		if i >= len(requiredSegments) {
+			return false
+		}
+		if segment != scopeWildcard && segment != requiredSegments[i] {
+			return false
+		}
+	}
+	return len(grantedSegments) == len(requiredSegments)
+}
+
+// validateScopes checks every scope, see validateScope.
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if err := validateScope(scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateScope checks that the scope is made of non empty segments without whitespaces,
+// and that wildcards only appear as whole segments.
+// Returns consts.ErrInvalidScope if not valid.
+func validateScope(scope string) error {
+	if scope == "" || strings.ContainsAny(scope, " \t\r\n") {
+		return consts.ErrInvalidScope
+	}
+	for _, segment := range strings.Split(scope, scopeSeparator) {
+		if segment == "" {
+			return consts.ErrInvalidScope
+		}
+		if segment != scopeWildcard && strings.Contains(segment, scopeWildcard) {
+			return consts.ErrInvalidScope
+		}
+	}
+	return nil
+}
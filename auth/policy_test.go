@@ -0,0 +1,199 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testPolicyConfig = &PolicyConfig{
+		Roles: map[string][]string{
+			strUser:   {"document:read", "user:*:own"},
+			"support": {"user:read", "document:*"},
+		},
+	}
+)
+
+func TestNewPolicy(t *testing.T) {
+	cases := []struct {
+		desc     string
+		config   *PolicyConfig
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil config", nil, true, consts.ErrNilPolicyConfig},
+		{"test for empty role", &PolicyConfig{Roles: map[string][]string{" ": {"a"}}}, true, consts.ErrInvalidRole},
+		{"test for empty scope", &PolicyConfig{Roles: map[string][]string{"a": {""}}}, true, consts.ErrInvalidScope},
+		{"test for empty segment", &PolicyConfig{Roles: map[string][]string{"a": {"doc::read"}}}, true, consts.ErrInvalidScope},
+		{"test for partial wildcard", &PolicyConfig{Roles: map[string][]string{"a": {"doc:re*"}}}, true, consts.ErrInvalidScope},
+		{"test for whitespace", &PolicyConfig{Roles: map[string][]string{"a": {"doc: read"}}}, true, consts.ErrInvalidScope},
+		{"test for empty config", &PolicyConfig{}, false, nil},
+		{"test for valid config", testPolicyConfig, false, nil},
+	}
+	for _, c := range cases {
+		policy, err := NewPolicy(c.config)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, policy, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, policy, c.desc)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	validPath := filepath.Join(dir, "valid.json")
+	err = ioutil.WriteFile(validPath, []byte(`{"roles": {"support": ["user:read"]}}`), 0600)
+	assert.Nil(t, err)
+	invalidPath := filepath.Join(dir, "invalid.json")
+	err = ioutil.WriteFile(invalidPath, []byte(`{"roles": {"support": ["user::read"]}}`), 0600)
+	assert.Nil(t, err)
+	malformedPath := filepath.Join(dir, "malformed.json")
+	err = ioutil.WriteFile(malformedPath, []byte(`{"roles":`), 0600)
+	assert.Nil(t, err)
+
+	policy, err := LoadPolicy(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err, "test for missing file")
+	assert.Nil(t, policy, "test for missing file")
+
+	policy, err = LoadPolicy(malformedPath)
+	assert.NotNil(t, err, "test for malformed file")
+	assert.Nil(t, policy, "test for malformed file")
+
+	policy, err = LoadPolicy(invalidPath)
+	assert.EqualError(t, err, consts.ErrInvalidScope.Error(), "test for invalid scope")
+	assert.Nil(t, policy, "test for invalid scope")
+
+	policy, err = LoadPolicy(validPath)
+	assert.Nil(t, err, "test for valid file")
+	assert.Nil(t, policy.Authorize(&Body{Roles: []string{"support"}}, "user:read"), "test for valid file")
+}
+
+func TestMatchScope(t *testing.T) {
+	cases := []struct {
+		granted   string
+		required  string
+		expOutput bool
+	}{
+		{"document:read", "document:read", true},
+		{"document:read", "document:write", false},
+		{"document:read", "document", false},
+		{"document", "document:read", false},
+		{"document:*", "document:read", true},
+		{"document:*", "document:read:own", true},
+		{"document:*", "document", false},
+		{"*", "document:read", true},
+		{"*:read", "document:read", true},
+		{"*:read", "document:write", false},
+		{"*:read", "document:read:own", false},
+		{"user:*:own", "user:write:own", true},
+		{"user:*:own", "user:write:all", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expOutput, matchScope(c.granted, c.required), c.granted+" versus "+c.required)
+	}
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := NewPolicy(testPolicyConfig)
+	assert.Nil(t, err)
+	cases := []struct {
+		desc     string
+		policy   *Policy
+		body     *Body
+		required []string
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil body", policy, nil, []string{"document:read"}, true, consts.ErrNilBody},
+		{"test for no required scopes", policy, &Body{}, nil, false, nil},
+		{"test for user role implied by permission", policy,
+			&Body{Permission: User}, []string{"document:read"}, false, nil},
+		{"test for user role without write", policy,
+			&Body{Permission: User}, []string{"document:write"}, true, consts.ErrInsufficientScope},
+		{"test for user wildcard in the middle", policy,
+			&Body{Permission: User}, []string{"user:update:own"}, false, nil},
+		{"test for support role that is not an admin", policy,
+			&Body{Permission: UserRegistration, Roles: []string{"support"}}, []string{"user:read", "document:write"}, false, nil},
+		{"test for support role missing a scope", policy,
+			&Body{Permission: UserRegistration, Roles: []string{"support"}}, []string{"user:read", "user:write"},
+			true, consts.ErrInsufficientScope},
+		{"test for unknown role", policy,
+			&Body{Roles: []string{"unknown"}}, []string{"user:read"}, true, consts.ErrInsufficientScope},
+		{"test for scope granted directly", policy,
+			&Body{Scopes: []string{"billing:*"}}, []string{"billing:refund"}, false, nil},
+		{"test for admin in configured policy without admin role", policy,
+			&Body{Permission: Admin}, []string{"billing:refund"}, true, consts.ErrInsufficientScope},
+		{"test for admin in default policy", DefaultPolicy,
+			&Body{Permission: Admin}, []string{"billing:refund"}, false, nil},
+		{"test for user in default policy", DefaultPolicy,
+			&Body{Permission: User}, []string{"document:read"}, true, consts.ErrInsufficientScope},
+	}
+	for _, c := range cases {
+		err := c.policy.Authorize(c.body, c.required...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestRequirementScopes(t *testing.T) {
+	policy, err := NewPolicy(testPolicyConfig)
+	assert.Nil(t, err)
+	token, err := NewToken(valid256JWT, &Body{
+		UUID:                "01d3x3wm2nnrdfzp0tka2vw9dx",
+		Permission:          User,
+		ExpirationTimestamp: time.Now().UTC().Add(time.Hour).Unix(),
+		Roles:               []string{"support"},
+	}, validSecret)
+	assert.Nil(t, err)
+	id := &pbauth.Identification{
+		Token:  token,
+		Secret: validSecret,
+	}
+
+	requirement, err := policy.Require(Jwt, User, "user:read", "document:write")
+	assert.Nil(t, err, "test for granted scopes")
+	body, err := requirement.authorize(id, nil)
+	assert.Nil(t, err, "test for granted scopes")
+	assert.Equal(t, []string{"support"}, body.Roles, "test for granted scopes")
+
+	requirement, err = policy.Require(Jwt, User, "billing:read")
+	assert.Nil(t, err, "test for missing scope")
+	_, err = requirement.authorize(id, nil)
+	assert.EqualError(t, err, consts.ErrInsufficientScope.Error(), "test for missing scope")
+	assert.True(t, isForbidden(err), "test for missing scope")
+
+	_, err = Requirement{TokenType: Jwt, Permission: User, Scopes: []string{"user:read"}}.authorize(id, nil)
+	assert.EqualError(t, err, consts.ErrInsufficientScope.Error(), "test for default policy")
+
+	_, err = Requirement{TokenType: Jwt, Permission: User, Scopes: []string{"user:read"}}.authorize(
+		validAdminIdentification, nil)
+	assert.Nil(t, err, "test for admin in default policy")
+
+	for _, scope := range []string{"", "docs:*:", "docs::read", "docs:re*", "docs read"} {
+		desc := "test for malformed required scope " + scope
+		_, err = policy.Require(Jwt, User, scope)
+		assert.EqualError(t, err, consts.ErrInvalidScope.Error(), desc)
+		assert.EqualError(t, Requirement{TokenType: Jwt, Scopes: []string{scope}}.validate(),
+			consts.ErrInvalidScope.Error(), desc)
+		assert.EqualError(t, policy.Authorize(&Body{Permission: Admin}, scope), consts.ErrInvalidScope.Error(), desc)
+	}
+
+	desc := "test for requirement without token type"
+	_, err = policy.Require(NoType, User, "user:read")
+	assert.EqualError(t, err, consts.ErrInvalidRequirement.Error(), desc)
+}
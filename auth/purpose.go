@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/hmac"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"strings"
+)
+
+// PurposeOption configures a PurposeIssuer.
+type PurposeOption func(*PurposeIssuer)
+
+// WithPurposeClock makes the purpose issuer read the current time from the clock.
+func WithPurposeClock(clock Clock) PurposeOption {
+	return func(p *PurposeIssuer) {
+		p.clock = clock
+	}
+}
+
+// WithPurposeLifetimePolicy makes the purpose issuer expire tokens according to the policy.
+func WithPurposeLifetimePolicy(policy *LifetimePolicy) PurposeOption {
+	return func(p *PurposeIssuer) {
+		p.lifetimes = policy
+	}
+}
+
+// PurposeIssuer issues and verifies single use tokens bound to a purpose:
+// password reset (Jpt), email change (Jct) and passwordless login (Jmt).
+// Every token is bound to a value of the user, ie: the current password hash or email,
+// so that the token dies when that value changes.
+// Only the most recently issued token of a purpose can be used, and only once.
+type PurposeIssuer struct {
+	secret    *pbauth.Secret
+	store     VerificationStore
+	clock     Clock
+	lifetimes *LifetimePolicy
+}
+
+// NewPurposeIssuer makes an issuer that signs purpose tokens with the secret
+// and records them in the store.
+// Returns an error if the secret is not valid or the store is nil.
+func NewPurposeIssuer(secret *pbauth.Secret, store VerificationStore, opts ...PurposeOption) (*PurposeIssuer, error) {
+	p := &PurposeIssuer{
+		secret:    secret,
+		store:     store,
+		clock:     SystemClock,
+		lifetimes: DefaultLifetimePolicy,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if p.lifetimes == nil {
+		return nil, consts.ErrNilLifetimePolicy
+	}
+	if err := validateSecret(secret, p.clock.Now(), 0); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, consts.ErrNilVerificationStore
+	}
+	return p, nil
+}
+
+// Issue generates a token of the purpose tokenType for the user's uuid and permission,
+// bound to the value, and supersedes the tokens of the purpose issued to the user before.
+// Returns an identification containing the secret and token string.
+func (p *PurposeIssuer) Issue(tokenType TokenType, uuid string, permission Permission,
+	value string) (*pbauth.Identification, error) {
+	if !isPurposeTokenType(tokenType) {
+		return nil, consts.ErrInvalidRequiredTokenType
+	}
+	if err := validation.ValidateUserUUID(uuid); err != nil {
+		return nil, err
+	}
+	if permission < NoPermission || permission > Admin {
+		return nil, consts.ErrUnknownPermission
+	}
+	binding, err := p.bind(value)
+	if err != nil {
+		return nil, err
+	}
+	now := p.clock.Now()
+	tokenID, err := generateID(now)
+	if err != nil {
+		return nil, err
+	}
+	expiration, err := p.lifetimes.Expiration(tokenType, permission, now)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newToken(
+		&Header{
+			Alg:      AlgorithmMap[permission],
+			TokenTyp: tokenType,
+		},
+		&Body{
+			UUID:                uuid,
+			Permission:          permission,
+			ExpirationTimestamp: expiration.Unix(),
+			IssuedAt:            now.Unix(),
+			ID:                  tokenID,
+			Binding:             binding,
+		},
+		p.secret,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.store.Record(purposeSubject(tokenType, uuid), tokenID); err != nil {
+		return nil, err
+	}
+	return &pbauth.Identification{
+		Token:  token,
+		Secret: p.secret,
+	}, nil
+}
+
+// Verify authorizes the token of the purpose tokenType, checks that it is bound to the value,
+// and consumes it.
+// Returns the verified claims, or an error if not valid, superseded or already used.
+func (p *PurposeIssuer) Verify(tokenType TokenType, token string, value string) (*Claims, error) {
+	if !isPurposeTokenType(tokenType) {
+		return nil, consts.ErrInvalidRequiredTokenType
+	}
+	v := &Verifier{
+		tokenRequired: tokenType,
+		clock:         p.clock,
+	}
+	claims, err := v.Verify(&pbauth.Identification{
+		Token:  token,
+		Secret: p.secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := claims.body
+	if strings.TrimSpace(body.ID) == "" {
+		return nil, consts.ErrInvalidVerificationToken
+	}
+	binding, err := p.bind(value)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(body.Binding), []byte(binding)) {
+		return nil, consts.ErrTokenBindingMismatch
+	}
+	if err := p.store.Consume(purposeSubject(tokenType, body.UUID), body.ID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// bind hashes the value with the secret of the issuer,
+// so that the token does not reveal the value it is bound to.
+func (p *PurposeIssuer) bind(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", consts.ErrInvalidTokenBinding
+	}
+	return hashSignature(Hs256, value, p.secret)
+}
+
+// isPurposeTokenType checks if the token type is bound to a purpose.
+func isPurposeTokenType(tokenType TokenType) bool {
+	return tokenType == Jpt || tokenType == Jct || tokenType == Jmt
+}
+
+// purposeSubject keys the store by purpose, so that a user can hold a token of every purpose.
+func purposeSubject(tokenType TokenType, uuid string) string {
+	return TokenTypeStringMap[tokenType] + ":" + uuid
+}
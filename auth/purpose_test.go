@@ -0,0 +1,142 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewPurposeIssuer(t *testing.T) {
+	cases := []struct {
+		desc     string
+		secret   *pbauth.Secret
+		store    VerificationStore
+		opts     []PurposeOption
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil secret", nil, NewMemoryVerificationStore(), nil, true, consts.ErrNilSecret},
+		{"test for nil store", validSecret, nil, nil, true, consts.ErrNilVerificationStore},
+		{"test for nil clock", validSecret, NewMemoryVerificationStore(),
+			[]PurposeOption{WithPurposeClock(nil)}, true, consts.ErrNilClock},
+		{"test for nil lifetime policy", validSecret, NewMemoryVerificationStore(),
+			[]PurposeOption{WithPurposeLifetimePolicy(nil)}, true, consts.ErrNilLifetimePolicy},
+		{"test for valid input", validSecret, NewMemoryVerificationStore(), nil, false, nil},
+	}
+	for _, c := range cases {
+		p, err := NewPurposeIssuer(c.secret, c.store, c.opts...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, p, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, p, c.desc)
+		}
+	}
+}
+
+func TestPurposeIssuerIssue(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	p, err := NewPurposeIssuer(validSecret, NewMemoryVerificationStore(), WithPurposeClock(NewFakeClock(now)))
+	assert.Nil(t, err)
+	uuid := "01d3x3wm2nnrdfzp0tka2vw9dx"
+	cases := []struct {
+		desc          string
+		tokenType     TokenType
+		uuid          string
+		permission    Permission
+		value         string
+		isExpErr      bool
+		expErr        error
+		expExpiration time.Time
+	}{
+		{"test for access token type", Jwt, uuid, User, "hash", true, consts.ErrInvalidRequiredTokenType, time.Time{}},
+		{"test for email token type", Jet, uuid, User, "hash", true, consts.ErrInvalidRequiredTokenType, time.Time{}},
+		{"test for invalid uuid", Jpt, "", User, "hash", true, consts.ErrInvalidUUID, time.Time{}},
+		{"test for unknown permission", Jpt, uuid, Admin + 1, "hash", true, consts.ErrUnknownPermission, time.Time{}},
+		{"test for empty binding", Jpt, uuid, User, " ", true, consts.ErrInvalidTokenBinding, time.Time{}},
+		{"test for password reset", Jpt, uuid, User, "hash", false, nil, now.Add(time.Hour)},
+		{"test for email change", Jct, uuid, User, "hwsc@test.com", false, nil, now.Add(24 * time.Hour)},
+		{"test for magic link", Jmt, uuid, Admin, "hwsc@test.com", false, nil, now.Add(15 * time.Minute)},
+	}
+	for _, c := range cases {
+		id, err := p.Issue(c.tokenType, c.uuid, c.permission, c.value)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, id, c.desc)
+			continue
+		}
+		assert.Nil(t, err, c.desc)
+		claims, err := NewVerifier(c.tokenType, c.permission, WithClock(NewFakeClock(now))).Verify(id)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expExpiration.Unix(), claims.Body().ExpirationTimestamp, c.desc)
+		assert.NotEmpty(t, claims.Body().ID, c.desc)
+		assert.NotContains(t, claims.Body().Binding, c.value, c.desc)
+
+		// a purpose token cannot be used as an access token
+		_, err = NewVerifier(Jwt, NoPermission, WithClock(NewFakeClock(now))).Verify(id)
+		assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), c.desc)
+	}
+}
+
+func TestPurposeIssuerVerify(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	p, err := NewPurposeIssuer(validSecret, NewMemoryVerificationStore(), WithPurposeClock(clock))
+	assert.Nil(t, err)
+	uuid := "01d3x3wm2nnrdfzp0tka2vw9dx"
+	passwordHash := "$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA"
+
+	desc := "test for verifying with another purpose"
+	reset, err := p.Issue(Jpt, uuid, User, passwordHash)
+	assert.Nil(t, err, desc)
+	_, err = p.Verify(Jmt, reset.GetToken(), passwordHash)
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), desc)
+	_, err = p.Verify(Jwt, reset.GetToken(), passwordHash)
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), desc)
+
+	desc = "test for changed password"
+	_, err = p.Verify(Jpt, reset.GetToken(), passwordHash+"changed")
+	assert.EqualError(t, err, consts.ErrTokenBindingMismatch.Error(), desc)
+	_, err = p.Verify(Jpt, reset.GetToken(), "")
+	assert.EqualError(t, err, consts.ErrInvalidTokenBinding.Error(), desc)
+
+	desc = "test for other purposes being unaffected"
+	login, err := p.Issue(Jmt, uuid, User, "hwsc@test.com")
+	assert.Nil(t, err, desc)
+
+	desc = "test for single use"
+	claims, err := p.Verify(Jpt, reset.GetToken(), passwordHash)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, uuid, claims.Body().UUID, desc)
+	_, err = p.Verify(Jpt, reset.GetToken(), passwordHash)
+	assert.EqualError(t, err, consts.ErrVerificationTokenConsumed.Error(), desc)
+
+	desc = "test for superseded token"
+	first, err := p.Issue(Jct, uuid, User, "hwsc@test.com")
+	assert.Nil(t, err, desc)
+	second, err := p.Issue(Jct, uuid, User, "hwsc@test.com")
+	assert.Nil(t, err, desc)
+	_, err = p.Verify(Jct, first.GetToken(), "hwsc@test.com")
+	assert.EqualError(t, err, consts.ErrVerificationTokenSuperseded.Error(), desc)
+	_, err = p.Verify(Jct, second.GetToken(), "hwsc@test.com")
+	assert.Nil(t, err, desc)
+
+	desc = "test for expired magic link"
+	clock.Advance(15 * time.Minute)
+	_, err = p.Verify(Jmt, login.GetToken(), "hwsc@test.com")
+	assert.EqualError(t, err, consts.ErrExpiredBody.Error(), desc)
+
+	desc = "test for token signed with another secret"
+	other, err := NewPurposeIssuer(&pbauth.Secret{
+		Key:                 "7B5oPa2CjBTtX1iP8NJnGrdlRk3sbUk-F6PgBlTx3gk=",
+		CreatedTimestamp:    validCreatedTimestamp,
+		ExpirationTimestamp: validExpirationTimestamp,
+	}, NewMemoryVerificationStore(), WithPurposeClock(clock))
+	assert.Nil(t, err, desc)
+	forged, err := other.Issue(Jpt, uuid, User, passwordHash)
+	assert.Nil(t, err, desc)
+	_, err = p.Verify(Jpt, forged.GetToken(), passwordHash)
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), desc)
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+	"sync"
+)
+
+// TokenPair contains a short-lived access token and the long-lived refresh token used to renew it.
+type TokenPair struct {
+	Access  *pbauth.Identification
+	Refresh *pbauth.Identification
+}
+
+// RefreshStore keeps track of the current refresh token of every token family.
+// A token family is the chain of refresh tokens rotated from a single login.
+type RefreshStore interface {
+	// CreateFamily registers a new family with tokenID as its current refresh token.
+	// Returns consts.ErrDuplicateTokenFamily if the family already exists.
+	CreateFamily(familyID string, tokenID string) error
+	// Rotate replaces the current refresh token of the family with nextID.
+	// If presentedID is not the current refresh token, the family is revoked
+	// and consts.ErrRefreshTokenReused is returned.
+	Rotate(familyID string, presentedID string, nextID string) error
+	// RevokeFamily revokes every refresh token of the family.
+	RevokeFamily(familyID string) error
+}
+
+// IssuerOption configures a TokenIssuer.
+type IssuerOption func(*TokenIssuer)
+
+// WithIssuerClock makes the issuer read the current time from the clock.
+func WithIssuerClock(clock Clock) IssuerOption {
+	return func(i *TokenIssuer) {
+		i.clock = clock
+	}
+}
+
+// WithIssuerClaims makes the issuer stamp its name on every token
+// and the audiences on the access tokens.
+// Refresh tokens are only meant for the issuer itself.
+func WithIssuerClaims(issuer string, audience ...string) IssuerOption {
+	return func(i *TokenIssuer) {
+		i.issuer = issuer
+		i.audience = audience
+	}
+}
+
+// WithLifetimePolicy makes the issuer expire tokens according to the policy,
+// instead of DefaultIssuerLifetimePolicy.
+func WithLifetimePolicy(policy *LifetimePolicy) IssuerOption {
+	return func(i *TokenIssuer) {
+		i.lifetimes = policy
+	}
+}
+
+// TokenIssuer issues access and refresh tokens signed with its secret.
+type TokenIssuer struct {
+	secret        *pbauth.Secret
+	store         RefreshStore
+	clock         Clock
+	lifetimes     *LifetimePolicy
+	issuer        string
+	audience      []string
+	impersonation *ImpersonationPolicy
+	sessions      SessionStore
+	sessionsSet   bool
+}
+
+// NewTokenIssuer makes an issuer that signs tokens with the secret
+// and records token families in the store.
+// Returns an error if the secret is not valid or the store is nil.
+func NewTokenIssuer(secret *pbauth.Secret, store RefreshStore, opts ...IssuerOption) (*TokenIssuer, error) {
+	i := &TokenIssuer{
+		secret:        secret,
+		store:         store,
+		clock:         SystemClock,
+		lifetimes:     DefaultIssuerLifetimePolicy,
+		impersonation: DefaultImpersonationPolicy,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if i.lifetimes == nil {
+		return nil, consts.ErrNilLifetimePolicy
+	}
+	if i.impersonation == nil {
+		return nil, consts.ErrNilImpersonationPolicy
+	}
+	if i.sessionsSet && i.sessions == nil {
+		return nil, consts.ErrNilSessionStore
+	}
+	if err := validateSecret(secret, i.clock.Now(), 0); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, consts.ErrNilRefreshStore
+	}
+	if i.issuer != "" && strings.TrimSpace(i.issuer) == "" {
+		return nil, consts.ErrInvalidIssuer
+	}
+	for _, audience := range i.audience {
+		if strings.TrimSpace(audience) == "" {
+			return nil, consts.ErrInvalidAudience
+		}
+	}
+	return i, nil
+}
+
+// Issue starts a new token family for the user's uuid and permission.
+// Returns the access and refresh tokens, or an error if issuing fails.
+func (i *TokenIssuer) Issue(uuid string, permission Permission) (*TokenPair, error) {
+	return i.IssueSession(uuid, permission, "")
+}
+
+// Exchange trades a refresh token for a new access token and a rotated refresh token.
+// Replaying a refresh token that was already exchanged revokes the whole token family.
+// Returns the new tokens, or an error if the refresh token or its session is not valid.
+func (i *TokenIssuer) Exchange(refreshToken string) (*TokenPair, error) {
+	body, err := i.validateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if i.sessions != nil {
+		if err := checkSession(i.sessions, body, i.clock.Now(), 0); err != nil {
+			return nil, err
+		}
+	}
+	nextID, err := generateID(i.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	// sign before rotating, so that a failure does not leave the family on a token that was never issued
+	pair, err := i.newTokenPair(body.UUID, body.Permission, body.FamilyID, nextID, body.AuthMethods)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.store.Rotate(body.FamilyID, body.ID, nextID); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Revoke revokes the token family and the session of the refresh token, ie: during logout.
+// Returns an error if the refresh token is not valid.
+func (i *TokenIssuer) Revoke(refreshToken string) error {
+	body, err := i.validateRefreshToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	if i.sessions != nil && body.Session != "" {
+		if err := i.sessions.Revoke(body.Session); err != nil && err != consts.ErrUnknownSession {
+			return err
+		}
+	}
+	return i.store.RevokeFamily(body.FamilyID)
+}
+
+// validateRefreshToken authorizes the refresh token against the issuer's secret.
+// Returns the body of the refresh token, or an error if not valid.
+func (i *TokenIssuer) validateRefreshToken(refreshToken string) (*Body, error) {
+	v := &Verifier{
+		tokenRequired: Jrt,
+		clock:         i.clock,
+		issuer:        i.issuer,
+	}
+	claims, err := v.Verify(&pbauth.Identification{
+		Token:  refreshToken,
+		Secret: i.secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	body := claims.Body()
+	if strings.TrimSpace(body.ID) == "" || strings.TrimSpace(body.FamilyID) == "" {
+		return nil, consts.ErrInvalidRefreshToken
+	}
+	return body, nil
+}
+
+// newTokenPair signs an access token and a refresh token identified by tokenID.
+// The tokens carry the family as their session if the issuer tracks sessions,
+// and the authentication methods of the login so that refreshing keeps them.
+// Returns the tokens, or an error if signing fails.
+func (i *TokenIssuer) newTokenPair(uuid string, permission Permission, familyID string, tokenID string,
+	methods []string) (*TokenPair, error) {
+	now := i.clock.Now()
+	var session string
+	if i.sessions != nil {
+		session = familyID
+	}
+	accessExpiration, err := i.lifetimes.Expiration(Jwt, permission, now)
+	if err != nil {
+		return nil, err
+	}
+	refreshExpiration, err := i.lifetimes.Expiration(Jrt, permission, now)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := newToken(
+		&Header{
+			Alg:      AlgorithmMap[permission],
+			TokenTyp: Jwt,
+		},
+		&Body{
+			UUID:                uuid,
+			Permission:          permission,
+			ExpirationTimestamp: accessExpiration.Unix(),
+			IssuedAt:            now.Unix(),
+			Issuer:              i.issuer,
+			Audience:            i.audience,
+			Session:             session,
+			AuthMethods:         methods,
+		},
+		i.secret,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newToken(
+		&Header{
+			Alg:      AlgorithmMap[permission],
+			TokenTyp: Jrt,
+		},
+		&Body{
+			UUID:                uuid,
+			Permission:          permission,
+			ExpirationTimestamp: refreshExpiration.Unix(),
+			IssuedAt:            now.Unix(),
+			Issuer:              i.issuer,
+			ID:                  tokenID,
+			FamilyID:            familyID,
+			Session:             session,
+			AuthMethods:         methods,
+		},
+		i.secret,
+		now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		Access: &pbauth.Identification{
+			Token:  accessToken,
+			Secret: i.secret,
+		},
+		Refresh: &pbauth.Identification{
+			Token:  refreshToken,
+			Secret: i.secret,
+		},
+	}, nil
+}
+
+// refreshFamily is the state of a token family kept by memoryRefreshStore.
+type refreshFamily struct {
+	currentID string
+	revoked   bool
+}
+
+// memoryRefreshStore is an in memory RefreshStore.
+type memoryRefreshStore struct {
+	locker   sync.Mutex
+	families map[string]*refreshFamily
+}
+
+// NewMemoryRefreshStore makes an in-memory RefreshStore.
+// Token families are lost when the process exits.
+func NewMemoryRefreshStore() RefreshStore {
+	return &memoryRefreshStore{
+		families: make(map[string]*refreshFamily),
+	}
+}
+
+// CreateFamily registers a new family with tokenID as its current refresh token.
+func (s *memoryRefreshStore) CreateFamily(familyID string, tokenID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if _, ok := s.families[familyID]; ok {
+		return consts.ErrDuplicateTokenFamily
+	}
+	s.families[familyID] = &refreshFamily{
+		currentID: tokenID,
+	}
+	return nil
+}
+
+// Rotate replaces the current refresh token of the family with nextID.
+func (s *memoryRefreshStore) Rotate(familyID string, presentedID string, nextID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	family, ok := s.families[familyID]
+	if !ok {
+		return consts.ErrUnknownTokenFamily
+	}
+	if family.revoked {
+		return consts.ErrRevokedTokenFamily
+	}
+	if family.currentID != presentedID {
+		family.revoked = true
+		return consts.ErrRefreshTokenReused
+	}
+	family.currentID = nextID
+	return nil
+}
+
+// RevokeFamily revokes every refresh token of the family.
+func (s *memoryRefreshStore) RevokeFamily(familyID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	family, ok := s.families[familyID]
+	if !ok {
+		return consts.ErrUnknownTokenFamily
+	}
+	family.revoked = true
+	return nil
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/oklog/ulid"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewTokenIssuer(t *testing.T) {
+	cases := []struct {
+		desc     string
+		secret   *pbauth.Secret
+		store    RefreshStore
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil secret", nil, NewMemoryRefreshStore(), true, consts.ErrNilSecret},
+		{"test for empty secret", &pbauth.Secret{}, NewMemoryRefreshStore(), true, consts.ErrEmptySecret},
+		{"test for nil store", validSecret, nil, true, consts.ErrNilRefreshStore},
+		{"test for secret created after the clock", validSecret, NewMemoryRefreshStore(), true,
+			consts.ErrInvalidSecretCreateTimestamp},
+		{"test for valid input", validSecret, NewMemoryRefreshStore(), false, nil},
+	}
+	for _, c := range cases {
+		clock := SystemClock
+		if c.expErr == consts.ErrInvalidSecretCreateTimestamp {
+			clock = NewFakeClock(time.Unix(validCreatedTimestamp, 0).Add(-time.Hour))
+		}
+		issuer, err := NewTokenIssuer(c.secret, c.store, WithIssuerClock(clock))
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, issuer, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, issuer, c.desc)
+		}
+	}
+}
+
+func TestTokenIssuerIssue(t *testing.T) {
+	cases := []struct {
+		desc       string
+		uuid       string
+		permission Permission
+		isExpErr   bool
+		expErr     error
+	}{
+		{"test for invalid uuid", "", User, true, consts.ErrInvalidUUID},
+		{"test for unknown permission", "01d3x3wm2nnrdfzp0tka2vw9dx", Admin + 1, true, consts.ErrUnknownPermission},
+		{"test for valid user", "01d3x3wm2nnrdfzp0tka2vw9dx", User, false, nil},
+		{"test for valid admin", "01d3x3wm2nnrdfzp0tka2vw9dx", Admin, false, nil},
+	}
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore())
+	assert.Nil(t, err)
+	for _, c := range cases {
+		pair, err := issuer.Issue(c.uuid, c.permission)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, pair, c.desc)
+			continue
+		}
+		assert.Nil(t, err, c.desc)
+
+		access := NewAuthority(Jwt, c.permission)
+		assert.Nil(t, access.Authorize(pair.Access), c.desc)
+		assert.Equal(t, c.uuid, access.Body().UUID, c.desc)
+		assert.Equal(t, AlgorithmMap[c.permission], access.Header().Alg, c.desc)
+
+		refresh := &Authority{header: &Header{}, body: &Body{}, tokenRequired: Jrt}
+		assert.Nil(t, refresh.Authorize(pair.Refresh), c.desc)
+		assert.NotEmpty(t, refresh.Body().ID, c.desc)
+		assert.NotEmpty(t, refresh.Body().FamilyID, c.desc)
+		assert.True(t, refresh.Body().ExpirationTimestamp > access.Body().ExpirationTimestamp, c.desc)
+
+		// a refresh token cannot be used as an access token
+		assert.EqualError(t, access.Authorize(pair.Refresh), consts.ErrInvalidRequiredTokenType.Error(), c.desc)
+	}
+}
+
+func TestTokenIssuerExchange(t *testing.T) {
+	uuid := "01d3x3wm2nnrdfzp0tka2vw9dx"
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore())
+	assert.Nil(t, err)
+
+	desc := "test for access token used as refresh token"
+	first, err := issuer.Issue(uuid, User)
+	assert.Nil(t, err, desc)
+	pair, err := issuer.Exchange(first.Access.GetToken())
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), desc)
+	assert.Nil(t, pair, desc)
+
+	desc = "test for fake refresh token"
+	pair, err = issuer.Exchange(fakeToken)
+	assert.NotNil(t, err, desc)
+	assert.Nil(t, pair, desc)
+
+	desc = "test for valid rotation"
+	second, err := issuer.Exchange(first.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	assert.NotEqual(t, first.Refresh.GetToken(), second.Refresh.GetToken(), desc)
+	third, err := issuer.Exchange(second.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+
+	desc = "test for replayed refresh token revoking the family"
+	pair, err = issuer.Exchange(first.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrRefreshTokenReused.Error(), desc)
+	assert.Nil(t, pair, desc)
+	pair, err = issuer.Exchange(third.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrRevokedTokenFamily.Error(), desc)
+	assert.Nil(t, pair, desc)
+
+	desc = "test for other families being unaffected"
+	other, err := issuer.Issue(uuid, User)
+	assert.Nil(t, err, desc)
+	_, err = issuer.Exchange(other.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+
+	desc = "test for failed signing keeping the family"
+	store := NewMemoryRefreshStore()
+	issuer, err = NewTokenIssuer(validSecret, store)
+	assert.Nil(t, err, desc)
+	failing, err := NewTokenIssuer(validSecret, store, WithLifetimePolicy(&LifetimePolicy{}))
+	assert.Nil(t, err, desc)
+	first, err = issuer.Issue(uuid, User)
+	assert.Nil(t, err, desc)
+	pair, err = failing.Exchange(first.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrUnknownTokenType.Error(), desc)
+	assert.Nil(t, pair, desc)
+	_, err = issuer.Exchange(first.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+}
+
+func TestTokenIssuerRevoke(t *testing.T) {
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore())
+	assert.Nil(t, err)
+	pair, err := issuer.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", User)
+	assert.Nil(t, err)
+
+	err = issuer.Revoke(pair.Access.GetToken())
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), "test for revoking with access token")
+
+	err = issuer.Revoke(pair.Refresh.GetToken())
+	assert.Nil(t, err, "test for revoking with refresh token")
+
+	_, err = issuer.Exchange(pair.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrRevokedTokenFamily.Error(), "test for exchanging revoked refresh token")
+}
+
+func TestMemoryRefreshStore(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	assert.Nil(t, store.CreateFamily("family", "a"))
+	assert.EqualError(t, store.CreateFamily("family", "b"), consts.ErrDuplicateTokenFamily.Error())
+	assert.EqualError(t, store.Rotate("unknown", "a", "b"), consts.ErrUnknownTokenFamily.Error())
+	assert.EqualError(t, store.RevokeFamily("unknown"), consts.ErrUnknownTokenFamily.Error())
+
+	// only one of the concurrent rotations of the same refresh token can win
+	const count = 50
+	var wg sync.WaitGroup
+	var locker sync.Mutex
+	succeeded := 0
+	wg.Add(count)
+	start := make(chan struct{})
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			next, err := generateID(time.Now())
+			assert.Nil(t, err)
+			if err := store.Rotate("family", "a", next); err == nil {
+				locker.Lock()
+				succeeded++
+				locker.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, 1, succeeded)
+	assert.EqualError(t, store.Rotate("family", "a", "c"), consts.ErrRevokedTokenFamily.Error())
+}
+
+func TestTokenIssuerClock(t *testing.T) {
+	_, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(nil))
+	assert.EqualError(t, err, consts.ErrNilClock.Error(), "test for nil clock")
+	_, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClaims(" "))
+	assert.EqualError(t, err, consts.ErrInvalidIssuer.Error(), "test for blank issuer")
+	_, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClaims("hwsc-user-svc", ""))
+	assert.EqualError(t, err, consts.ErrInvalidAudience.Error(), "test for blank audience")
+	_, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithLifetimePolicy(nil))
+	assert.EqualError(t, err, consts.ErrNilLifetimePolicy.Error(), "test for nil lifetime policy")
+
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock))
+	assert.Nil(t, err)
+	pair, err := issuer.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", User)
+	assert.Nil(t, err)
+
+	verifier := NewVerifier(Jwt, User, WithClock(clock))
+	claims, err := verifier.Verify(pair.Access)
+	assert.Nil(t, err, "test for fresh access token")
+	assert.Equal(t, clock.Now().Add(15*time.Minute).Unix(), claims.Body().ExpirationTimestamp,
+		"test for short-lived access token")
+	refreshClaims, err := (&Verifier{tokenRequired: Jrt, clock: clock}).Verify(pair.Refresh)
+	assert.Nil(t, err, "test for fresh refresh token")
+	assert.True(t, refreshClaims.Body().ExpirationTimestamp-claims.Body().ExpirationTimestamp > 13*24*60*60,
+		"test for access token expiring long before refresh token")
+	id, err := ulid.Parse(refreshClaims.Body().ID)
+	assert.Nil(t, err, "test for token id timestamped by the clock")
+	assert.Equal(t, ulid.Timestamp(clock.Now()), id.Time(), "test for token id timestamped by the clock")
+
+	clock.Advance(15 * time.Minute)
+	_, err = verifier.Verify(pair.Access)
+	assert.EqualError(t, err, consts.ErrExpiredBody.Error(), "test for expired access token")
+	pair, err = issuer.Exchange(pair.Refresh.GetToken())
+	assert.Nil(t, err, "test for refreshing expired access token")
+	_, err = verifier.Verify(pair.Access)
+	assert.Nil(t, err, "test for refreshed access token")
+
+	clock.Advance(15 * 24 * time.Hour)
+	_, err = issuer.Exchange(pair.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrExpiredBody.Error(), "test for expired refresh token")
+}
+
+func TestTokenIssuerLifetimePolicy(t *testing.T) {
+	lifetimes, err := NewLifetimePolicy(&LifetimeConfig{
+		TokenTypes: map[TokenType]Lifetime{
+			Jwt: {Duration: 15 * time.Minute},
+			Jrt: {Duration: 12 * time.Hour, Alignment: HourAlignment},
+		},
+		Permissions: map[TokenType]map[Permission]Lifetime{
+			Jwt: {Admin: {Duration: 5 * time.Minute}},
+		},
+	})
+	assert.Nil(t, err)
+	now := time.Date(2019, 6, 1, 12, 10, 0, 0, time.UTC)
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(),
+		WithIssuerClock(NewFakeClock(now)), WithLifetimePolicy(lifetimes))
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc          string
+		permission    Permission
+		expAccess     time.Time
+		expRefreshExp time.Time
+	}{
+		{"test for user", User, now.Add(15 * time.Minute), time.Date(2019, 6, 2, 1, 0, 0, 0, time.UTC)},
+		{"test for admin", Admin, now.Add(5 * time.Minute), time.Date(2019, 6, 2, 1, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		pair, err := issuer.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", c.permission)
+		assert.Nil(t, err, c.desc)
+		claims, err := NewVerifier(Jwt, c.permission, WithClock(NewFakeClock(now))).Verify(pair.Access)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expAccess.Unix(), claims.Body().ExpirationTimestamp, c.desc)
+		claims, err = (&Verifier{tokenRequired: Jrt, clock: NewFakeClock(now)}).Verify(pair.Refresh)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expRefreshExp.Unix(), claims.Body().ExpirationTimestamp, c.desc)
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+)
+
+// Requirement is the token type and permission level needed to perform an operation.
+// Scopes are additionally evaluated by Policy, or by DefaultPolicy if Policy is nil.
+// Options configure the Verifier checking the identification.
+// Public marks an operation that skips authorization, and cannot be combined with the other fields.
+type Requirement struct {
+	Public     bool
+	TokenType  TokenType
+	Permission Permission
+	Scopes     []string
+	Policy     *Policy
+	Options    []VerifierOption
+}
+
+// isPublic checks if the operation can be performed without an identification.
+func (r Requirement) isPublic() bool {
+	return r.Public
+}
+
+// validate checks that the requirement is either explicitly public, or names the required token type,
+// so that a requirement missing its token type cannot silently make an operation public.
+// Returns consts.ErrInvalidRequirement otherwise, or consts.ErrInvalidScope if a required scope is not valid.
+func (r Requirement) validate() error {
+	restricted := r.TokenType != NoType || r.Permission != NoPermission || len(r.Scopes) > 0 ||
+		r.Policy != nil || len(r.Options) > 0
+	if r.Public == restricted || (!r.Public && r.TokenType == NoType) {
+		return consts.ErrInvalidRequirement
+	}
+	return validateScopes(r.Scopes)
+}
+
+// validateRequirements checks every requirement of the map, see Requirement.validate.
+func validateRequirements(requirements map[string]Requirement) error {
+	for _, requirement := range requirements {
+		if err := requirement.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize verifies the identification using the requirement,
+// checking the proof of possession of bound tokens with the presentation.
+// Returns a copy of the authorized body, or an error if not authorized.
+func (r Requirement) authorize(id *pbauth.Identification, p *Presentation) (*Body, error) {
+	claims, err := NewVerifier(r.TokenType, r.Permission, r.Options...).VerifyPresentation(id, p)
+	if err != nil {
+		return nil, err
+	}
+	body := claims.Body()
+	if len(r.Scopes) > 0 {
+		policy := r.Policy
+		if policy == nil {
+			policy = DefaultPolicy
+		}
+		if err := policy.Authorize(body, r.Scopes...); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// isForbidden checks if the authorization error is about what the caller is allowed to do,
+// rather than about the identity of the caller.
+func isForbidden(err error) bool {
+	switch err {
+	case consts.ErrInvalidPermission, consts.ErrInvalidRequiredTokenType, consts.ErrUnregisteredOperation,
+		consts.ErrInsufficientScope, consts.ErrPrincipalNotAllowed, consts.ErrServiceNotAllowed,
+		consts.ErrImpersonationNotAllowed:
+		return true
+	default:
+		return false
+	}
+}
+
+// bodyContextKey is the context key for the authorized body.
+type bodyContextKey struct{}
+
+// NewContext returns a copy of ctx that carries the authorized body.
+func NewContext(ctx context.Context, body *Body) context.Context {
+	return context.WithValue(ctx, bodyContextKey{}, body)
+}
+
+// FromContext extracts the authorized body from ctx.
+// Returns false if ctx does not carry a body.
+func FromContext(ctx context.Context) (*Body, bool) {
+	body, ok := ctx.Value(bodyContextKey{}).(*Body)
+	return body, ok && body != nil
+}
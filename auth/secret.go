@@ -0,0 +1,373 @@
+package auth
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	secretFileVersion = 1
+	secretFileAAD     = "hwsc-secret-store"
+)
+
+// SecretStore keeps the secrets tokens are signed with.
+// A secret is active while ValidateSecret accepts it, the newest active secret signs new tokens,
+// and the other active secrets still verify the tokens they signed until they expire.
+type SecretStore interface {
+	// Get returns the newest active secret.
+	// Returns consts.ErrNoActiveSecret if every secret has expired.
+	Get() (*pbauth.Secret, error)
+	// Put adds a secret that is valid now, and discards the expired secrets.
+	// Returns consts.ErrDuplicateSecret if a secret with the same key exists.
+	Put(secret *pbauth.Secret) error
+	// Rotate generates a secret valid for lifetime from now, which becomes the newest active secret,
+	// and discards the expired secrets.
+	Rotate(lifetime time.Duration) (*pbauth.Secret, error)
+	// List returns the active secrets, newest first.
+	List() ([]*pbauth.Secret, error)
+}
+
+// SecretStoreOption configures a SecretStore.
+type SecretStoreOption func(*secretStore)
+
+// WithSecretStoreClock makes the secret store read the current time from the clock.
+func WithSecretStoreClock(clock Clock) SecretStoreOption {
+	return func(s *secretStore) {
+		s.clock = clock
+	}
+}
+
+// secretStore implements SecretStore, saving the secrets with persist after every change if set.
+type secretStore struct {
+	locker  sync.Mutex
+	clock   Clock
+	secrets []*pbauth.Secret
+	persist func([]*pbauth.Secret) error
+}
+
+// NewMemorySecretStore makes an in memory SecretStore, ie: for tests.
+// Secrets are lost when the process exits.
+func NewMemorySecretStore(opts ...SecretStoreOption) SecretStore {
+	return newSecretStore(opts)
+}
+
+// newSecretStore makes a secret store with the options.
+func newSecretStore(opts []SecretStoreOption) *secretStore {
+	s := &secretStore{
+		clock: SystemClock,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Get implements SecretStore.
+func (s *secretStore) Get() (*pbauth.Secret, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	active := s.active()
+	if len(active) == 0 {
+		return nil, consts.ErrNoActiveSecret
+	}
+	return copySecret(active[0]), nil
+}
+
+// Put implements SecretStore.
+func (s *secretStore) Put(secret *pbauth.Secret) error {
+	if err := validateSecret(secret, s.now(), 0); err != nil {
+		return err
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	for _, stored := range s.secrets {
+		if stored.GetKey() == secret.GetKey() {
+			return consts.ErrDuplicateSecret
+		}
+	}
+	return s.save(append([]*pbauth.Secret{copySecret(secret)}, s.active()...))
+}
+
+// Rotate implements SecretStore.
+func (s *secretStore) Rotate(lifetime time.Duration) (*pbauth.Secret, error) {
+	if lifetime < time.Second {
+		return nil, consts.ErrInvalidSecretLifetime
+	}
+	key, err := GenerateSecretKey(SecretByteSize)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	secret := &pbauth.Secret{
+		Key:                 key,
+		CreatedTimestamp:    now.Unix(),
+		ExpirationTimestamp: now.Add(lifetime).Unix(),
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if err := s.save(append([]*pbauth.Secret{secret}, s.active()...)); err != nil {
+		return nil, err
+	}
+	return copySecret(secret), nil
+}
+
+// List implements SecretStore.
+func (s *secretStore) List() ([]*pbauth.Secret, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	active := s.active()
+	secrets := make([]*pbauth.Secret, 0, len(active))
+	for _, secret := range active {
+		secrets = append(secrets, copySecret(secret))
+	}
+	return secrets, nil
+}
+
+// active returns the secrets ValidateSecret accepts now, newest first.
+// The caller must hold the lock.
+func (s *secretStore) active() []*pbauth.Secret {
+	now := s.now()
+	var active []*pbauth.Secret
+	for _, secret := range s.secrets {
+		if validateSecret(secret, now, 0) == nil {
+			active = append(active, secret)
+		}
+	}
+	sortSecrets(active)
+	return active
+}
+
+// save persists the secrets, then replaces the stored ones.
+// The caller must hold the lock.
+func (s *secretStore) save(secrets []*pbauth.Secret) error {
+	sortSecrets(secrets)
+	if s.persist != nil {
+		if err := s.persist(secrets); err != nil {
+			return err
+		}
+	}
+	s.secrets = secrets
+	return nil
+}
+
+// now reads the current time from the clock of the store.
+func (s *secretStore) now() time.Time {
+	if s.clock == nil {
+		return SystemClock.Now()
+	}
+	return s.clock.Now()
+}
+
+// sortSecrets sorts the secrets newest first.
+// Secrets created in the same second keep their order, so new secrets are added in front of the stored ones.
+func sortSecrets(secrets []*pbauth.Secret) {
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return secrets[i].GetCreatedTimestamp() > secrets[j].GetCreatedTimestamp()
+	})
+}
+
+// copySecret copies the fields of the secret.
+func copySecret(secret *pbauth.Secret) *pbauth.Secret {
+	return &pbauth.Secret{
+		Key:                 secret.GetKey(),
+		CreatedTimestamp:    secret.GetCreatedTimestamp(),
+		ExpirationTimestamp: secret.GetExpirationTimestamp(),
+	}
+}
+
+// secretFile is the content of a file backed SecretStore.
+// The secrets are encrypted with a random data key, which is encrypted with the master key,
+// so that the master key never encrypts more than one key per write.
+type secretFile struct {
+	Version    int    `json:"version"`
+	WrappedKey string `json:"wrapped_key"`
+	KeyNonce   string `json:"key_nonce"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// NewFileSecretStore makes a SecretStore saving the secrets encrypted at rest in the file at path,
+// with envelope encryption under the 256 bit master key, ie: from MasterKeyFromEnv or MasterKeyFromFile.
+// The file is created on the first change, and replaced atomically on every change.
+// Only one process should write the file.
+// Returns an error if the master key is not valid, or the file cannot be read or decrypted.
+func NewFileSecretStore(path string, masterKey []byte, opts ...SecretStoreOption) (SecretStore, error) {
+	if len(masterKey) != contentKeyByteSize {
+		return nil, consts.ErrInvalidMasterKey
+	}
+	key := append([]byte(nil), masterKey...)
+	s := newSecretStore(opts)
+	secrets, err := readSecretFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+	s.secrets = secrets
+	s.persist = func(secrets []*pbauth.Secret) error {
+		return writeSecretFile(path, key, secrets)
+	}
+	return s, nil
+}
+
+// MasterKeyFromEnv reads a base64 encoded 256 bit master key from the environment variable.
+// Returns consts.ErrInvalidMasterKey if the variable is not set or not a valid key.
+func MasterKeyFromEnv(name string) ([]byte, error) {
+	return decodeMasterKey(os.Getenv(name))
+}
+
+// MasterKeyFromFile reads a base64 encoded 256 bit master key from the file at path.
+// Returns an error if the file cannot be read, or consts.ErrInvalidMasterKey if it is not a valid key.
+func MasterKeyFromFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMasterKey(string(data))
+}
+
+// decodeMasterKey decodes a master key in URL safe or standard base64, such as from GenerateSecretKey.
+func decodeMasterKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	for _, encoding := range []*base64.Encoding{base64.URLEncoding, base64.StdEncoding} {
+		key, err := encoding.DecodeString(encoded)
+		if err == nil && len(key) == contentKeyByteSize {
+			return key, nil
+		}
+	}
+	return nil, consts.ErrInvalidMasterKey
+}
+
+// readSecretFile decrypts the secrets in the file at path.
+// Returns no secrets if the file does not exist yet.
+func readSecretFile(path string, masterKey []byte) ([]*pbauth.Secret, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := &secretFile{}
+	if err := json.Unmarshal(data, file); err != nil || file.Version != secretFileVersion {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	dataKey, err := openSecretFilePart(masterKey, file.KeyNonce, file.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openSecretFilePart(dataKey, file.Nonce, file.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	var secrets []*pbauth.Secret
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	for _, secret := range secrets {
+		if err := validateSecretKey(secret); err != nil {
+			return nil, consts.ErrCorruptSecretStore
+		}
+	}
+	return secrets, nil
+}
+
+// writeSecretFile encrypts the secrets with a new data key,
+// and replaces the file at path with a file readable by the owner only.
+func writeSecretFile(path string, masterKey []byte, secrets []*pbauth.Secret) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	dataKey := make([]byte, contentKeyByteSize)
+	if _, err := cryptorand.Read(dataKey); err != nil {
+		return err
+	}
+	keyNonce, wrappedKey, err := sealSecretFilePart(masterKey, dataKey)
+	if err != nil {
+		return err
+	}
+	nonce, ciphertext, err := sealSecretFilePart(dataKey, plaintext)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&secretFile{
+		Version:    secretFileVersion,
+		WrappedKey: wrappedKey,
+		KeyNonce:   keyNonce,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// sealSecretFilePart encrypts the plaintext with A256GCM under the key.
+// Returns the base64 encoded nonce and ciphertext.
+func sealSecretFilePart(key []byte, plaintext []byte) (string, string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, []byte(secretFileAAD))
+	return base64.RawURLEncoding.EncodeToString(nonce), base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// openSecretFilePart decrypts the base64 encoded ciphertext with A256GCM under the key.
+func openSecretFilePart(key []byte, encodedNonce string, encodedCiphertext string) ([]byte, error) {
+	nonce, err := base64.RawURLEncoding.Strict().DecodeString(encodedNonce)
+	if err != nil {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	ciphertext, err := base64.RawURLEncoding.Strict().DecodeString(encodedCiphertext)
+	if err != nil {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(secretFileAAD))
+	if err != nil {
+		return nil, consts.ErrCorruptSecretStore
+	}
+	return plaintext, nil
+}
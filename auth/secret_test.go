@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/base64"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecretStorePut(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemorySecretStore(WithSecretStoreClock(NewFakeClock(now)))
+	cases := []struct {
+		desc     string
+		secret   *pbauth.Secret
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil secret", nil, true, consts.ErrNilSecret},
+		{"test for empty key", &pbauth.Secret{CreatedTimestamp: now.Unix(), ExpirationTimestamp: now.Unix() + 60},
+			true, consts.ErrEmptySecret},
+		{"test for future secret", &pbauth.Secret{Key: "future", CreatedTimestamp: now.Unix() + 60,
+			ExpirationTimestamp: now.Unix() + 120}, true, consts.ErrInvalidSecretCreateTimestamp},
+		{"test for expired secret", &pbauth.Secret{Key: "expired", CreatedTimestamp: now.Unix() - 120,
+			ExpirationTimestamp: now.Unix()}, true, consts.ErrExpiredSecret},
+		{"test for valid secret", validSecret, false, nil},
+		{"test for duplicate secret", validSecret, true, consts.ErrDuplicateSecret},
+	}
+	for _, c := range cases {
+		err := store.Put(c.secret)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+	secret, err := store.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, validSecret.GetKey(), secret.GetKey())
+}
+
+func TestSecretStoreRotate(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	store := NewMemorySecretStore(WithSecretStoreClock(clock))
+
+	_, err := store.Get()
+	assert.EqualError(t, err, consts.ErrNoActiveSecret.Error(), "test for empty store")
+	_, err = store.Rotate(0)
+	assert.EqualError(t, err, consts.ErrInvalidSecretLifetime.Error(), "test for zero lifetime")
+
+	first, err := store.Rotate(48 * time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, validateSecret(first, clock.Now(), 0))
+	clock.Advance(24 * time.Hour)
+	second, err := store.Rotate(48 * time.Hour)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.GetKey(), second.GetKey())
+
+	// the newest secret signs, the previous one still verifies
+	active, err := store.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, second, active)
+	secrets, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []*pbauth.Secret{second, first}, secrets)
+
+	// the first secret expires
+	clock.Advance(24 * time.Hour)
+	secrets, err = store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []*pbauth.Secret{second}, secrets)
+
+	// every secret expires
+	clock.Advance(24 * time.Hour)
+	_, err = store.Get()
+	assert.EqualError(t, err, consts.ErrNoActiveSecret.Error())
+	secrets, err = store.List()
+	assert.Nil(t, err)
+	assert.Empty(t, secrets)
+
+	// returned secrets are copies
+	third, err := store.Rotate(time.Hour)
+	assert.Nil(t, err)
+	third.Key = "altered"
+	active, err = store.Get()
+	assert.Nil(t, err)
+	assert.NotEqual(t, "altered", active.GetKey())
+}
+
+func TestSecretStoreRotateSameSecond(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	fileStore, err := NewFileSecretStore(path, []byte("0123456789abcdef0123456789abcdef"), WithSecretStoreClock(clock))
+	assert.Nil(t, err)
+	stores := map[string]SecretStore{
+		"memory": NewMemorySecretStore(WithSecretStoreClock(clock)),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		desc := "test for rotation in the same second with " + name + " store"
+		first, err := store.Rotate(time.Hour)
+		assert.Nil(t, err, desc)
+		second, err := store.Rotate(time.Hour)
+		assert.Nil(t, err, desc)
+		assert.Equal(t, first.GetCreatedTimestamp(), second.GetCreatedTimestamp(), desc)
+		active, err := store.Get()
+		assert.Nil(t, err, desc)
+		assert.Equal(t, second, active, desc)
+		secrets, err := store.List()
+		assert.Nil(t, err, desc)
+		assert.Equal(t, []*pbauth.Secret{second, first}, secrets, desc)
+	}
+
+	desc := "test for rotation order kept by the file"
+	reopened, err := NewFileSecretStore(path, []byte("0123456789abcdef0123456789abcdef"), WithSecretStoreClock(clock))
+	assert.Nil(t, err, desc)
+	active, err := reopened.Get()
+	assert.Nil(t, err, desc)
+	expected, err := fileStore.Get()
+	assert.Nil(t, err, desc)
+	assert.Equal(t, expected, active, desc)
+}
+
+func TestFileSecretStore(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	masterKey := []byte("0123456789abcdef0123456789abcdef")
+
+	_, err := NewFileSecretStore(path, []byte("short"))
+	assert.EqualError(t, err, consts.ErrInvalidMasterKey.Error(), "test for short master key")
+
+	store, err := NewFileSecretStore(path, masterKey, WithSecretStoreClock(clock))
+	assert.Nil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "test for file created on first change")
+
+	secret, err := store.Rotate(time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Put(validSecret))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), secret.GetKey(), "test for encryption at rest")
+	assert.NotContains(t, string(data), validSecret.GetKey(), "test for encryption at rest")
+
+	reopened, err := NewFileSecretStore(path, masterKey, WithSecretStoreClock(clock))
+	assert.Nil(t, err)
+	secrets, err := reopened.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []*pbauth.Secret{secret, copySecret(validSecret)}, secrets)
+
+	_, err = NewFileSecretStore(path, []byte("fedcba9876543210fedcba9876543210"))
+	assert.EqualError(t, err, consts.ErrCorruptSecretStore.Error(), "test for wrong master key")
+	corrupt := filepath.Join(t.TempDir(), "corrupt.json")
+	assert.Nil(t, ioutil.WriteFile(corrupt, []byte(`{"version":1}`), 0600))
+	_, err = NewFileSecretStore(corrupt, masterKey)
+	assert.EqualError(t, err, consts.ErrCorruptSecretStore.Error(), "test for corrupt file")
+}
+
+func TestMasterKey(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	const env = "HWSC_TEST_MASTER_KEY"
+	defer os.Unsetenv(env)
+
+	assert.Nil(t, os.Setenv(env, base64.URLEncoding.EncodeToString(key)))
+	decoded, err := MasterKeyFromEnv(env)
+	assert.Nil(t, err, "test for url encoded key")
+	assert.Equal(t, key, decoded, "test for url encoded key")
+
+	assert.Nil(t, os.Setenv(env, base64.StdEncoding.EncodeToString(key)))
+	decoded, err = MasterKeyFromEnv(env)
+	assert.Nil(t, err, "test for std encoded key")
+	assert.Equal(t, key, decoded, "test for std encoded key")
+
+	assert.Nil(t, os.Setenv(env, base64.StdEncoding.EncodeToString(key[:16])))
+	_, err = MasterKeyFromEnv(env)
+	assert.EqualError(t, err, consts.ErrInvalidMasterKey.Error(), "test for short key")
+
+	assert.Nil(t, os.Unsetenv(env))
+	_, err = MasterKeyFromEnv(env)
+	assert.EqualError(t, err, consts.ErrInvalidMasterKey.Error(), "test for unset variable")
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(base64.URLEncoding.EncodeToString(key)+"\n"), 0600))
+	decoded, err = MasterKeyFromFile(path)
+	assert.Nil(t, err, "test for key file")
+	assert.Equal(t, key, decoded, "test for key file")
+
+	_, err = MasterKeyFromFile(filepath.Join(t.TempDir(), "missing.key"))
+	assert.NotNil(t, err, "test for missing key file")
+}
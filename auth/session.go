@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/hwsc-org/hwsc-lib/validation"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionState is whether tokens of a session are still accepted.
+type SessionState int32
+
+const (
+	// ActiveSession accepts its tokens until they expire
+	ActiveSession SessionState = iota
+	// RevokedSession rejects its tokens, ie: after the user logged out
+	RevokedSession
+)
+
+const (
+	// DefaultSessionRetention is how long the memory SessionStore keeps a session after it was last seen:
+	// the default refresh token lifetime, and a day for its alignment
+	DefaultSessionRetention = 15 * 24 * time.Hour
+	// DefaultSessionTouchInterval is how long a verifier leaves the last seen time of a session unchanged,
+	// so that verifying a token does not write to the store on every request
+	DefaultSessionTouchInterval = time.Minute
+	// sessionPruneInterval is how often the memory SessionStore discards the sessions past their retention
+	sessionPruneInterval = time.Hour
+)
+
+// Session is a login of a user on a device, shared by the tokens issued and refreshed from it.
+// The ID of a session is the family ID of its refresh tokens.
+// LastSeen is when a token of the session was last refreshed, or verified up to the touch interval of the verifier.
+type Session struct {
+	ID        string
+	UUID      string
+	Device    string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	State     SessionState
+}
+
+// SessionStore keeps track of the sessions of users.
+type SessionStore interface {
+	// Create registers the session.
+	// Returns consts.ErrDuplicateSession if the ID is taken.
+	Create(session *Session) error
+	// Session looks up the session of the ID.
+	// Returns consts.ErrUnknownSession if there is none.
+	Session(id string) (*Session, error)
+	// Touch updates when the session was last seen, if at is later.
+	// Returns consts.ErrUnknownSession if there is none.
+	Touch(id string, at time.Time) error
+	// List returns every session of the user, most recently seen first.
+	List(uuid string) ([]*Session, error)
+	// Revoke revokes the session.
+	// Returns consts.ErrUnknownSession if there is none.
+	Revoke(id string) error
+	// RevokeAll revokes every session of the user.
+	RevokeAll(uuid string) error
+}
+
+// SessionStoreOption configures the memory SessionStore.
+type SessionStoreOption func(*memorySessionStore)
+
+// WithSessionStoreClock makes the session store read the current time from the clock.
+func WithSessionStoreClock(clock Clock) SessionStoreOption {
+	return func(s *memorySessionStore) {
+		s.clock = clock
+	}
+}
+
+// WithSessionRetention makes the session store discard sessions last seen more than retention ago,
+// which must not be shorter than the lifetime of refresh tokens.
+func WithSessionRetention(retention time.Duration) SessionStoreOption {
+	return func(s *memorySessionStore) {
+		s.retention = retention
+	}
+}
+
+// memorySessionStore is an in memory SessionStore.
+type memorySessionStore struct {
+	locker    sync.Mutex
+	clock     Clock
+	retention time.Duration
+	pruneAt   time.Time
+	sessions  map[string]*Session
+	users     map[string][]string
+}
+
+// NewMemorySessionStore makes an in memory SessionStore.
+// Sessions are lost when the process exits, and discarded once they were not seen for DefaultSessionRetention:
+// the tokens of a discarded session are rejected as revoked.
+func NewMemorySessionStore(opts ...SessionStoreOption) SessionStore {
+	s := &memorySessionStore{
+		clock:     SystemClock,
+		retention: DefaultSessionRetention,
+		sessions:  make(map[string]*Session),
+		users:     make(map[string][]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// prune discards the sessions past their retention, at most once per sessionPruneInterval.
+// The caller must hold the lock.
+func (s *memorySessionStore) prune() {
+	now := SystemClock.Now()
+	if s.clock != nil {
+		now = s.clock.Now()
+	}
+	if now.Before(s.pruneAt) {
+		return
+	}
+	s.pruneAt = now.Add(sessionPruneInterval)
+	start := now.Add(-s.retention)
+	for uuid, ids := range s.users {
+		kept := ids[:0]
+		for _, id := range ids {
+			if s.sessions[id].LastSeen.Before(start) {
+				delete(s.sessions, id)
+				continue
+			}
+			kept = append(kept, id)
+		}
+		if len(kept) == 0 {
+			delete(s.users, uuid)
+			continue
+		}
+		s.users[uuid] = kept
+	}
+}
+
+// Create implements SessionStore.
+func (s *memorySessionStore) Create(session *Session) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.prune()
+	if _, ok := s.sessions[session.ID]; ok {
+		return consts.ErrDuplicateSession
+	}
+	copied := *session
+	s.sessions[session.ID] = &copied
+	s.users[session.UUID] = append(s.users[session.UUID], session.ID)
+	return nil
+}
+
+// Session implements SessionStore.
+func (s *memorySessionStore) Session(id string) (*Session, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, consts.ErrUnknownSession
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// Touch implements SessionStore.
+func (s *memorySessionStore) Touch(id string, at time.Time) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return consts.ErrUnknownSession
+	}
+	if at.After(session.LastSeen) {
+		session.LastSeen = at
+	}
+	return nil
+}
+
+// List implements SessionStore.
+func (s *memorySessionStore) List(uuid string) ([]*Session, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.prune()
+	sessions := make([]*Session, 0, len(s.users[uuid]))
+	for _, id := range s.users[uuid] {
+		copied := *s.sessions[id]
+		sessions = append(sessions, &copied)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// Revoke implements SessionStore.
+func (s *memorySessionStore) Revoke(id string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return consts.ErrUnknownSession
+	}
+	session.State = RevokedSession
+	return nil
+}
+
+// RevokeAll implements SessionStore.
+func (s *memorySessionStore) RevokeAll(uuid string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	for _, id := range s.users[uuid] {
+		s.sessions[id].State = RevokedSession
+	}
+	return nil
+}
+
+// WithIssuerSessions makes the issuer open a session in the store for every login,
+// and stamp its ID on the tokens of the login so that they can be revoked together.
+func WithIssuerSessions(store SessionStore) IssuerOption {
+	return func(i *TokenIssuer) {
+		i.sessions = store
+		i.sessionsSet = true
+	}
+}
+
+// IssueSession starts a new token family for the user's uuid and permission on the device,
+// ie: the user agent or device name reported at login.
+// The methods the user authenticated with are stamped on the tokens, ie: AuthMethodPassword, AuthMethodOTP
+// and AuthMethodMFA after a password and a TOTP code.
+// Issue is IssueSession without a device or methods.
+// Returns the access and refresh tokens, or an error if issuing fails.
+func (i *TokenIssuer) IssueSession(uuid string, permission Permission, device string,
+	methods ...string) (*TokenPair, error) {
+	if err := validation.ValidateUserUUID(uuid); err != nil {
+		return nil, err
+	}
+	if permission < NoPermission || permission > Admin {
+		return nil, consts.ErrUnknownPermission
+	}
+	if err := validateAuthMethods(methods); err != nil {
+		return nil, err
+	}
+	now := i.clock.Now()
+	familyID, err := generateID(now)
+	if err != nil {
+		return nil, err
+	}
+	tokenID, err := generateID(now)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.store.CreateFamily(familyID, tokenID); err != nil {
+		return nil, err
+	}
+	if i.sessions != nil {
+		if err := i.sessions.Create(&Session{
+			ID:        familyID,
+			UUID:      uuid,
+			Device:    device,
+			CreatedAt: now,
+			LastSeen:  now,
+			State:     ActiveSession,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return i.newTokenPair(uuid, permission, familyID, tokenID, methods)
+}
+
+// Sessions lists the active sessions of the user, most recently seen first.
+// Returns consts.ErrNilSessionStore if the issuer does not track sessions.
+func (i *TokenIssuer) Sessions(uuid string) ([]*Session, error) {
+	if i.sessions == nil {
+		return nil, consts.ErrNilSessionStore
+	}
+	sessions, err := i.sessions.List(uuid)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.State == ActiveSession {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession logs the user out of one session, rejecting its access and refresh tokens.
+// Returns consts.ErrUnknownSession if the session is not one of the user's.
+func (i *TokenIssuer) RevokeSession(uuid string, id string) error {
+	if i.sessions == nil {
+		return consts.ErrNilSessionStore
+	}
+	session, err := i.sessions.Session(id)
+	if err != nil {
+		return err
+	}
+	if session.UUID != uuid {
+		return consts.ErrUnknownSession
+	}
+	if err := i.sessions.Revoke(id); err != nil {
+		return err
+	}
+	return i.revokeFamily(id)
+}
+
+// RevokeAllSessions logs the user out everywhere, ie: after a password change.
+func (i *TokenIssuer) RevokeAllSessions(uuid string) error {
+	if i.sessions == nil {
+		return consts.ErrNilSessionStore
+	}
+	sessions, err := i.sessions.List(uuid)
+	if err != nil {
+		return err
+	}
+	if err := i.sessions.RevokeAll(uuid); err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := i.revokeFamily(session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeFamily revokes the token family of the session, if the refresh store still knows it.
+func (i *TokenIssuer) revokeFamily(id string) error {
+	if err := i.store.RevokeFamily(id); err != nil && err != consts.ErrUnknownTokenFamily {
+		return err
+	}
+	return nil
+}
+
+// checkSession checks that the session of the body is active,
+// and records it was seen at now if it was last seen touchInterval ago or more.
+// Only access and refresh tokens belong to sessions, see isSessionTokenType.
+// Returns consts.ErrMissingSession for tokens of users issued without a session,
+// or consts.ErrRevokedSession if the session was revoked or does not belong to the token.
+func checkSession(store SessionStore, body *Body, now time.Time, touchInterval time.Duration) error {
+	if body.Session == "" {
+		if body.Principal == ServicePrincipal {
+			return nil
+		}
+		return consts.ErrMissingSession
+	}
+	session, err := store.Session(body.Session)
+	if err == consts.ErrUnknownSession {
+		return consts.ErrRevokedSession
+	}
+	if err != nil {
+		return err
+	}
+	// impersonation tokens belong to the session of the actor
+	owner := body.UUID
+	if body.Actor != nil {
+		owner = body.Actor.UUID
+	}
+	if session.State != ActiveSession || session.UUID != owner {
+		return consts.ErrRevokedSession
+	}
+	if now.Sub(session.LastSeen) < touchInterval {
+		return nil
+	}
+	return store.Touch(body.Session, now)
+}
+
+// isSessionTokenType checks if tokens of the type are issued for a login, and so belong to its session.
+// Purpose tokens such as email verification or password reset tokens are not.
+func isSessionTokenType(tokenType TokenType) bool {
+	return tokenType == Jwt || tokenType == Jrt
+}
+
+// WithSessions makes the verifier reject access and refresh tokens of users without a session in the store,
+// or whose session was revoked. Service tokens and purpose tokens are not tied to sessions.
+// Tokens issued before the issuer tracked sessions carry no session and are rejected,
+// so enabling sessions logs every user out once.
+func WithSessions(store SessionStore) VerifierOption {
+	return func(v *Verifier) {
+		v.sessions = store
+	}
+}
+
+// WithSessionTouchInterval makes the verifier record that a session was seen at most once per interval,
+// instead of once per DefaultSessionTouchInterval.
+// Non-positive intervals are ignored.
+func WithSessionTouchInterval(interval time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		if interval > 0 {
+			v.sessionTouch = interval
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemorySessionStore()
+	assert.Nil(t, store.Create(&Session{ID: "laptop", UUID: testUserUUID, CreatedAt: now, LastSeen: now}))
+	assert.Nil(t, store.Create(&Session{ID: "phone", UUID: testUserUUID, CreatedAt: now, LastSeen: now}))
+	assert.Nil(t, store.Create(&Session{ID: "admin", UUID: testAdminUUID, CreatedAt: now, LastSeen: now}))
+	assert.EqualError(t, store.Create(&Session{ID: "phone", UUID: testUserUUID}),
+		consts.ErrDuplicateSession.Error(), "test for duplicate session")
+
+	desc := "test for most recently seen first"
+	assert.Nil(t, store.Touch("phone", now.Add(time.Hour)), desc)
+	assert.Nil(t, store.Touch("phone", now), desc)
+	sessions, err := store.List(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Len(t, sessions, 2, desc)
+	assert.Equal(t, "phone", sessions[0].ID, desc)
+	assert.Equal(t, now.Add(time.Hour), sessions[0].LastSeen, desc)
+	assert.Equal(t, "laptop", sessions[1].ID, desc)
+
+	desc = "test for revoking every session of a user"
+	assert.Nil(t, store.RevokeAll(testUserUUID), desc)
+	for _, id := range []string{"laptop", "phone"} {
+		session, err := store.Session(id)
+		assert.Nil(t, err, desc)
+		assert.Equal(t, RevokedSession, session.State, desc)
+	}
+	session, err := store.Session("admin")
+	assert.Nil(t, err, desc)
+	assert.Equal(t, ActiveSession, session.State, desc)
+
+	desc = "test for unknown session"
+	_, err = store.Session("tablet")
+	assert.EqualError(t, err, consts.ErrUnknownSession.Error(), desc)
+	assert.EqualError(t, store.Touch("tablet", now), consts.ErrUnknownSession.Error(), desc)
+	assert.EqualError(t, store.Revoke("tablet"), consts.ErrUnknownSession.Error(), desc)
+}
+
+func TestMemorySessionStorePrune(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	store := NewMemorySessionStore(WithSessionStoreClock(clock), WithSessionRetention(24*time.Hour))
+	assert.Nil(t, store.Create(&Session{ID: "laptop", UUID: testUserUUID, CreatedAt: now, LastSeen: now}))
+	assert.Nil(t, store.Create(&Session{ID: "phone", UUID: testUserUUID, CreatedAt: now, LastSeen: now}))
+	assert.Nil(t, store.Revoke("laptop"))
+
+	desc := "test for sessions within retention"
+	clock.Advance(12 * time.Hour)
+	assert.Nil(t, store.Touch("phone", clock.Now()), desc)
+	sessions, err := store.List(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Len(t, sessions, 2, desc)
+
+	desc = "test for sessions past retention"
+	clock.Advance(13 * time.Hour)
+	sessions, err = store.List(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Len(t, sessions, 1, desc)
+	assert.Equal(t, "phone", sessions[0].ID, desc)
+	_, err = store.Session("laptop")
+	assert.EqualError(t, err, consts.ErrUnknownSession.Error(), desc)
+	assert.EqualError(t, checkSession(store, &Body{UUID: testUserUUID, Session: "laptop"}, clock.Now(), 0),
+		consts.ErrRevokedSession.Error(), desc)
+}
+
+func TestTokenIssuerSessions(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	sessions := NewMemorySessionStore()
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithIssuerSessions(sessions))
+	assert.Nil(t, err)
+	verifier := NewVerifier(Jwt, User, WithClock(clock), WithSessions(sessions))
+
+	laptop, err := issuer.IssueSession(testUserUUID, User, "Firefox on Linux")
+	assert.Nil(t, err)
+	clock.Advance(time.Minute)
+	phone, err := issuer.IssueSession(testUserUUID, User, "hwsc for Android")
+	assert.Nil(t, err)
+	admin, err := issuer.Issue(testAdminUUID, Admin)
+	assert.Nil(t, err)
+
+	desc := "test for session stamped on the tokens"
+	claims, err := verifier.Verify(laptop.Access)
+	assert.Nil(t, err, desc)
+	laptopID := claims.Body().Session
+	assert.NotEmpty(t, laptopID, desc)
+	_, refresh, err := DecodeToken(laptop.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	assert.Equal(t, laptopID, refresh.Session, desc)
+
+	desc = "test for listing active sessions"
+	clock.Advance(time.Minute)
+	_, err = verifier.Verify(laptop.Access)
+	assert.Nil(t, err, desc)
+	list, err := issuer.Sessions(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Len(t, list, 2, desc)
+	assert.Equal(t, laptopID, list[0].ID, desc)
+	assert.Equal(t, "Firefox on Linux", list[0].Device, desc)
+	assert.Equal(t, now, list[0].CreatedAt, desc)
+	assert.Equal(t, clock.Now(), list[0].LastSeen, desc)
+	assert.Equal(t, "hwsc for Android", list[1].Device, desc)
+
+	desc = "test for refresh keeping the session"
+	rotated, err := issuer.Exchange(laptop.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	claims, err = verifier.Verify(rotated.Access)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, laptopID, claims.Body().Session, desc)
+
+	desc = "test for revoking another user's session"
+	assert.EqualError(t, issuer.RevokeSession(testAdminUUID, laptopID), consts.ErrUnknownSession.Error(), desc)
+
+	desc = "test for revoking one session"
+	assert.Nil(t, issuer.RevokeSession(testUserUUID, laptopID), desc)
+	_, err = verifier.Verify(rotated.Access)
+	assert.EqualError(t, err, consts.ErrRevokedSession.Error(), desc)
+	_, err = issuer.Exchange(rotated.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrRevokedSession.Error(), desc)
+	_, err = verifier.Verify(phone.Access)
+	assert.Nil(t, err, desc)
+	list, err = issuer.Sessions(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Len(t, list, 1, desc)
+
+	desc = "test for revoking every session"
+	assert.Nil(t, issuer.RevokeAllSessions(testUserUUID), desc)
+	_, err = verifier.Verify(phone.Access)
+	assert.EqualError(t, err, consts.ErrRevokedSession.Error(), desc)
+	list, err = issuer.Sessions(testUserUUID)
+	assert.Nil(t, err, desc)
+	assert.Empty(t, list, desc)
+	_, err = NewVerifier(Jwt, Admin, WithClock(clock), WithSessions(sessions)).Verify(admin.Access)
+	assert.Nil(t, err, desc)
+
+	desc = "test for logout revoking the session"
+	assert.Nil(t, issuer.Revoke(admin.Refresh.GetToken()), desc)
+	_, err = NewVerifier(Jwt, Admin, WithClock(clock), WithSessions(sessions)).Verify(admin.Access)
+	assert.EqualError(t, err, consts.ErrRevokedSession.Error(), desc)
+}
+
+func TestVerifySession(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	sessions := NewMemorySessionStore()
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithIssuerSessions(sessions))
+	assert.Nil(t, err)
+	adminPair, err := issuer.Issue(testAdminUUID, Admin)
+	assert.Nil(t, err)
+	admin, err := NewVerifier(Jwt, Admin, WithClock(clock)).Verify(adminPair.Access)
+	assert.Nil(t, err)
+	impersonation, err := issuer.Impersonate(admin, testUserUUID, User)
+	assert.Nil(t, err)
+	clientIssuer, err := NewClientIssuer(validSecret, NewMemoryClientStore(), WithClientClock(clock))
+	assert.Nil(t, err)
+	clientSecret, err := clientIssuer.Register("hwsc-document-svc", User, nil)
+	assert.Nil(t, err)
+	service, err := clientIssuer.Exchange("hwsc-document-svc", clientSecret)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc     string
+		id       *pbauth.Identification
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for token issued before sessions", validUserIdentification, true, consts.ErrMissingSession},
+		{"test for service token", service, false, nil},
+		{"test for impersonation in the actor's session", impersonation, false, nil},
+	}
+	for _, c := range cases {
+		_, err := NewVerifier(Jwt, User, WithClock(clock), WithSessions(sessions), WithServices()).Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+
+	desc := "test for purpose token without session"
+	emailToken, err := newToken(&Header{Alg: Hs256, TokenTyp: Jet}, &Body{
+		UUID:                testUserUUID,
+		Permission:          User,
+		ExpirationTimestamp: clock.Now().Add(time.Hour).Unix(),
+	}, validSecret, clock.Now())
+	assert.Nil(t, err, desc)
+	_, err = NewVerifier(Jet, User, WithClock(clock), WithSessions(sessions)).Verify(&pbauth.Identification{
+		Token:  emailToken,
+		Secret: validSecret,
+	})
+	assert.Nil(t, err, desc)
+
+	desc = "test for impersonation revoked with the actor's session"
+	assert.Nil(t, issuer.RevokeAllSessions(testAdminUUID), desc)
+	_, err = NewVerifier(Jwt, User, WithClock(clock), WithSessions(sessions)).Verify(impersonation)
+	assert.EqualError(t, err, consts.ErrRevokedSession.Error(), desc)
+
+	desc = "test for nil session store"
+	_, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerSessions(nil))
+	assert.EqualError(t, err, consts.ErrNilSessionStore.Error(), desc)
+	issuer, err = NewTokenIssuer(validSecret, NewMemoryRefreshStore())
+	assert.Nil(t, err, desc)
+	_, err = issuer.Sessions(testUserUUID)
+	assert.EqualError(t, err, consts.ErrNilSessionStore.Error(), desc)
+}
+
+// touchCountingStore is a SessionStore counting the writes of last seen times.
+type touchCountingStore struct {
+	SessionStore
+	touches int
+}
+
+func (s *touchCountingStore) Touch(id string, at time.Time) error {
+	s.touches++
+	return s.SessionStore.Touch(id, at)
+}
+
+func TestVerifySessionTouch(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	sessions := &touchCountingStore{SessionStore: NewMemorySessionStore()}
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithIssuerSessions(sessions))
+	assert.Nil(t, err)
+	pair, err := issuer.Issue(testUserUUID, User)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc       string
+		opts       []VerifierOption
+		advance    time.Duration
+		expTouches int
+	}{
+		{"test for session seen within the interval", nil, 30 * time.Second, 0},
+		{"test for session seen after the interval", nil, 30 * time.Second, 1},
+		{"test for verifications within the interval", nil, 0, 1},
+		{"test for longer interval", []VerifierOption{WithSessionTouchInterval(5 * time.Minute)}, 2 * time.Minute, 1},
+		{"test for non-positive interval", []VerifierOption{WithSessionTouchInterval(0)}, time.Minute, 2},
+	}
+	for _, c := range cases {
+		clock.Advance(c.advance)
+		opts := append([]VerifierOption{WithClock(clock), WithSessions(sessions)}, c.opts...)
+		_, err := NewVerifier(Jwt, User, opts...).Verify(pair.Access)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expTouches, sessions.touches, c.desc)
+	}
+
+	desc := "test for refresh always recording the session"
+	_, err = issuer.Exchange(pair.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	assert.Equal(t, 3, sessions.touches, desc)
+}
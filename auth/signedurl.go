@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/hmac"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// URLExpiresParam is the query parameter of the unix timestamp a signed URL expires at
+	URLExpiresParam = "hwsc_expires"
+	// URLKeyIDParam is the query parameter of the ID of the key a URL is signed with
+	URLKeyIDParam = "hwsc_kid"
+	// URLParamsParam is the query parameter listing the other query parameters covered by the signature
+	URLParamsParam = "hwsc_params"
+	// URLBoundParam is the query parameter marking a URL only the user it was signed for may use
+	URLBoundParam = "hwsc_bound"
+	// URLSignatureParam is the query parameter of the signature of a URL
+	URLSignatureParam  = "hwsc_signature"
+	urlParamsSeparator = ","
+	urlBound           = "1"
+	urlSigningAlg      = Hs256
+)
+
+// URLSignerOption configures a URLSigner.
+type URLSignerOption func(*URLSigner)
+
+// WithURLSignerClock makes the URL signer read the current time from the clock.
+func WithURLSignerClock(clock Clock) URLSignerOption {
+	return func(s *URLSigner) {
+		s.clock = clock
+	}
+}
+
+// WithURLVerificationKey makes the URL signer also accept URLs signed with the secret of the key ID,
+// ie: the previous key after a rotation, until the URLs it signed expire.
+func WithURLVerificationKey(keyID string, secret *pbauth.Secret) URLSignerOption {
+	return func(s *URLSigner) {
+		s.keyIDs = append(s.keyIDs, keyID)
+		s.keys[keyID] = secret
+	}
+}
+
+// URLSigner signs time-limited URLs, ie: download links, and verifies the requests made with them.
+// The signature is an HMAC of the path, the selected query parameters, the expiry and the key ID,
+// and of the user's UUID for URLs bound to a user.
+// Secrets are fixed when the signer is made: rotate keys by making a new signer with WithURLVerificationKey.
+type URLSigner struct {
+	keyID  string
+	keyIDs []string
+	keys   map[string]*pbauth.Secret
+	clock  Clock
+}
+
+// NewURLSigner makes a URL signer signing with the secret of the key ID.
+// Returns an error if a key ID is blank or duplicated, or a secret is not valid.
+func NewURLSigner(keyID string, secret *pbauth.Secret, opts ...URLSignerOption) (*URLSigner, error) {
+	s := &URLSigner{
+		keyID:  keyID,
+		keyIDs: []string{keyID},
+		keys:   map[string]*pbauth.Secret{keyID: secret},
+		clock:  SystemClock,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if len(s.keys) != len(s.keyIDs) {
+		return nil, consts.ErrDuplicateSecret
+	}
+	now := s.clock.Now()
+	for _, id := range s.keyIDs {
+		if strings.TrimSpace(id) == "" || strings.ContainsAny(id, " \t\r\n") {
+			return nil, consts.ErrUnknownURLKey
+		}
+		if err := validateSecret(s.keys[id], now, 0); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Sign signs the URL for lifetime from now, covering its path and the query parameters named in params.
+// Other query parameters may be added or changed without invalidating the signature.
+// If uuid is set, the URL is bound to the user: Verify only accepts it for the same uuid.
+// Returns the signed URL, or an error if the URL cannot be parsed, a parameter is reserved,
+// or the URL would outlive the signing secret.
+func (s *URLSigner) Sign(rawURL string, lifetime time.Duration, uuid string, params ...string) (string, error) {
+	if lifetime < time.Second {
+		return "", consts.ErrInvalidLifetime
+	}
+	now := s.clock.Now()
+	secret := s.keys[s.keyID]
+	if err := validateSecret(secret, now, 0); err != nil {
+		return "", err
+	}
+	expires := now.Add(lifetime).Unix()
+	if expires > secret.GetExpirationTimestamp() {
+		return "", consts.ErrInvalidLifetime
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", consts.ErrInvalidSignedURL
+	}
+	for _, param := range params {
+		if param == "" || strings.HasPrefix(param, "hwsc_") || strings.Contains(param, urlParamsSeparator) {
+			return "", consts.ErrInvalidSignedURL
+		}
+	}
+	query := u.Query()
+	for _, reserved := range []string{URLExpiresParam, URLKeyIDParam, URLParamsParam, URLBoundParam,
+		URLSignatureParam} {
+		query.Del(reserved)
+	}
+	query.Set(URLExpiresParam, strconv.FormatInt(expires, 10))
+	query.Set(URLKeyIDParam, s.keyID)
+	if len(params) > 0 {
+		sorted := append([]string(nil), params...)
+		sort.Strings(sorted)
+		query.Set(URLParamsParam, strings.Join(sorted, urlParamsSeparator))
+	}
+	if uuid != "" {
+		query.Set(URLBoundParam, urlBound)
+	}
+	signature, err := hashSignature(urlSigningAlg, canonicalURL(u.EscapedPath(), query, uuid), secret)
+	if err != nil {
+		return "", err
+	}
+	query.Set(URLSignatureParam, signature)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Verify checks the signature and expiry of the signed URL,
+// for the user's uuid if the URL is bound to a user, ie: the UUID of the authorized body of the request.
+// Returns consts.ErrExpiredURL if the URL has expired, consts.ErrUnknownURLKey if its key is not known,
+// or consts.ErrInvalidSignature if the URL was tampered with or is bound to another user.
+func (s *URLSigner) Verify(u *url.URL, uuid string) error {
+	if u == nil {
+		return consts.ErrInvalidSignedURL
+	}
+	query := u.Query()
+	for _, param := range []string{URLExpiresParam, URLKeyIDParam, URLSignatureParam} {
+		if len(query[param]) != 1 {
+			return consts.ErrInvalidSignedURL
+		}
+	}
+	for _, param := range []string{URLParamsParam, URLBoundParam} {
+		if len(query[param]) > 1 {
+			return consts.ErrInvalidSignedURL
+		}
+	}
+	expires, err := strconv.ParseInt(query.Get(URLExpiresParam), 10, 64)
+	if err != nil {
+		return consts.ErrInvalidSignedURL
+	}
+	now := s.clock.Now()
+	if now.Unix() >= expires {
+		return consts.ErrExpiredURL
+	}
+	secret, ok := s.keys[query.Get(URLKeyIDParam)]
+	if !ok {
+		return consts.ErrUnknownURLKey
+	}
+	if err := validateSecret(secret, now, 0); err != nil {
+		return err
+	}
+	switch query.Get(URLBoundParam) {
+	case "":
+		uuid = ""
+	case urlBound:
+		if uuid == "" {
+			return consts.ErrInvalidSignature
+		}
+	default:
+		return consts.ErrInvalidSignedURL
+	}
+	signature, err := decodeSignature(query.Get(URLSignatureParam))
+	if err != nil {
+		return err
+	}
+	query.Del(URLSignatureParam)
+	expected, err := computeSignature(urlSigningAlg, canonicalURL(u.EscapedPath(), query, uuid), secret)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, expected) {
+		return consts.ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyRequest checks the signed URL of the request, see Verify.
+func (s *URLSigner) VerifyRequest(r *http.Request, uuid string) error {
+	if r == nil {
+		return consts.ErrInvalidSignedURL
+	}
+	return s.Verify(r.URL, uuid)
+}
+
+// canonicalURL builds the string signed for a URL: its escaped path, expiry, key ID, bound uuid,
+// and the signed query parameters in their canonical encoding.
+// Parameters listed but absent from the query are signed as absent.
+func canonicalURL(path string, query url.Values, uuid string) string {
+	signed := url.Values{}
+	if list := query.Get(URLParamsParam); list != "" {
+		for _, param := range strings.Split(list, urlParamsSeparator) {
+			signed[param] = query[param]
+		}
+	}
+	return strings.Join([]string{
+		path,
+		query.Get(URLExpiresParam),
+		query.Get(URLKeyIDParam),
+		query.Get(URLParamsParam),
+		uuid,
+		signed.Encode(),
+	}, "\n")
+}
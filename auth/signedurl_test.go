@@ -0,0 +1,162 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewURLSigner(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	expired := &pbauth.Secret{
+		Key:                 validSecretKey,
+		CreatedTimestamp:    validCreatedTimestamp,
+		ExpirationTimestamp: clock.Now().Add(-time.Hour).Unix(),
+	}
+
+	cases := []struct {
+		desc   string
+		keyID  string
+		secret *pbauth.Secret
+		opts   []URLSignerOption
+		expErr error
+	}{
+		{"test for valid signer", "k1", validSecret, nil, nil},
+		{"test for blank key id", " ", validSecret, nil, consts.ErrUnknownURLKey},
+		{"test for nil secret", "k1", nil, nil, consts.ErrNilSecret},
+		{"test for expired secret", "k1", expired, nil, consts.ErrExpiredSecret},
+		{"test for duplicate key id", "k1", validSecret,
+			[]URLSignerOption{WithURLVerificationKey("k1", validSecret)}, consts.ErrDuplicateSecret},
+		{"test for nil clock", "k1", validSecret, []URLSignerOption{WithURLSignerClock(nil)}, consts.ErrNilClock},
+	}
+	for _, c := range cases {
+		opts := append([]URLSignerOption{WithURLSignerClock(clock)}, c.opts...)
+		signer, err := NewURLSigner(c.keyID, c.secret, opts...)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, signer, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, signer, c.desc)
+		}
+	}
+}
+
+func TestURLSigner(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	signer, err := NewURLSigner("k1", validSecret, WithURLSignerClock(clock))
+	assert.Nil(t, err)
+	rawURL := "https://docs.hwsc.org/documents/42/download?name=report.pdf&ref=mail"
+	signed, err := signer.Sign(rawURL, time.Hour, "", "name")
+	assert.Nil(t, err)
+	bound, err := signer.Sign(rawURL, time.Hour, testUserUUID)
+	assert.Nil(t, err)
+
+	tamper := func(signedURL string, edit func(url.Values)) string {
+		u, _ := url.Parse(signedURL)
+		query := u.Query()
+		edit(query)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	cases := []struct {
+		desc   string
+		rawURL string
+		uuid   string
+		expErr error
+	}{
+		{"test for signed url", signed, "", nil},
+		{"test for unbound url with user", signed, testUserUUID, nil},
+		{"test for unsigned param changed", tamper(signed, func(q url.Values) { q.Set("ref", "web") }), "", nil},
+		{"test for signed param changed", tamper(signed, func(q url.Values) { q.Set("name", "x.pdf") }), "",
+			consts.ErrInvalidSignature},
+		{"test for signed param repeated", tamper(signed, func(q url.Values) { q.Add("name", "x.pdf") }), "",
+			consts.ErrInvalidSignature},
+		{"test for signed params dropped", tamper(signed, func(q url.Values) { q.Del(URLParamsParam) }), "",
+			consts.ErrInvalidSignature},
+		{"test for path changed", strings.Replace(signed, "/42/", "/43/", 1), "", consts.ErrInvalidSignature},
+		{"test for expiry extended", tamper(signed, func(q url.Values) { q.Set(URLExpiresParam, "4102444800") }),
+			"", consts.ErrInvalidSignature},
+		{"test for unknown key", tamper(signed, func(q url.Values) { q.Set(URLKeyIDParam, "k2") }), "",
+			consts.ErrUnknownURLKey},
+		{"test for missing signature", tamper(signed, func(q url.Values) { q.Del(URLSignatureParam) }), "",
+			consts.ErrInvalidSignedURL},
+		{"test for bound url", bound, testUserUUID, nil},
+		{"test for bound url without user", bound, "", consts.ErrInvalidSignature},
+		{"test for bound url with other user", bound, testAdminUUID, consts.ErrInvalidSignature},
+		{"test for binding dropped", tamper(bound, func(q url.Values) { q.Del(URLBoundParam) }), "",
+			consts.ErrInvalidSignature},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.rawURL)
+		assert.Nil(t, err, c.desc)
+		err = signer.VerifyRequest(httptest.NewRequest("GET", u.String(), nil), c.uuid)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+
+	desc := "test for expired url"
+	clock.Advance(time.Hour)
+	u, _ := url.Parse(signed)
+	assert.EqualError(t, signer.Verify(u, ""), consts.ErrExpiredURL.Error(), desc)
+
+	desc = "test for reserved param"
+	_, err = signer.Sign(rawURL, time.Hour, "", URLSignatureParam)
+	assert.EqualError(t, err, consts.ErrInvalidSignedURL.Error(), desc)
+
+	desc = "test for invalid lifetime"
+	_, err = signer.Sign(rawURL, 0, "")
+	assert.EqualError(t, err, consts.ErrInvalidLifetime.Error(), desc)
+
+	desc = "test for lifetime past the secret expiration"
+	expiring := &pbauth.Secret{
+		Key:                 validSecretKey,
+		CreatedTimestamp:    validCreatedTimestamp,
+		ExpirationTimestamp: clock.Now().Add(time.Hour).Unix(),
+	}
+	signer, err = NewURLSigner("k1", expiring, WithURLSignerClock(clock))
+	assert.Nil(t, err, desc)
+	_, err = signer.Sign(rawURL, 2*time.Hour, "")
+	assert.EqualError(t, err, consts.ErrInvalidLifetime.Error(), desc)
+	_, err = signer.Sign(rawURL, time.Hour, "")
+	assert.Nil(t, err, desc)
+}
+
+func TestURLSignerRotation(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	next := &pbauth.Secret{
+		Key:                 "c2VjcmV0LWtleS1mb3ItdGhlLW5leHQtdXJsLXNpZ25pbmcta2V5",
+		CreatedTimestamp:    validCreatedTimestamp,
+		ExpirationTimestamp: validExpirationTimestamp,
+	}
+	previous, err := NewURLSigner("k1", validSecret, WithURLSignerClock(clock))
+	assert.Nil(t, err)
+	signed, err := previous.Sign("/documents/42", time.Hour, "")
+	assert.Nil(t, err)
+	u, err := url.Parse(signed)
+	assert.Nil(t, err)
+
+	rotated, err := NewURLSigner("k2", next, WithURLSignerClock(clock))
+	assert.Nil(t, err)
+	assert.EqualError(t, rotated.Verify(u, ""), consts.ErrUnknownURLKey.Error(), "test for retired key")
+
+	rotated, err = NewURLSigner("k2", next, WithURLSignerClock(clock), WithURLVerificationKey("k1", validSecret))
+	assert.Nil(t, err)
+	assert.Nil(t, rotated.Verify(u, ""), "test for previous key")
+	resigned, err := rotated.Sign("/documents/42", time.Hour, "")
+	assert.Nil(t, err)
+	assert.Contains(t, resigned, URLKeyIDParam+"=k2", "test for signing with current key")
+	u, err = url.Parse(resigned)
+	assert.Nil(t, err)
+	assert.Nil(t, rotated.Verify(u, ""), "test for current key")
+	assert.EqualError(t, previous.Verify(u, ""), consts.ErrUnknownURLKey.Error(), "test for old signer")
+}
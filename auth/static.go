@@ -12,6 +12,7 @@ import (
 	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
 	"github.com/hwsc-org/hwsc-lib/consts"
 	"github.com/hwsc-org/hwsc-lib/validation"
+	"github.com/oklog/ulid"
 	"hash"
 	"strings"
 	"sync"
@@ -21,8 +22,6 @@ import (
 const (
 	utc                = "UTC"
 	emailTokenByteSize = 32
-	daysInOneWeek      = 7
-	daysInTwoWeeks     = 14
 )
 
 var (
@@ -33,13 +32,22 @@ var (
 // Checks if the Secret has expired.
 // Returns the first error encountered.
 func ValidateIdentification(id *pbauth.Identification) error {
+	return validateIdentification(id, SystemClock.Now(), 0)
+}
+
+// validateIdentification validates Identification at the given time,
+// tolerating a clock skew of leeway for the Secret's timestamps.
+func validateIdentification(id *pbauth.Identification, now time.Time, leeway time.Duration) error {
 	if id == nil {
 		return consts.ErrNilIdentification
 	}
 	if strings.TrimSpace(id.GetToken()) == "" {
 		return consts.ErrEmptyToken
 	}
-	if err := ValidateSecret(id.GetSecret()); err != nil {
+	if len(id.GetToken()) > MaxTokenSize {
+		return consts.ErrTokenTooLarge
+	}
+	if err := validateSecret(id.GetSecret(), now, leeway); err != nil {
 		return err
 	}
 	return nil
@@ -52,7 +60,7 @@ func ValidateHeader(header *Header) error {
 		return consts.ErrNilHeader
 	}
 	tokenType := header.TokenTyp
-	if tokenType < NoType || tokenType > Jet {
+	if tokenType != NoType && !isSupportedTokenType(tokenType) {
 		return consts.ErrUnknownTokenType
 	}
 	alg := header.Alg
@@ -63,46 +71,140 @@ func ValidateHeader(header *Header) error {
 }
 
 // ValidateBody validates Body.
-// Checks if token string has expired.
+// Checks if token string has expired or is not valid yet.
 // Returns the first error encountered.
 func ValidateBody(body *Body) error {
+	return validateBody(body, SystemClock.Now(), 0)
+}
+
+// validateBody validates Body at the given time,
+// tolerating a clock skew of leeway for its timestamps.
+func validateBody(body *Body, now time.Time, leeway time.Duration) error {
+	if err := validateBodyClaims(body, now, leeway); err != nil {
+		return err
+	}
+	if body.NotBefore > now.Add(leeway).Unix() {
+		return consts.ErrTokenNotYetValid
+	}
+	return nil
+}
+
+// validateBodyClaims validates Body at the given time without the not before timestamp,
+// so that tokens which become valid later can be issued.
+func validateBodyClaims(body *Body, now time.Time, leeway time.Duration) error {
 	if body == nil {
 		return consts.ErrNilBody
 	}
-	if err := validation.ValidateUserUUID(body.UUID); err != nil {
+	if err := validatePrincipal(body.Principal, body.UUID, body.Service); err != nil {
 		return err
 	}
 	permission := body.Permission
 	if permission < NoPermission || permission > Admin {
 		return consts.ErrUnknownPermission
 	}
-	if isExpired(body.ExpirationTimestamp) {
+	if isExpired(body.ExpirationTimestamp, now, leeway) {
 		return consts.ErrExpiredBody
 	}
+	if body.IssuedAt < 0 || body.IssuedAt > now.Add(leeway).Unix() {
+		return consts.ErrInvalidIssuedAt
+	}
+	if body.NotBefore < 0 || body.NotBefore >= body.ExpirationTimestamp {
+		return consts.ErrInvalidNotBefore
+	}
+	if body.Issuer != "" && strings.TrimSpace(body.Issuer) == "" {
+		return consts.ErrInvalidIssuer
+	}
+	for _, audience := range body.Audience {
+		if strings.TrimSpace(audience) == "" {
+			return consts.ErrInvalidAudience
+		}
+	}
+	for _, role := range body.Roles {
+		if strings.TrimSpace(role) == "" {
+			return consts.ErrInvalidRole
+		}
+	}
+	for _, scope := range body.Scopes {
+		if err := validateScope(scope); err != nil {
+			return err
+		}
+	}
+	if err := validateAuthMethods(body.AuthMethods); err != nil {
+		return err
+	}
+	if body.Confirmation != nil {
+		if err := validateConfirmation(body.Confirmation); err != nil {
+			return err
+		}
+	}
+	if body.Actor != nil {
+		if err := validateActor(body.Actor); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// validatePrincipal checks that a user has a uuid and a service has a service name, but not both.
+func validatePrincipal(principal PrincipalType, uuid string, service string) error {
+	switch principal {
+	case UserPrincipal:
+		if service != "" {
+			return consts.ErrInvalidPrincipal
+		}
+		return validation.ValidateUserUUID(uuid)
+	case ServicePrincipal:
+		if uuid != "" {
+			return consts.ErrInvalidPrincipal
+		}
+		return validation.ValidateServiceName(service)
+	}
+	return consts.ErrUnknownPrincipal
+}
+
 // ValidateSecret checks if the secret is still valid and has not expired.
 // Returns an error if the Secret is not valid and has expired.
 func ValidateSecret(secret *pbauth.Secret) error {
-	if secret == nil {
-		return consts.ErrNilSecret
-	}
-	if strings.TrimSpace(secret.Key) == "" {
-		return consts.ErrEmptySecret
+	return validateSecret(secret, SystemClock.Now(), 0)
+}
+
+// validateSecret checks if the secret is valid at the given time,
+// tolerating a clock skew of leeway for its create and expiration timestamps.
+func validateSecret(secret *pbauth.Secret, now time.Time, leeway time.Duration) error {
+	if err := validateSecretKey(secret); err != nil {
+		return err
 	}
 	createTime := secret.CreatedTimestamp
-	if createTime == 0 || createTime > time.Now().UTC().Unix() {
+	if createTime == 0 || createTime > now.Add(leeway).Unix() {
 		return consts.ErrInvalidSecretCreateTimestamp
 	}
-	if isExpired(secret.ExpirationTimestamp) {
+	if isExpired(secret.ExpirationTimestamp, now, leeway) {
 		return consts.ErrExpiredSecret
 	}
 	return nil
 }
 
-func isExpired(timestamp int64) bool {
-	if timestamp <= 0 || time.Now().UTC().Unix() >= timestamp {
+// validateSecretKey checks if the secret has a key, regardless of its timestamps.
+func validateSecretKey(secret *pbauth.Secret) error {
+	if secret == nil {
+		return consts.ErrNilSecret
+	}
+	if strings.TrimSpace(secret.Key) == "" {
+		return consts.ErrEmptySecret
+	}
+	return nil
+}
+
+// isSupportedTokenType checks if tokens of the type can be signed and verified.
+// Currently supports JWT, JET, JRT, JPT, JCT, JMT
+func isSupportedTokenType(tokenType TokenType) bool {
+	return tokenType >= Jwt && tokenType <= Jmt
+}
+
+// isExpired checks if the timestamp has passed at the given time,
+// tolerating a clock skew of leeway.
+func isExpired(timestamp int64, now time.Time, leeway time.Duration) bool {
+	if timestamp <= 0 || now.Add(-leeway).Unix() >= timestamp {
 		return true
 	}
 	return false
@@ -111,45 +213,56 @@ func isExpired(timestamp int64) bool {
 // NewToken generates token string using a header, body, and secret.
 // Return error if an error exists during signing.
 func NewToken(header *Header, body *Body, secret *pbauth.Secret) (string, error) {
+	return newToken(header, body, secret, SystemClock.Now())
+}
+
+// NewTokenAt generates token string using a header, body, and secret valid at now, ie: read from a Clock.
+// Return error if an error exists during signing.
+func NewTokenAt(header *Header, body *Body, secret *pbauth.Secret, now time.Time) (string, error) {
+	return newToken(header, body, secret, now)
+}
+
+// newToken generates token string using a header, body, and secret valid at the given time.
+// Return error if an error exists during signing.
+func newToken(header *Header, body *Body, secret *pbauth.Secret, now time.Time) (string, error) {
 	if err := ValidateHeader(header); err != nil {
 		return "", err
 	}
-	if err := ValidateBody(body); err != nil {
+	if err := validateBodyClaims(body, now, 0); err != nil {
 		return "", err
 	}
-	if err := ValidateSecret(secret); err != nil {
+	if err := validateSecret(secret, now, 0); err != nil {
 		return "", err
 	}
 	if body.Permission == Admin && header.Alg != Hs512 {
 		return "", consts.ErrInvalidPermission
 	}
-	// Currently supports JWT, JET
-	if header.TokenTyp != Jwt && header.TokenTyp != Jet {
+	if !isSupportedTokenType(header.TokenTyp) {
 		return "", consts.ErrUnknownTokenType
 	}
-	tokenString, err := getTokenSignature(header, body, secret)
+	tokenString, err := getTokenSignature(header, body, secret, now)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-// getTokenSignature gets the token signature using the encoded header, body, and secret key.
+// getTokenSignature gets the token signature using the encoded header, body, and secret key valid at the given time.
 // Return error if an error exists during signing.
-func getTokenSignature(header *Header, body *Body, secret *pbauth.Secret) (string, error) {
+func getTokenSignature(header *Header, body *Body, secret *pbauth.Secret, now time.Time) (string, error) {
 	if err := ValidateHeader(header); err != nil {
 		return "", err
 	}
-	if err := ValidateBody(body); err != nil {
+	if err := validateBodyClaims(body, now, 0); err != nil {
 		return "", err
 	}
-	if err := ValidateSecret(secret); err != nil {
+	if err := validateSecret(secret, now, 0); err != nil {
 		return "", err
 	}
 	if body.Permission == Admin && header.Alg != Hs512 {
 		return "", consts.ErrInvalidPermission
 	}
-	if header.TokenTyp != Jwt && header.TokenTyp != Jet {
+	if !isSupportedTokenType(header.TokenTyp) {
 		return "", consts.ErrUnknownTokenType
 	}
 	// Token Signature = <encoded header>.<encoded body>.<hashed(<encoded header>.<encoded body>)>
@@ -178,7 +291,8 @@ func buildTokenSignature(encodedHeader string, encodedBody string, alg Algorithm
 	if strings.TrimSpace(encodedBody) == "" {
 		return "", consts.ErrInvalidEncodedBody
 	}
-	if err := ValidateSecret(secret); err != nil {
+	// timestamps of the secret are checked by the callers at their own time
+	if err := validateSecretKey(secret); err != nil {
 		return "", err
 	}
 	// 3. Build <encoded header>.<encoded body>
@@ -190,7 +304,7 @@ func buildTokenSignature(encodedHeader string, encodedBody string, alg Algorithm
 	// 4. Build <hashed(<encoded header>.<encoded body>)>
 	encodedSignature, err := hashSignature(alg, encodedHeaderBody, secret)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	// 5. Build Token Signature = <encoded header>.<encoded body>.<hashed(<encoded header>.<encoded body>)>
 	var bufferTokenSignature bytes.Buffer
@@ -210,9 +324,7 @@ func base64Encode(src interface{}) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	srcString := string(srcMarshal)
-	// TODO maybe use Trim
-	return strings.TrimRight(base64.URLEncoding.EncodeToString([]byte(srcString)), "="), nil
+	return base64.RawURLEncoding.EncodeToString(srcMarshal), nil
 }
 
 // base64Encode takes in a base 64 encoded string.
@@ -232,13 +344,120 @@ func base64Decode(src string) (string, error) {
 	return string(decoded), nil
 }
 
-// hashSignature generates a HMAC hash of a string using a secret
+// decodeHeader parses a decoded header.
+// Returns an error if the header is not valid JSON, has duplicate keys or unknown fields.
+func decodeHeader(decodedHeader string) (*Header, error) {
+	header := &Header{}
+	if err := json.Unmarshal([]byte(decodedHeader), header); err != nil {
+		return nil, err
+	}
+	if err := checkDuplicateKeys([]byte(decodedHeader)); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(decodedHeader))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&Header{}); err != nil {
+		return nil, consts.ErrUnknownHeaderField
+	}
+	return header, nil
+}
+
+// decodeBody parses a decoded body.
+// Unknown fields are ignored so that newer tokens can be read.
+// Returns an error if the body is not valid JSON or has duplicate keys.
+func decodeBody(decodedBody string) (*Body, error) {
+	body := &Body{}
+	if err := json.Unmarshal([]byte(decodedBody), body); err != nil {
+		return nil, err
+	}
+	if err := checkDuplicateKeys([]byte(decodedBody)); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// checkDuplicateKeys walks valid JSON and checks that no object repeats a key.
+// Keys are compared case insensitively because that is how encoding/json matches fields,
+// ie: {"UUID":"a","uuid":"b"} would otherwise silently use the last value.
+// Returns consts.ErrDuplicateJSONKey if a key is repeated.
+func checkDuplicateKeys(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return checkDuplicateKeysValue(decoder, strings.EqualFold)
+}
+
+// checkExactDuplicateKeys walks valid JSON and checks that no object repeats a key exactly.
+// It is for JSON whose keys are case sensitive, ie: the claims of a provider ID token,
+// which may hold both "groups" and "Groups".
+// Returns consts.ErrDuplicateJSONKey if a key is repeated.
+func checkExactDuplicateKeys(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return checkDuplicateKeysValue(decoder, func(a string, b string) bool {
+		return a == b
+	})
+}
+
+// checkDuplicateKeysValue checks the next JSON value of the decoder, comparing keys with equal.
+func checkDuplicateKeysValue(decoder *json.Decoder, equal func(a string, b string) bool) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		var keys []string
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := keyToken.(string)
+			if !ok {
+				return consts.ErrDuplicateJSONKey
+			}
+			for _, seen := range keys {
+				if equal(seen, key) {
+					return consts.ErrDuplicateJSONKey
+				}
+			}
+			keys = append(keys, key)
+			if err := checkDuplicateKeysValue(decoder, equal); err != nil {
+				return err
+			}
+		}
+		// consume the closing delimiter
+		_, err = decoder.Token()
+		return err
+	case json.Delim('['):
+		for decoder.More() {
+			if err := checkDuplicateKeysValue(decoder, equal); err != nil {
+				return err
+			}
+		}
+		_, err = decoder.Token()
+		return err
+	default:
+		return nil
+	}
+}
+
+// hashSignature generates a base64 encoded HMAC hash of a string using a secret
 func hashSignature(alg Algorithm, signatureValue string, secret *pbauth.Secret) (string, error) {
+	signature, err := computeSignature(alg, signatureValue, secret)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(signature), nil
+}
+
+// computeSignature generates the raw HMAC hash of a string using a secret
+func computeSignature(alg Algorithm, signatureValue string, secret *pbauth.Secret) ([]byte, error) {
 	if strings.TrimSpace(signatureValue) == "" {
-		return "", consts.ErrInvalidSignatureValue
+		return nil, consts.ErrInvalidSignatureValue
 	}
-	if err := ValidateSecret(secret); err != nil {
-		return "", err
+	if err := validateSecretKey(secret); err != nil {
+		return nil, err
 	}
 	key := []byte(secret.Key)
 	var h hash.Hash
@@ -248,15 +467,27 @@ func hashSignature(alg Algorithm, signatureValue string, secret *pbauth.Secret)
 	case Hs512:
 		h = hmac.New(sha512.New, key)
 	default:
-		return "", consts.ErrNoHashAlgorithm
+		return nil, consts.ErrNoHashAlgorithm
 	}
 	h.Write([]byte(signatureValue))
-	return base64.URLEncoding.EncodeToString(h.Sum(nil)), nil
+	return h.Sum(nil), nil
 }
 
-// isEquivalentHash validates a hash against a value
-func isEquivalentHash(alg Algorithm, signatureValue string, secret *pbauth.Secret, hashedValue string) bool {
-	if err := ValidateSecret(secret); err != nil {
+// decodeSignature decodes a base64 encoded signature.
+// Only the padded canonical encoding produced by hashSignature is accepted,
+// so a signature has a single valid string representation.
+func decodeSignature(encodedSignature string) ([]byte, error) {
+	signature, err := base64.URLEncoding.Strict().DecodeString(encodedSignature)
+	if err != nil {
+		return nil, consts.ErrInvalidSignature
+	}
+	return signature, nil
+}
+
+// isEquivalentHash validates a hash against a value, with the secret valid at now
+func isEquivalentHash(alg Algorithm, signatureValue string, secret *pbauth.Secret, hashedValue string,
+	now time.Time) bool {
+	if err := validateSecret(secret, now, 0); err != nil {
 		return false
 	}
 	/*
@@ -264,16 +495,115 @@ func isEquivalentHash(alg Algorithm, signatureValue string, secret *pbauth.Secre
 		If it evaluates to true, then the character is a what is in the hash.
 		The isValidHash function only hashes the value with the secret and compared it with the hash.
 	*/
-	actualHashedValue, err := hashSignature(alg, signatureValue, secret)
+	actualSignature, err := computeSignature(alg, signatureValue, secret)
 	if err != nil {
 		return false
 	}
-	return hashedValue == actualHashedValue
+	signature, err := decodeSignature(hashedValue)
+	if err != nil {
+		return false
+	}
+	// compare in constant time to not leak how much of the hash matches
+	return hmac.Equal(signature, actualSignature)
+}
+
+// copyStrings makes a copy of a string slice.
+// Returns nil if the slice is empty.
+func copyStrings(src []string) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	dst := make([]string, len(src))
+	copy(dst, src)
+	return dst
+}
+
+// copyHeader makes a copy of the header.
+// Returns nil if the header is nil.
+func copyHeader(header *Header) *Header {
+	if header == nil {
+		return nil
+	}
+	return &Header{
+		Alg:      header.Alg,
+		TokenTyp: header.TokenTyp,
+	}
+}
+
+// copyBody makes a deep copy of the body.
+// Returns nil if the body is nil.
+func copyBody(body *Body) *Body {
+	if body == nil {
+		return nil
+	}
+	return &Body{
+		UUID:                body.UUID,
+		Permission:          body.Permission,
+		ExpirationTimestamp: body.ExpirationTimestamp,
+		IssuedAt:            body.IssuedAt,
+		NotBefore:           body.NotBefore,
+		Issuer:              body.Issuer,
+		Audience:            copyStrings(body.Audience),
+		Binding:             body.Binding,
+		ID:                  body.ID,
+		FamilyID:            body.FamilyID,
+		Roles:               copyStrings(body.Roles),
+		Scopes:              copyStrings(body.Scopes),
+		Confirmation:        copyConfirmation(body.Confirmation),
+		Principal:           body.Principal,
+		Service:             body.Service,
+		Actor:               copyActor(body.Actor),
+		Session:             body.Session,
+		AuthMethods:         copyStrings(body.AuthMethods),
+	}
+}
+
+// copyConfirmation copies the confirmation.
+// Returns nil if the confirmation is nil.
+func copyConfirmation(cnf *Confirmation) *Confirmation {
+	if cnf == nil {
+		return nil
+	}
+	copied := *cnf
+	return &copied
+}
+
+// DecodeToken decodes the header and body of the token string without verifying its signature,
+// ie: to inspect a token while debugging. Never trust the decoded claims, use a Verifier instead.
+// Returns the header and body, or an error if the token cannot be decoded.
+func DecodeToken(tokenString string) (*Header, *Body, error) {
+	if len(tokenString) > MaxTokenSize {
+		return nil, nil, consts.ErrTokenTooLarge
+	}
+	tokenSignature := strings.Split(tokenString, ".")
+	if len(tokenSignature) != 3 {
+		return nil, nil, consts.ErrIncompleteToken
+	}
+	decodedHeader, err := base64Decode(tokenSignature[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	header, err := decodeHeader(decodedHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	decodedBody, err := base64Decode(tokenSignature[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := decodeBody(decodedBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, body, nil
 }
 
 // ExtractUUID takes in a token string and extracts the UUID from the body.
 // Returns the uuid or an empty string due to an error.
 func ExtractUUID(tokenString string) string {
+	if len(tokenString) > MaxTokenSize {
+		return ""
+	}
 	tokenSignature := strings.Split(tokenString, ".")
 	if len(tokenSignature) != 3 {
 		return ""
@@ -282,11 +612,8 @@ func ExtractUUID(tokenString string) string {
 	if err != nil {
 		return ""
 	}
-	body := &Body{}
-	if err := json.Unmarshal([]byte(decodedBody), body); err != nil {
-		return ""
-	}
-	if body == nil {
+	body, err := decodeBody(decodedBody)
+	if err != nil {
 		return ""
 	}
 	if err := validation.ValidateUserUUID(body.UUID); err != nil {
@@ -316,8 +643,18 @@ func GenerateSecretKey(tokenSize int) (string, error) {
 	return base64.URLEncoding.EncodeToString(randomBytes), nil
 }
 
+// generateID generates a lowercase ULID used to identify tokens, timestamped at now.
+// Return error if system's secure random number generator fails.
+func generateID(now time.Time) (string, error) {
+	id, err := ulid.New(ulid.Timestamp(now.UTC()), cryptorand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(id.String()), nil
+}
+
 // GenerateExpirationTimestamp returns the expiration date set with addDays parameter.
-// Currently only adds number of days to currentTimestamp.
+// Currently only adds number of days to currentTimestamp, use a LifetimePolicy for other lifetimes.
 // Returns error if date object is nil or error with loading location.
 func GenerateExpirationTimestamp(currentTimestamp time.Time, addDays int) (*time.Time, error) {
 	if currentTimestamp.IsZero() {
@@ -346,7 +683,20 @@ func GenerateExpirationTimestamp(currentTimestamp time.Time, addDays int) (*time
 
 // GenerateEmailIdentification takes the user's uuid and permission to generate an email token for verification.
 // Returns an identification containing the secret and token string.
+// Use an EmailVerifier to make the email token single use.
 func GenerateEmailIdentification(uuid string, permission string) (*pbauth.Identification, error) {
+	now := SystemClock.Now()
+	tokenID, err := generateID(now)
+	if err != nil {
+		return nil, err
+	}
+	return generateEmailIdentification(uuid, permission, tokenID, now)
+}
+
+// generateEmailIdentification generates an email token identified by tokenID for verification created at the given time.
+// Returns an identification containing the secret and token string.
+func generateEmailIdentification(uuid string, permission string, tokenID string,
+	now time.Time) (*pbauth.Identification, error) {
 	if err := validation.ValidateUserUUID(uuid); err != nil {
 		return nil, err
 	}
@@ -358,9 +708,8 @@ func GenerateEmailIdentification(uuid string, permission string) (*pbauth.Identi
 	if err != nil {
 		return nil, err
 	}
-	// subtract a second because the test runs fast causing our check to fail
-	emailTokenCreationTime := time.Now().UTC().Add(time.Duration(-1) * time.Second)
-	emailTokenExpirationTime, err := GenerateExpirationTimestamp(emailTokenCreationTime, daysInTwoWeeks)
+	emailTokenCreationTime := now
+	emailTokenExpirationTime, err := DefaultLifetimePolicy.Expiration(Jet, permissionLevel, emailTokenCreationTime)
 	if err != nil {
 		return nil, err
 	}
@@ -373,13 +722,15 @@ func GenerateEmailIdentification(uuid string, permission string) (*pbauth.Identi
 		UUID:                uuid,
 		Permission:          permissionLevel,
 		ExpirationTimestamp: emailTokenExpirationTime.Unix(),
+		IssuedAt:            emailTokenCreationTime.Unix(),
+		ID:                  tokenID,
 	}
 	secret := &pbauth.Secret{
 		Key:                 emailSecretKey,
 		CreatedTimestamp:    emailTokenCreationTime.Unix(),
 		ExpirationTimestamp: emailTokenExpirationTime.Unix(),
 	}
-	emailToken, err := NewToken(header, body, secret)
+	emailToken, err := newToken(header, body, secret, now)
 	if err != nil {
 		return nil, err
 	}
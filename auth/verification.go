@@ -0,0 +1,147 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+	"sync"
+)
+
+// VerificationStore keeps track of the email tokens issued to every user.
+// Only the most recently issued email token of a user can be consumed, and only once.
+// A PurposeIssuer shares the store by prefixing the uuid with the token type.
+type VerificationStore interface {
+	// Record registers tokenID as the current email token of the user,
+	// superseding the email tokens issued before it.
+	Record(uuid string, tokenID string) error
+	// Consume marks tokenID as used.
+	// Returns consts.ErrVerificationTokenSuperseded if a newer email token was issued,
+	// or consts.ErrVerificationTokenConsumed if it was already used.
+	Consume(uuid string, tokenID string) error
+}
+
+// VerificationOption configures an EmailVerifier.
+type VerificationOption func(*EmailVerifier)
+
+// WithVerificationClock makes the email verifier read the current time from the clock.
+func WithVerificationClock(clock Clock) VerificationOption {
+	return func(e *EmailVerifier) {
+		e.clock = clock
+	}
+}
+
+// EmailVerifier issues and verifies single use email tokens (JET),
+// so that a verification link cannot be replayed.
+type EmailVerifier struct {
+	store VerificationStore
+	clock Clock
+}
+
+// NewEmailVerifier makes an email verifier that records issued email tokens in the store.
+// Returns an error if the store is nil.
+func NewEmailVerifier(store VerificationStore, opts ...VerificationOption) (*EmailVerifier, error) {
+	e := &EmailVerifier{
+		store: store,
+		clock: SystemClock,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if store == nil {
+		return nil, consts.ErrNilVerificationStore
+	}
+	return e, nil
+}
+
+// Issue generates an email token for the user's uuid and permission,
+// superseding the email tokens issued to the user before.
+// Returns an identification containing the secret and token string.
+func (e *EmailVerifier) Issue(uuid string, permission string) (*pbauth.Identification, error) {
+	now := e.clock.Now()
+	tokenID, err := generateID(now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := generateEmailIdentification(uuid, permission, tokenID, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.store.Record(uuid, tokenID); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Verify authorizes the email token and consumes it.
+// Returns the verified claims, or an error if not valid, superseded or already used.
+func (e *EmailVerifier) Verify(id *pbauth.Identification) (*Claims, error) {
+	v := &Verifier{
+		tokenRequired: Jet,
+		clock:         e.clock,
+	}
+	claims, err := v.Verify(id)
+	if err != nil {
+		return nil, err
+	}
+	body := claims.body
+	if strings.TrimSpace(body.ID) == "" {
+		return nil, consts.ErrInvalidVerificationToken
+	}
+	if err := e.store.Consume(body.UUID, body.ID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verificationRecord is the current email token of a user kept by memoryVerificationStore.
+type verificationRecord struct {
+	tokenID  string
+	consumed bool
+}
+
+// memoryVerificationStore is an in memory VerificationStore.
+type memoryVerificationStore struct {
+	locker  sync.Mutex
+	records map[string]*verificationRecord
+}
+
+// NewMemoryVerificationStore makes an in-memory VerificationStore.
+// Issued email tokens are lost when the process exits.
+func NewMemoryVerificationStore() VerificationStore {
+	return &memoryVerificationStore{
+		records: make(map[string]*verificationRecord),
+	}
+}
+
+// Record registers tokenID as the current email token of the user.
+func (s *memoryVerificationStore) Record(uuid string, tokenID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	s.records[uuid] = &verificationRecord{
+		tokenID: tokenID,
+	}
+	return nil
+}
+
+// Consume marks tokenID as used.
+func (s *memoryVerificationStore) Consume(uuid string, tokenID string) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	record, ok := s.records[uuid]
+	if !ok {
+		return consts.ErrUnknownVerificationToken
+	}
+	if record.tokenID != tokenID {
+		return consts.ErrVerificationTokenSuperseded
+	}
+	if record.consumed {
+		return consts.ErrVerificationTokenConsumed
+	}
+	record.consumed = true
+	return nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewEmailVerifier(t *testing.T) {
+	cases := []struct {
+		desc     string
+		store    VerificationStore
+		opts     []VerificationOption
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil store", nil, nil, true, consts.ErrNilVerificationStore},
+		{"test for nil clock", NewMemoryVerificationStore(), []VerificationOption{WithVerificationClock(nil)},
+			true, consts.ErrNilClock},
+		{"test for valid input", NewMemoryVerificationStore(), nil, false, nil},
+	}
+	for _, c := range cases {
+		e, err := NewEmailVerifier(c.store, c.opts...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, e, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, e, c.desc)
+		}
+	}
+}
+
+func TestEmailVerifier(t *testing.T) {
+	uuid := "01d3x3wm2nnrdfzp0tka2vw9dx"
+	e, err := NewEmailVerifier(NewMemoryVerificationStore())
+	assert.Nil(t, err)
+
+	desc := "test for invalid uuid"
+	id, err := e.Issue("", strUserRegistration)
+	assert.EqualError(t, err, consts.ErrInvalidUUID.Error(), desc)
+	assert.Nil(t, id, desc)
+
+	desc = "test for first use"
+	first, err := e.Issue(uuid, strUserRegistration)
+	assert.Nil(t, err, desc)
+	claims, err := e.Verify(first)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, uuid, claims.Body().UUID, desc)
+
+	desc = "test for replay"
+	claims, err = e.Verify(first)
+	assert.EqualError(t, err, consts.ErrVerificationTokenConsumed.Error(), desc)
+	assert.Nil(t, claims, desc)
+
+	desc = "test for superseded link"
+	second, err := e.Issue(uuid, strUserRegistration)
+	assert.Nil(t, err, desc)
+	third, err := e.Issue(uuid, strUserRegistration)
+	assert.Nil(t, err, desc)
+	_, err = e.Verify(second)
+	assert.EqualError(t, err, consts.ErrVerificationTokenSuperseded.Error(), desc)
+	_, err = e.Verify(third)
+	assert.Nil(t, err, desc)
+
+	desc = "test for email token not issued by the verifier"
+	other, err := GenerateEmailIdentification("01d3x3wm2nnrdfzp0tka2vw9dy", strUserRegistration)
+	assert.Nil(t, err, desc)
+	_, err = e.Verify(other)
+	assert.EqualError(t, err, consts.ErrUnknownVerificationToken.Error(), desc)
+
+	desc = "test for access token"
+	_, err = e.Verify(validUserIdentification)
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), desc)
+
+	desc = "test for email token without id"
+	secret := other.GetSecret()
+	token, err := NewToken(
+		&Header{Alg: Hs256, TokenTyp: Jet},
+		&Body{UUID: uuid, Permission: UserRegistration, ExpirationTimestamp: secret.GetExpirationTimestamp()},
+		secret,
+	)
+	assert.Nil(t, err, desc)
+	_, err = e.Verify(&pbauth.Identification{Token: token, Secret: secret})
+	assert.EqualError(t, err, consts.ErrInvalidVerificationToken.Error(), desc)
+}
+
+func TestEmailVerifierClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	e, err := NewEmailVerifier(NewMemoryVerificationStore(), WithVerificationClock(clock))
+	assert.Nil(t, err)
+	id, err := e.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", strUserRegistration)
+	assert.Nil(t, err)
+
+	clock.Advance(15 * 24 * time.Hour)
+	_, err = e.Verify(id)
+	assert.EqualError(t, err, consts.ErrExpiredSecret.Error(), "test for expired link")
+}
+
+func TestMemoryVerificationStore(t *testing.T) {
+	store := NewMemoryVerificationStore()
+	assert.EqualError(t, store.Consume("unknown", "a"), consts.ErrUnknownVerificationToken.Error())
+	assert.Nil(t, store.Record("user", "a"))
+	assert.Nil(t, store.Record("other", "a"))
+
+	// only one of the concurrent uses of the same email token can win
+	const count = 50
+	var wg sync.WaitGroup
+	var locker sync.Mutex
+	succeeded := 0
+	wg.Add(count)
+	start := make(chan struct{})
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			if err := store.Consume("user", "a"); err == nil {
+				locker.Lock()
+				succeeded++
+				locker.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.Equal(t, 1, succeeded)
+	assert.EqualError(t, store.Consume("user", "a"), consts.ErrVerificationTokenConsumed.Error())
+	assert.Nil(t, store.Consume("other", "a"), "test for other users being unaffected")
+}
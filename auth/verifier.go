@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"crypto/hmac"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+	"time"
+)
+
+// Claims are the verified header and body of a token.
+// Claims cannot be mutated, the accessors return copies.
+type Claims struct {
+	header *Header
+	body   *Body
+}
+
+// Header extracts a copy of the verified header.
+func (c *Claims) Header() *Header {
+	return copyHeader(c.header)
+}
+
+// Body extracts a copy of the verified body.
+func (c *Claims) Body() *Body {
+	return copyBody(c.body)
+}
+
+// VerifierOption configures a Verifier.
+type VerifierOption func(*Verifier)
+
+// WithClock makes the verifier read the current time from the clock.
+func WithClock(clock Clock) VerifierOption {
+	return func(v *Verifier) {
+		v.clock = clock
+	}
+}
+
+// WithLeeway makes the verifier tolerate a clock skew of leeway between services
+// when checking the timestamps of tokens and secrets.
+// Negative leeways are ignored.
+func WithLeeway(leeway time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		if leeway > 0 {
+			v.leeway = leeway
+		}
+	}
+}
+
+// WithMaxAge makes the verifier reject tokens issued more than maxAge ago,
+// even if they have not expired, e.g. to force Admin tokens to be re-issued hourly.
+// The limit applies to tokens with any of the permissions, or to every token if none is given.
+// Tokens without an issued at timestamp cannot prove their age and are rejected.
+// Non-positive ages are ignored.
+func WithMaxAge(maxAge time.Duration, permissions ...Permission) VerifierOption {
+	return func(v *Verifier) {
+		if maxAge <= 0 {
+			return
+		}
+		if len(permissions) == 0 {
+			v.maxAge = maxAge
+			return
+		}
+		if v.permissionMaxAge == nil {
+			v.permissionMaxAge = make(map[Permission]time.Duration)
+		}
+		for _, permission := range permissions {
+			v.permissionMaxAge[permission] = maxAge
+		}
+	}
+}
+
+// WithIssuer makes the verifier only accept tokens minted by the issuer.
+func WithIssuer(issuer string) VerifierOption {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience makes the verifier only accept tokens meant for at least one of the audiences,
+// ie: the name of the service verifying the token.
+func WithAudience(audiences ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.audiences = append(v.audiences, audiences...)
+	}
+}
+
+// WithDecrypter makes the verifier decrypt tokens before verifying their signature and claims.
+// Tokens that are not encrypted are rejected, so that confidential claims are never sent in the clear.
+func WithDecrypter(decrypter *Decrypter) VerifierOption {
+	return func(v *Verifier) {
+		v.decrypter = decrypter
+	}
+}
+
+// WithPrincipals makes the verifier only accept tokens of the principal types,
+// ie: WithPrincipals(ServicePrincipal) for an operation reserved to other services.
+// Verifiers only accept UserPrincipal tokens by default.
+func WithPrincipals(principals ...PrincipalType) VerifierOption {
+	return func(v *Verifier) {
+		v.principals = append([]PrincipalType{}, principals...)
+	}
+}
+
+// WithServices makes the verifier also accept ServicePrincipal tokens,
+// only from the named services if any is given.
+func WithServices(services ...string) VerifierOption {
+	return func(v *Verifier) {
+		if v.principals == nil {
+			v.principals = []PrincipalType{UserPrincipal}
+		}
+		v.principals = append(v.principals, ServicePrincipal)
+		v.services = append(v.services, services...)
+	}
+}
+
+// WithImpersonation makes the verifier authorize the actors of impersonation tokens with the policy
+// instead of DefaultImpersonationPolicy.
+func WithImpersonation(policy *ImpersonationPolicy) VerifierOption {
+	return func(v *Verifier) {
+		v.impersonation = policy
+	}
+}
+
+// WithoutImpersonation makes the verifier reject impersonation tokens,
+// ie: for operations only users may perform themselves such as changing their password.
+func WithoutImpersonation() VerifierOption {
+	return func(v *Verifier) {
+		v.noImpersonation = true
+	}
+}
+
+// WithFailureTracker makes the verifier lock out clients that repeatedly present forged, tampered or expired
+// tokens, tracking the IP of the client when the presentation carries it and any of the keys,
+// ie: "ip:" followed by the client IP for an Authority made per request.
+// Returns consts.ErrLockedOut without verifying the token while a key is locked out.
+func WithFailureTracker(tracker *FailureTracker, keys ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.failures = tracker
+		v.trackedKeys = append(v.trackedKeys, keys...)
+	}
+}
+
+// WithUUIDFailureTracking makes the failure tracker of the verifier also track the UUID claimed by tokens.
+// Failing tokens are not authentic, so anyone can lock a user out by forging tokens with the user's UUID:
+// only enable it where the lockout of a user is preferable to the risk of a brute force.
+func WithUUIDFailureTracking() VerifierOption {
+	return func(v *Verifier) {
+		v.trackUUID = true
+	}
+}
+
+// Verifier verifies identifications against the required token type and permission level.
+type Verifier struct {
+	tokenRequired      TokenType
+	permissionRequired Permission
+	clock              Clock
+	leeway             time.Duration
+	maxAge             time.Duration
+	permissionMaxAge   map[Permission]time.Duration
+	issuer             string
+	audiences          []string
+	decrypter          *Decrypter
+	principals         []PrincipalType
+	services           []string
+	impersonation      *ImpersonationPolicy
+	noImpersonation    bool
+	failures           *FailureTracker
+	trackedKeys        []string
+	trackUUID          bool
+	sessions           SessionStore
+	sessionTouch       time.Duration
+	mfa                bool
+	mfaPermissions     map[Permission]bool
+	proofReplays       ProofReplayStore
+}
+
+// NewVerifier makes a verifier with the required token and permission level.
+// The verifier defaults to NoPermission if unknown permission level is used.
+func NewVerifier(tokenRequired TokenType, permissionRequired Permission, opts ...VerifierOption) *Verifier {
+	permission := NoPermission
+	if permissionRequired > NoPermission && permissionRequired <= Admin {
+		permission = permissionRequired
+	}
+	token := NoType
+	if isSupportedTokenType(tokenRequired) {
+		token = tokenRequired
+	}
+	v := &Verifier{
+		tokenRequired:      token,
+		permissionRequired: permission,
+		clock:              SystemClock,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify checks if the identification is authorized using its secret.
+// Tokens bound to a key or certificate are rejected, use VerifyPresentation instead.
+// Returns the verified claims, or an error if not valid.
+func (v *Verifier) Verify(id *pbauth.Identification) (*Claims, error) {
+	return v.VerifyPresentation(id, nil)
+}
+
+// VerifyPresentation checks if the identification is authorized using its secret,
+// and if the token has a Confirmation, that the presentation proves possession of the bound key or certificate.
+// With a FailureTracker, a success only clears the failures of the verified UUID:
+// the failures of the client age out of the window, so that valid tokens cannot hide forged ones.
+// Checking the lockout and recording the outcome are separate steps, so concurrent verifications
+// of a client all pass the check before any of their failures is recorded:
+// bound the requests in flight per client where a burst exceeding the limit matters.
+// Returns the verified claims, or an error if not valid.
+func (v *Verifier) VerifyPresentation(id *pbauth.Identification, p *Presentation) (*Claims, error) {
+	if v.failures == nil {
+		return v.verify(id, p)
+	}
+	keys := v.failureKeys(id.GetToken(), p)
+	if err := v.failures.Check(keys...); err != nil {
+		return nil, err
+	}
+	claims, err := v.verify(id, p)
+	// errors of the store while recording are ignored, they already fail the next check
+	if err == nil {
+		if v.trackUUID {
+			_ = v.failures.Success(uuidFailureKeyPrefix + claims.body.UUID)
+		}
+	} else if isTrackedFailure(err) {
+		_ = v.failures.Failure(keys...)
+	}
+	return claims, err
+}
+
+// verify checks the identification and the presentation.
+func (v *Verifier) verify(id *pbauth.Identification, p *Presentation) (*Claims, error) {
+	now := v.now()
+	if err := validateIdentification(id, now, v.leeway); err != nil {
+		return nil, err
+	}
+	token := id.GetToken()
+	if v.decrypter != nil {
+		if !isEncryptedToken(token) {
+			return nil, consts.ErrTokenNotEncrypted
+		}
+		decrypted, err := v.decrypter.Decrypt(token)
+		if err != nil {
+			return nil, err
+		}
+		token = decrypted
+	}
+	tokenSignature := strings.Split(token, ".")
+	// check 1: do we have a header, body, and signature?
+	if len(tokenSignature) != 3 {
+		return nil, consts.ErrIncompleteToken
+	}
+	// check 2: decode header
+	decodedHeader, err := base64Decode(tokenSignature[0])
+	if err != nil {
+		return nil, err
+	}
+	// check 3: parses header from string to a struct
+	header, err := decodeHeader(decodedHeader)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateHeader(header); err != nil {
+		return nil, err
+	}
+	// check 4: decode body
+	decodedBody, err := base64Decode(tokenSignature[1])
+	if err != nil {
+		return nil, err
+	}
+	// check 5: parses body from string to a struct
+	body, err := decodeBody(decodedBody)
+	if err != nil {
+		return nil, err
+	}
+	// check expiration and not before in body
+	if err := validateBody(body, now, v.leeway); err != nil {
+		return nil, err
+	}
+	// check 6: rebuild the signature using the secret,
+	// before any claim policy so that forged tokens cannot probe the policies
+	expectedSignature, err := computeSignature(header.Alg, tokenSignature[0]+"."+tokenSignature[1], id.GetSecret())
+	if err != nil {
+		return nil, err
+	}
+	// check 7: the signature in the token should be the same with the expected signature,
+	// compared in constant time to not leak how much of the signature matches
+	signature, err := decodeSignature(tokenSignature[2])
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, expectedSignature) {
+		return nil, consts.ErrInvalidSignature
+	}
+	if err := v.validateAge(body, now); err != nil {
+		return nil, err
+	}
+	if err := v.validateIssuer(body); err != nil {
+		return nil, err
+	}
+	if err := v.validatePrincipal(body); err != nil {
+		return nil, err
+	}
+	if err := v.validateActor(body); err != nil {
+		return nil, err
+	}
+	if err := v.validateMFA(body); err != nil {
+		return nil, err
+	}
+	// check 8: checks permission requirement
+	if body.Permission < v.permissionRequired {
+		return nil, consts.ErrInvalidPermission
+	}
+	if body.Permission == Admin && header.Alg != Hs512 {
+		return nil, consts.ErrInvalidPermission
+	}
+	// check 9: checks token type
+	if header.TokenTyp != v.tokenRequired {
+		return nil, consts.ErrInvalidRequiredTokenType
+	}
+	// check 10: the client holds the key or certificate the token is bound to
+	if body.Confirmation != nil {
+		if err := checkPossession(id.GetToken(), body.Confirmation, p, v.proofReplays, now, v.leeway); err != nil {
+			return nil, err
+		}
+	}
+	// check 11: the session of the token has not been revoked
+	if v.sessions != nil && isSessionTokenType(header.TokenTyp) {
+		touchInterval := v.sessionTouch
+		if touchInterval == 0 {
+			touchInterval = DefaultSessionTouchInterval
+		}
+		if err := checkSession(v.sessions, body, now, touchInterval); err != nil {
+			return nil, err
+		}
+	}
+	return &Claims{
+		header: header,
+		body:   body,
+	}, nil
+}
+
+// now reads the current time from the clock of the verifier.
+func (v *Verifier) now() time.Time {
+	if v.clock == nil {
+		return SystemClock.Now()
+	}
+	return v.clock.Now()
+}
+
+// validateAge checks that the body was issued within the maximum age for its permission.
+func (v *Verifier) validateAge(body *Body, now time.Time) error {
+	maxAge, ok := v.permissionMaxAge[body.Permission]
+	if !ok {
+		maxAge = v.maxAge
+	}
+	if maxAge <= 0 {
+		return nil
+	}
+	if body.IssuedAt <= 0 {
+		return consts.ErrInvalidIssuedAt
+	}
+	if now.Sub(time.Unix(body.IssuedAt, 0)) > maxAge+v.leeway {
+		return consts.ErrTokenTooOld
+	}
+	return nil
+}
+
+// validateIssuer checks that the body was minted by the required issuer
+// for one of the required audiences.
+func (v *Verifier) validateIssuer(body *Body) error {
+	if v.issuer != "" && body.Issuer != v.issuer {
+		return consts.ErrIssuerMismatch
+	}
+	if len(v.audiences) == 0 {
+		return nil
+	}
+	for _, required := range v.audiences {
+		for _, audience := range body.Audience {
+			if audience == required {
+				return nil
+			}
+		}
+	}
+	return consts.ErrAudienceMismatch
+}
+
+// validatePrincipal checks that the verifier accepts the principal type of the body,
+// and the name of the service for service tokens.
+func (v *Verifier) validatePrincipal(body *Body) error {
+	principals := v.principals
+	if principals == nil {
+		principals = []PrincipalType{UserPrincipal}
+	}
+	accepted := false
+	for _, principal := range principals {
+		if principal == body.Principal {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		return consts.ErrPrincipalNotAllowed
+	}
+	if body.Principal != ServicePrincipal || len(v.services) == 0 {
+		return nil
+	}
+	for _, service := range v.services {
+		if service == body.Service {
+			return nil
+		}
+	}
+	return consts.ErrServiceNotAllowed
+}
+
+// validateActor checks that the impersonation policy of the verifier lets the actors of the body act as its principal.
+func (v *Verifier) validateActor(body *Body) error {
+	if body.Actor == nil {
+		return nil
+	}
+	if v.noImpersonation {
+		return consts.ErrImpersonationNotAllowed
+	}
+	policy := v.impersonation
+	if policy == nil {
+		policy = DefaultImpersonationPolicy
+	}
+	return policy.Authorize(body)
+}
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewVerifier(t *testing.T) {
+	cases := []struct {
+		desc          string
+		requiredToken TokenType
+		expToken      TokenType
+		requiredPerm  Permission
+		expPermLevel  Permission
+	}{
+		{"test for negative token type", NoType - 1, NoType, -1, NoPermission},
+		{"test for negative permission", Jwt, Jwt, -1, NoPermission},
+		{"test for User", Jwt, Jwt, User, User},
+		{"test for Admin", Jwt, Jwt, Admin, Admin},
+		{"test for over permission", Jwt, Jwt, Admin + 1, NoPermission},
+		{"test for every supported token type", Jmt, Jmt, User, User},
+		{"test for over max token type", Jmt + 1, NoType, User, User},
+	}
+	for _, c := range cases {
+		v := NewVerifier(c.requiredToken, c.requiredPerm)
+		assert.Equal(t, c.expPermLevel, v.permissionRequired, c.desc)
+		assert.Equal(t, c.expToken, v.tokenRequired, c.desc)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		desc     string
+		verifier *Verifier
+		id       *pbauth.Identification
+		isExpErr bool
+		expErr   error
+		expBody  *Body
+	}{
+		{"test for nil identification", NewVerifier(Jwt, User), nil, true, consts.ErrNilIdentification, nil},
+		{"test for invalid token string", NewVerifier(Jwt, User),
+			&pbauth.Identification{Token: "a.b", Secret: validSecret}, true, consts.ErrIncompleteToken, nil},
+		{"test for expired user token", NewVerifier(Jwt, User),
+			&pbauth.Identification{Token: expiredUserToken, Secret: validSecret}, true, consts.ErrExpiredBody, nil},
+		{"test for fake token", NewVerifier(Jwt, User),
+			&pbauth.Identification{Token: fakeToken, Secret: validSecret}, true, consts.ErrInvalidSignature, nil},
+		{"test for user token versus admin requirement", NewVerifier(Jwt, Admin), validUserIdentification,
+			true, consts.ErrInvalidPermission, nil},
+		{"test for HS256 with Admin permission", NewVerifier(Jwt, User),
+			&pbauth.Identification{Token: invalid256JWTTokenString, Secret: validSecret},
+			true, consts.ErrInvalidPermission, nil},
+		{"test for no type token versus jwt requirement", NewVerifier(Jwt, User),
+			&pbauth.Identification{Token: invalid256NoTypeTokenString, Secret: validSecret},
+			true, consts.ErrInvalidRequiredTokenType, nil},
+		{"test for valid user token", NewVerifier(Jwt, User), validUserIdentification, false, nil, validUserBody},
+		{"test for valid admin token", NewVerifier(Jwt, Admin), validAdminIdentification, false, nil, validAdminBody},
+	}
+	for _, c := range cases {
+		claims, err := c.verifier.Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, claims, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, c.expBody, claims.Body(), c.desc)
+		}
+	}
+}
+
+func TestVerifySignatureBeforePolicies(t *testing.T) {
+	forgedSecret := &pbauth.Secret{
+		Key:                 "Zm9yZ2VkLXNlY3JldC1rZXktdGhhdC1pcy1ub3QtdGhlLXJlYWwtb25l",
+		CreatedTimestamp:    validCreatedTimestamp,
+		ExpirationTimestamp: validExpirationTimestamp,
+	}
+	token, err := NewToken(valid512JWT, &Body{
+		UUID:                validUserBody.UUID,
+		Permission:          Admin,
+		ExpirationTimestamp: validUserBody.ExpirationTimestamp,
+		Issuer:              "forged",
+		Actor:               &Actor{UUID: validAdminBody.UUID, Permission: Admin},
+	}, forgedSecret)
+	assert.Nil(t, err)
+	id := &pbauth.Identification{Token: token, Secret: validSecret}
+
+	cases := []struct {
+		desc     string
+		verifier *Verifier
+	}{
+		{"test for impersonation policy", NewVerifier(Jwt, Admin, WithoutImpersonation())},
+		{"test for mfa policy", NewVerifier(Jwt, Admin, WithMFA())},
+		{"test for issuer policy", NewVerifier(Jwt, Admin, WithIssuer("hwsc-user-svc"))},
+		{"test for principal policy", NewVerifier(Jwt, Admin, WithPrincipals(ServicePrincipal))},
+		{"test for token type", NewVerifier(Jet, Admin)},
+	}
+	for _, c := range cases {
+		_, err := c.verifier.Verify(id)
+		assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), c.desc)
+		assert.False(t, isForbidden(err), c.desc)
+	}
+}
+
+func TestClaimsImmutable(t *testing.T) {
+	claims, err := NewVerifier(Jwt, User).Verify(validUserIdentification)
+	assert.Nil(t, err)
+
+	body := claims.Body()
+	body.UUID = "mutate"
+	body.Roles = append(body.Roles, "mutate")
+	assert.Equal(t, validUserBody.UUID, claims.Body().UUID)
+	assert.Nil(t, claims.Body().Roles)
+
+	header := claims.Header()
+	header.Alg = NoAlg
+	assert.Equal(t, Hs256, claims.Header().Alg)
+}
+
+func TestVerifyParallel(t *testing.T) {
+	// a single verifier is shared between goroutines, run with -race
+	const count = 200
+	verifier := NewVerifier(Jwt, User)
+	ids := []*pbauth.Identification{
+		validUserIdentification,
+		validAdminIdentification,
+		{Token: fakeToken, Secret: validSecret},
+		{Token: expiredUserToken, Secret: validSecret},
+	}
+	expUUIDs := []string{validUserBody.UUID, validAdminBody.UUID, "", ""}
+
+	var wg sync.WaitGroup
+	wg.Add(count)
+	start := make(chan struct{})
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			n := i % len(ids)
+			claims, err := verifier.Verify(ids[n])
+			if expUUIDs[n] == "" {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, expUUIDs[n], claims.Body().UUID)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestAuthorityReuse(t *testing.T) {
+	a := NewAuthority(Jwt, User)
+	assert.Nil(t, a.Authorize(validAdminIdentification), "test for first authorization")
+	assert.Equal(t, validAdminBody.UUID, a.Body().UUID, "test for first authorization")
+
+	assert.Nil(t, a.Authorize(validUserIdentification), "test for reusing the authority")
+	assert.Equal(t, validUserBody.UUID, a.Body().UUID, "test for reusing the authority")
+	assert.Equal(t, Hs256, a.Header().Alg, "test for reusing the authority")
+
+	err := a.Authorize(&pbauth.Identification{Token: fakeToken, Secret: validSecret})
+	assert.EqualError(t, err, consts.ErrInvalidSignature.Error(), "test for failed authorization")
+	assert.Equal(t, validUserBody.UUID, a.Body().UUID, "test for failed authorization keeping last state")
+
+	// after invalidating, the authority no longer panics nor fails to unmarshal,
+	// it only rejects tokens because its requirement was reset
+	a.Invalidate()
+	err = a.Authorize(validUserIdentification)
+	assert.EqualError(t, err, consts.ErrInvalidRequiredTokenType.Error(), "test for authorizing after invalidate")
+}
+
+func TestVerifyClock(t *testing.T) {
+	// validUserBody expires at 2030-01-01 and validSecret was created at 2019-01-01
+	expiration := time.Unix(validUserBody.ExpirationTimestamp, 0)
+	created := time.Unix(validCreatedTimestamp, 0)
+	cases := []struct {
+		desc     string
+		now      time.Time
+		leeway   time.Duration
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for a second before expiration", expiration.Add(-time.Second), 0, false, nil},
+		{"test for expiration", expiration, 0, true, consts.ErrExpiredBody},
+		{"test for expiration with leeway", expiration, time.Minute, false, nil},
+		{"test for expiration past leeway", expiration.Add(time.Minute), time.Minute, true, consts.ErrExpiredBody},
+		{"test for secret created in the future", created.Add(-time.Second), 0, true,
+			consts.ErrInvalidSecretCreateTimestamp},
+		{"test for secret created in the future with leeway", created.Add(-time.Second), time.Minute, false, nil},
+		{"test for negative leeway", expiration, -time.Minute, true, consts.ErrExpiredBody},
+	}
+	for _, c := range cases {
+		clock := NewFakeClock(c.now)
+		v := NewVerifier(Jwt, User, WithClock(clock), WithLeeway(c.leeway))
+		_, err := v.Verify(validUserIdentification)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+
+	desc := "test for nil clock falling back to the system clock"
+	_, err := NewVerifier(Jwt, User, WithClock(nil)).Verify(validUserIdentification)
+	assert.Nil(t, err, desc)
+}
+
+func TestVerifyNotBefore(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	token, err := newToken(
+		&Header{Alg: Hs256, TokenTyp: Jwt},
+		&Body{
+			UUID:                "01d3x3wm2nnrdfzp0tka2vw9dx",
+			Permission:          User,
+			ExpirationTimestamp: now.Add(2 * time.Hour).Unix(),
+			IssuedAt:            now.Unix(),
+			NotBefore:           now.Add(time.Hour).Unix(),
+		},
+		validSecret,
+		now,
+	)
+	assert.Nil(t, err, "test for issuing a token valid later")
+	id := &pbauth.Identification{Token: token, Secret: validSecret}
+
+	cases := []struct {
+		desc     string
+		now      time.Time
+		leeway   time.Duration
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for before not before", now, 0, true, consts.ErrTokenNotYetValid},
+		{"test for a second before not before", now.Add(time.Hour - time.Second), 0, true, consts.ErrTokenNotYetValid},
+		{"test for a second before not before with leeway", now.Add(time.Hour - time.Second), time.Minute, false, nil},
+		{"test for not before", now.Add(time.Hour), 0, false, nil},
+		{"test for expired", now.Add(2 * time.Hour), 0, true, consts.ErrExpiredBody},
+	}
+	for _, c := range cases {
+		v := NewVerifier(Jwt, User, WithClock(NewFakeClock(c.now)), WithLeeway(c.leeway))
+		claims, err := v.Verify(id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, now.Unix(), claims.Body().IssuedAt, c.desc)
+			assert.Equal(t, now.Add(time.Hour).Unix(), claims.Body().NotBefore, c.desc)
+		}
+	}
+
+	desc := "test for issuing a token in the future"
+	_, err = newToken(
+		&Header{Alg: Hs256, TokenTyp: Jwt},
+		&Body{
+			UUID:                "01d3x3wm2nnrdfzp0tka2vw9dx",
+			Permission:          User,
+			ExpirationTimestamp: now.Add(2 * time.Hour).Unix(),
+			IssuedAt:            now.Add(time.Hour).Unix(),
+		},
+		validSecret,
+		now,
+	)
+	assert.EqualError(t, err, consts.ErrInvalidIssuedAt.Error(), desc)
+}
+
+func TestVerifyMaxAge(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(now)
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClock(clock),
+		WithLifetimePolicy(DefaultLifetimePolicy))
+	assert.Nil(t, err)
+	user, err := issuer.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", User)
+	assert.Nil(t, err)
+	admin, err := issuer.Issue("01d3x3wm2nnrdfzp0tka2vw9dx", Admin)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc     string
+		opts     []VerifierOption
+		id       *pbauth.Identification
+		age      time.Duration
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for no max age", nil, admin.Access, 12 * time.Hour, false, nil},
+		{"test for fresh admin token", []VerifierOption{WithMaxAge(time.Hour, Admin)},
+			admin.Access, time.Hour, false, nil},
+		{"test for old admin token", []VerifierOption{WithMaxAge(time.Hour, Admin)},
+			admin.Access, time.Hour + time.Second, true, consts.ErrTokenTooOld},
+		{"test for old admin token with leeway", []VerifierOption{WithMaxAge(time.Hour, Admin), WithLeeway(time.Minute)},
+			admin.Access, time.Hour + time.Second, false, nil},
+		{"test for user token without limit", []VerifierOption{WithMaxAge(time.Hour, Admin)},
+			user.Access, 2 * time.Hour, false, nil},
+		{"test for user token with default limit", []VerifierOption{WithMaxAge(time.Hour, Admin), WithMaxAge(90 * time.Minute)},
+			user.Access, 2 * time.Hour, true, consts.ErrTokenTooOld},
+		{"test for permission limit over default limit", []VerifierOption{WithMaxAge(3*time.Hour, Admin), WithMaxAge(time.Hour)},
+			admin.Access, 2 * time.Hour, false, nil},
+		{"test for non-positive max age", []VerifierOption{WithMaxAge(0), WithMaxAge(-time.Hour, Admin)},
+			admin.Access, 12 * time.Hour, false, nil},
+		{"test for token without issued at", []VerifierOption{WithMaxAge(24 * time.Hour)},
+			validUserIdentification, 0, true, consts.ErrInvalidIssuedAt},
+	}
+	for _, c := range cases {
+		clock.Set(now.Add(c.age))
+		opts := append([]VerifierOption{WithClock(clock)}, c.opts...)
+		_, err := NewVerifier(Jwt, User, opts...).Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
+
+func TestVerifyIssuer(t *testing.T) {
+	uuid := "01d3x3wm2nnrdfzp0tka2vw9dx"
+	issuer, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(),
+		WithIssuerClaims("hwsc-user-svc", "hwsc-document-svc", "hwsc-file-transaction-svc"))
+	assert.Nil(t, err)
+	pair, err := issuer.Issue(uuid, User)
+	assert.Nil(t, err)
+	other, err := NewTokenIssuer(validSecret, NewMemoryRefreshStore(), WithIssuerClaims("hwsc-app-gateway-svc"))
+	assert.Nil(t, err)
+	otherPair, err := other.Issue(uuid, User)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc     string
+		opts     []VerifierOption
+		id       *pbauth.Identification
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for no requirement", nil, pair.Access, false, nil},
+		{"test for no requirement without claims", nil, validUserIdentification, false, nil},
+		{"test for matching issuer", []VerifierOption{WithIssuer("hwsc-user-svc")}, pair.Access, false, nil},
+		{"test for other issuer", []VerifierOption{WithIssuer("hwsc-user-svc")}, otherPair.Access,
+			true, consts.ErrIssuerMismatch},
+		{"test for missing issuer", []VerifierOption{WithIssuer("hwsc-user-svc")}, validUserIdentification,
+			true, consts.ErrIssuerMismatch},
+		{"test for matching audience", []VerifierOption{WithAudience("hwsc-document-svc")}, pair.Access, false, nil},
+		{"test for one of the audiences",
+			[]VerifierOption{WithAudience("hwsc-app-gateway-svc"), WithAudience("hwsc-file-transaction-svc")},
+			pair.Access, false, nil},
+		{"test for other audience", []VerifierOption{WithAudience("hwsc-app-gateway-svc")}, pair.Access,
+			true, consts.ErrAudienceMismatch},
+		{"test for missing audience", []VerifierOption{WithAudience("hwsc-document-svc")}, otherPair.Access,
+			true, consts.ErrAudienceMismatch},
+		{"test for issuer and audience",
+			[]VerifierOption{WithIssuer("hwsc-user-svc"), WithAudience("hwsc-document-svc")},
+			pair.Access, false, nil},
+	}
+	for _, c := range cases {
+		_, err := NewVerifier(Jwt, User, c.opts...).Verify(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+
+		a := NewAuthority(Jwt, User, c.opts...)
+		err = a.Authorize(c.id)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+
+	desc := "test for refresh token of another issuer"
+	_, err = issuer.Exchange(otherPair.Refresh.GetToken())
+	assert.EqualError(t, err, consts.ErrIssuerMismatch.Error(), desc)
+
+	desc = "test for refreshed claims"
+	pair, err = issuer.Exchange(pair.Refresh.GetToken())
+	assert.Nil(t, err, desc)
+	claims, err := NewVerifier(Jwt, User).Verify(pair.Access)
+	assert.Nil(t, err, desc)
+	assert.Equal(t, "hwsc-user-svc", claims.Body().Issuer, desc)
+	assert.Equal(t, []string{"hwsc-document-svc", "hwsc-file-transaction-svc"}, claims.Body().Audience, desc)
+}
@@ -0,0 +1,313 @@
+// Command hwsc-token decodes, verifies and mints hwsc tokens, and generates secrets,
+// to debug "unauthorized" reports without decoding tokens by hand.
+//
+// Usage:
+//
+//	hwsc-token decode [-json] <token>
+//	hwsc-token verify [-json] [-type JWT] [-permission USER] [-principal USER] [-leeway 0s] (-key <key> | -secret-file <file>) <token>
+//	hwsc-token mint [-json] -uuid <uuid> [-type JWT] [-permission USER] [-expires 1h] (-key <key> | -secret-file <file>)
+//	hwsc-token secret [-json] [-size 32] [-expires 720h]
+//
+// A secret file is a JSON encoded secret as printed by "hwsc-token secret -json", ie:
+// {"key": "...", "created_timestamp": 1546300800, "expiration_timestamp": 2493072000}.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const usage = `usage: hwsc-token <command> [flags]
+
+commands:
+  decode   decode the header and body of a token without verifying it
+  verify   verify a token against a secret
+  mint     mint a test token
+  secret   generate a secret
+
+run "hwsc-token <command> -h" for the flags of a command
+`
+
+var (
+	errMissingToken  = errors.New("missing token argument")
+	errMissingSecret = errors.New("missing -key or -secret-file")
+	errUnknownType   = errors.New("unknown token type")
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, auth.SystemClock))
+}
+
+// run executes the command in args, writing results to stdout and usage errors to stderr.
+// Returns the exit code.
+func run(args []string, stdout io.Writer, stderr io.Writer, clock auth.Clock) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	cmd := &command{
+		stdout: stdout,
+		stderr: stderr,
+		clock:  clock,
+	}
+	switch args[0] {
+	case "decode":
+		return cmd.decode(args[1:])
+	case "verify":
+		return cmd.verify(args[1:])
+	case "mint":
+		return cmd.mint(args[1:])
+	case "secret":
+		return cmd.secret(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+	return exitUsage
+}
+
+// command holds the outputs shared by the subcommands.
+type command struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	clock   auth.Clock
+	jsonOut bool
+}
+
+// flagSet makes a flag set for the subcommand with the common -json flag.
+func (c *command) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.BoolVar(&c.jsonOut, "json", false, "print JSON instead of human readable output")
+	return fs
+}
+
+// decode prints the header and body of a token without verifying it.
+func (c *command) decode(args []string) int {
+	fs := c.flagSet("decode")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	token, err := tokenArg(fs)
+	if err != nil {
+		return c.usageError(fs, err)
+	}
+	header, body, err := auth.DecodeToken(token)
+	r := c.newReport(header, body)
+	if err != nil {
+		r.setError(err)
+		return c.print(r, exitFailure)
+	}
+	return c.print(r, exitOK)
+}
+
+// verify verifies a token against a secret and the required token type and permission.
+func (c *command) verify(args []string) int {
+	fs := c.flagSet("verify")
+	typ := fs.String("type", "JWT", "required token type: "+strings.Join(tokenTypeNames(), ", "))
+	permission := fs.String("permission", "USER", "required permission: NO_PERM, USER_REGISTRATION, USER, ADMIN")
+	principal := fs.String("principal", "USER", "required principal: USER, SERVICE")
+	leeway := fs.Duration("leeway", 0, "tolerated clock skew")
+	key := fs.String("key", "", "secret key the token is signed with")
+	secretFile := fs.String("secret-file", "", "JSON encoded secret the token is signed with")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	token, err := tokenArg(fs)
+	if err != nil {
+		return c.usageError(fs, err)
+	}
+	tokenType, ok := parseTokenType(*typ)
+	if !ok {
+		return c.usageError(fs, errUnknownType)
+	}
+	perm, ok := auth.PermissionEnumMap[strings.ToUpper(*permission)]
+	if !ok {
+		return c.usageError(fs, errors.New("unknown permission"))
+	}
+	principalType, ok := parsePrincipalType(*principal)
+	if !ok {
+		return c.usageError(fs, errors.New("unknown principal"))
+	}
+	secret, err := c.loadSecret(*key, *secretFile)
+	if err != nil {
+		return c.usageError(fs, err)
+	}
+
+	header, body, _ := auth.DecodeToken(token)
+	r := c.newReport(header, body)
+	v := auth.NewVerifier(tokenType, perm, auth.WithClock(c.clock), auth.WithLeeway(*leeway),
+		auth.WithPrincipals(principalType))
+	if _, err := v.Verify(&pbauth.Identification{Token: token, Secret: secret}); err != nil {
+		r.setError(err)
+		return c.print(r, exitFailure)
+	}
+	valid := true
+	r.Valid = &valid
+	return c.print(r, exitOK)
+}
+
+// mint signs a test token for a uuid, permission and token type.
+func (c *command) mint(args []string) int {
+	fs := c.flagSet("mint")
+	uuid := fs.String("uuid", "", "uuid of the user")
+	typ := fs.String("type", "JWT", "token type: "+strings.Join(tokenTypeNames(), ", "))
+	permission := fs.String("permission", "USER", "permission: NO_PERM, USER_REGISTRATION, USER, ADMIN")
+	expires := fs.Duration("expires", time.Hour, "lifetime of the token")
+	key := fs.String("key", "", "secret key to sign the token with")
+	secretFile := fs.String("secret-file", "", "JSON encoded secret to sign the token with")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	tokenType, ok := parseTokenType(*typ)
+	if !ok {
+		return c.usageError(fs, errUnknownType)
+	}
+	perm, ok := auth.PermissionEnumMap[strings.ToUpper(*permission)]
+	if !ok {
+		return c.usageError(fs, errors.New("unknown permission"))
+	}
+	secret, err := c.loadSecret(*key, *secretFile)
+	if err != nil {
+		return c.usageError(fs, err)
+	}
+
+	now := c.clock.Now()
+	header := &auth.Header{
+		Alg:      auth.AlgorithmMap[perm],
+		TokenTyp: tokenType,
+	}
+	body := &auth.Body{
+		UUID:                *uuid,
+		Permission:          perm,
+		ExpirationTimestamp: now.Add(*expires).Unix(),
+		IssuedAt:            now.Unix(),
+	}
+	r := c.newReport(header, body)
+	token, err := auth.NewTokenAt(header, body, secret, now)
+	if err != nil {
+		r.setError(err)
+		return c.print(r, exitFailure)
+	}
+	r.Token = token
+	return c.print(r, exitOK)
+}
+
+// secret generates a secret key valid from now.
+func (c *command) secret(args []string) int {
+	fs := c.flagSet("secret")
+	size := fs.Int("size", auth.SecretByteSize, "number of random bytes of the key")
+	expires := fs.Duration("expires", 30*24*time.Hour, "lifetime of the secret")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	key, err := auth.GenerateSecretKey(*size)
+	if err != nil {
+		r := &report{}
+		r.setError(err)
+		return c.print(r, exitFailure)
+	}
+	now := c.clock.Now()
+	secret := &pbauth.Secret{
+		Key:                 key,
+		CreatedTimestamp:    now.Unix(),
+		ExpirationTimestamp: now.Add(*expires).Unix(),
+	}
+	if c.jsonOut {
+		return c.printJSON(secret, exitOK)
+	}
+	fmt.Fprintf(c.stdout, "key:      %s\n", secret.GetKey())
+	fmt.Fprintf(c.stdout, "created:  %s\n", formatTimestamp(secret.GetCreatedTimestamp()))
+	fmt.Fprintf(c.stdout, "expires:  %s\n", formatTimestamp(secret.GetExpirationTimestamp()))
+	return exitOK
+}
+
+// loadSecret reads the secret from the key, valid from now for an hour, or from the secret file.
+func (c *command) loadSecret(key string, secretFile string) (*pbauth.Secret, error) {
+	if key != "" && secretFile != "" {
+		return nil, errors.New("-key and -secret-file are exclusive")
+	}
+	if secretFile != "" {
+		data, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret := &pbauth.Secret{}
+		if err := json.Unmarshal(data, secret); err != nil {
+			return nil, fmt.Errorf("invalid secret file: %v", err)
+		}
+		return secret, nil
+	}
+	if key != "" {
+		now := c.clock.Now()
+		return &pbauth.Secret{
+			Key:                 key,
+			CreatedTimestamp:    now.Unix(),
+			ExpirationTimestamp: now.Add(time.Hour).Unix(),
+		}, nil
+	}
+	return nil, errMissingSecret
+}
+
+// usageError prints the error with the usage of the subcommand.
+func (c *command) usageError(fs *flag.FlagSet, err error) int {
+	fmt.Fprintf(c.stderr, "%s: %v\n", fs.Name(), err)
+	fs.Usage()
+	return exitUsage
+}
+
+// tokenArg returns the single positional token argument.
+func tokenArg(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 {
+		return "", errMissingToken
+	}
+	return strings.TrimSpace(fs.Arg(0)), nil
+}
+
+// parseTokenType parses a token type name such as "JWT", case insensitively.
+func parseTokenType(name string) (auth.TokenType, bool) {
+	for tokenType, str := range auth.TokenTypeStringMap {
+		if tokenType != auth.NoType && strings.EqualFold(str, name) {
+			return tokenType, true
+		}
+	}
+	return auth.NoType, false
+}
+
+// parsePrincipalType parses a principal type name such as "SERVICE", case insensitively.
+func parsePrincipalType(name string) (auth.PrincipalType, bool) {
+	for principalType, str := range auth.PrincipalTypeStringMap {
+		if strings.EqualFold(str, name) {
+			return principalType, true
+		}
+	}
+	return auth.UserPrincipal, false
+}
+
+// tokenTypeNames lists the names of the supported token types in order.
+func tokenTypeNames() []string {
+	var names []string
+	for tokenType := auth.Jwt; ; tokenType++ {
+		name, ok := auth.TokenTypeStringMap[tokenType]
+		if !ok {
+			return names
+		}
+		names = append(names, name)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	pbauth "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testUUID = "01d3x3wm2nnrdfzp0tka2vw9dx"
+)
+
+// execute runs the command and returns its exit code, stdout and stderr.
+func execute(clock auth.Clock, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr, clock)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunUsage(t *testing.T) {
+	clock := auth.NewFakeClock(time.Now())
+	cases := []struct {
+		desc    string
+		args    []string
+		expCode int
+	}{
+		{"test for no command", nil, exitUsage},
+		{"test for unknown command", []string{"inspect"}, exitUsage},
+		{"test for help", []string{"help"}, exitOK},
+		{"test for missing token", []string{"decode"}, exitUsage},
+		{"test for extra arguments", []string{"decode", "a", "b"}, exitUsage},
+		{"test for unknown flag", []string{"decode", "-yaml", "a.b.c"}, exitUsage},
+		{"test for missing secret", []string{"verify", "a.b.c"}, exitUsage},
+		{"test for exclusive secrets", []string{"verify", "-key", "a", "-secret-file", "b", "a.b.c"}, exitUsage},
+		{"test for missing secret file", []string{"verify", "-secret-file", "missing.json", "a.b.c"}, exitUsage},
+		{"test for unknown type", []string{"verify", "-key", "a", "-type", "JXT", "a.b.c"}, exitUsage},
+		{"test for unknown principal", []string{"verify", "-key", "a", "-principal", "ROBOT", "a.b.c"}, exitUsage},
+		{"test for unknown permission", []string{"mint", "-key", "a", "-permission", "ROOT"}, exitUsage},
+		{"test for no type", []string{"mint", "-key", "a", "-type", "NO_TYPE"}, exitUsage},
+	}
+	for _, c := range cases {
+		code, _, _ := execute(clock, c.args...)
+		assert.Equal(t, c.expCode, code, c.desc)
+	}
+}
+
+func TestRunSecret(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := auth.NewFakeClock(now)
+
+	code, stdout, _ := execute(clock, "secret", "-json", "-expires", "24h")
+	assert.Equal(t, exitOK, code)
+	secret := &pbauth.Secret{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), secret))
+	assert.NotEmpty(t, secret.GetKey())
+	assert.Equal(t, now.Unix(), secret.GetCreatedTimestamp())
+	assert.Equal(t, now.Add(24*time.Hour).Unix(), secret.GetExpirationTimestamp())
+
+	code, stdout, _ = execute(clock, "secret")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "created:  2019-06-01T12:00:00Z")
+
+	code, stdout, _ = execute(clock, "secret", "-json", "-size", "0")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, consts.ErrInvalidTokenSize.Error())
+}
+
+func TestRunMintDecodeVerify(t *testing.T) {
+	clock := auth.NewFakeClock(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC))
+	dir, err := ioutil.TempDir("", "hwsc-token")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "secret.json")
+	code, stdout, _ := execute(clock, "secret", "-json")
+	assert.Equal(t, exitOK, code)
+	assert.Nil(t, ioutil.WriteFile(secretPath, []byte(stdout), 0600))
+	otherPath := filepath.Join(dir, "other.json")
+	code, stdout, _ = execute(clock, "secret", "-json")
+	assert.Equal(t, exitOK, code)
+	assert.Nil(t, ioutil.WriteFile(otherPath, []byte(stdout), 0600))
+
+	desc := "test for minting with an invalid uuid"
+	code, stdout, _ = execute(clock, "mint", "-json", "-uuid", "bad", "-secret-file", secretPath)
+	assert.Equal(t, exitFailure, code, desc)
+	assert.Contains(t, stdout, consts.ErrInvalidUUID.Error(), desc)
+
+	desc = "test for minting"
+	code, stdout, _ = execute(clock, "mint", "-json", "-uuid", testUUID, "-permission", "admin",
+		"-secret-file", secretPath)
+	assert.Equal(t, exitOK, code, desc)
+	minted := &report{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), minted), desc)
+	assert.NotEmpty(t, minted.Token, desc)
+	assert.Equal(t, "HS512", minted.Algorithm, desc)
+
+	desc = "test for decoding"
+	code, stdout, _ = execute(clock, "decode", "-json", minted.Token)
+	assert.Equal(t, exitOK, code, desc)
+	decoded := &report{}
+	assert.Nil(t, json.Unmarshal([]byte(stdout), decoded), desc)
+	assert.Equal(t, "JWT", decoded.Type, desc)
+	assert.Equal(t, "ADMIN", decoded.Permission, desc)
+	assert.Equal(t, testUUID, decoded.Body.UUID, desc)
+	assert.Nil(t, decoded.Valid, "test for decoding not verifying")
+
+	desc = "test for decoding as text"
+	code, stdout, _ = execute(clock, "decode", minted.Token)
+	assert.Equal(t, exitOK, code, desc)
+	assert.Contains(t, stdout, "uuid:       "+testUUID, desc)
+	assert.Contains(t, stdout, "permission: ADMIN", desc)
+
+	desc = "test for decoding an incomplete token"
+	code, stdout, _ = execute(clock, "decode", "a.b")
+	assert.Equal(t, exitFailure, code, desc)
+	assert.Contains(t, stdout, explanations[consts.ErrIncompleteToken], desc)
+
+	cases := []struct {
+		desc    string
+		args    []string
+		expCode int
+		expErr  error
+	}{
+		{"test for valid token", []string{"-secret-file", secretPath, "-permission", "ADMIN"}, exitOK, nil},
+		{"test for other secret", []string{"-secret-file", otherPath}, exitFailure, consts.ErrInvalidSignature},
+		{"test for other type", []string{"-secret-file", secretPath, "-type", "jrt"}, exitFailure,
+			consts.ErrInvalidRequiredTokenType},
+		{"test for other principal", []string{"-secret-file", secretPath, "-principal", "service"}, exitFailure,
+			consts.ErrPrincipalNotAllowed},
+		{"test for expired token", []string{"-secret-file", secretPath, "-leeway", "0s"}, exitFailure,
+			consts.ErrExpiredBody},
+	}
+	for i, c := range cases {
+		if i == len(cases)-1 {
+			clock.Advance(2 * time.Hour)
+		}
+		code, stdout, _ := execute(clock, append(append([]string{"verify", "-json"}, c.args...), minted.Token)...)
+		assert.Equal(t, c.expCode, code, c.desc)
+		verified := &report{}
+		assert.Nil(t, json.Unmarshal([]byte(stdout), verified), c.desc)
+		assert.NotNil(t, verified.Valid, c.desc)
+		if c.expErr == nil {
+			assert.True(t, *verified.Valid, c.desc)
+			continue
+		}
+		assert.False(t, *verified.Valid, c.desc)
+		assert.Equal(t, c.expErr.Error(), verified.Error, c.desc)
+		assert.NotEmpty(t, verified.Explanation, c.desc)
+	}
+}
+
+func TestExplanations(t *testing.T) {
+	// the errors a Verifier returns for a token, a secret or a presentation
+	verifierErrors := []error{
+		consts.ErrNilIdentification, consts.ErrEmptyToken, consts.ErrIncompleteToken, consts.ErrTokenTooLarge,
+		consts.ErrInvalidEncodedHeader, consts.ErrInvalidEncodedBody, consts.ErrDuplicateJSONKey,
+		consts.ErrUnknownHeaderField, consts.ErrUnknownTokenType, consts.ErrUnknownAlgorithm,
+		consts.ErrUnknownPermission, consts.ErrNilSecret, consts.ErrEmptySecret, consts.ErrExpiredSecret,
+		consts.ErrInvalidSecretCreateTimestamp, consts.ErrInvalidSignature, consts.ErrInvalidEncryptedToken,
+		consts.ErrDecryptionFailed, consts.ErrTokenNotEncrypted, consts.ErrExpiredBody, consts.ErrInvalidTimeStamp,
+		consts.ErrInvalidIssuedAt, consts.ErrInvalidNotBefore, consts.ErrTokenNotYetValid, consts.ErrTokenTooOld,
+		consts.ErrInvalidUUID, consts.ErrInvalidServiceName, consts.ErrInvalidIssuer, consts.ErrInvalidAudience,
+		consts.ErrIssuerMismatch, consts.ErrAudienceMismatch, consts.ErrInvalidRole, consts.ErrInvalidScope,
+		consts.ErrInsufficientScope, consts.ErrInvalidAuthMethod, consts.ErrMFARequired, consts.ErrInvalidPrincipal,
+		consts.ErrUnknownPrincipal,
+		consts.ErrPrincipalNotAllowed, consts.ErrServiceNotAllowed, consts.ErrInvalidActor,
+		consts.ErrImpersonationNotAllowed, consts.ErrInvalidConfirmation, consts.ErrMissingProofOfPossession,
+		consts.ErrInvalidProof, consts.ErrProofMismatch, consts.ErrProofReplayed, consts.ErrUnsupportedKey,
+		consts.ErrInvalidTokenBinding, consts.ErrTokenBindingMismatch, consts.ErrMissingSession,
+		consts.ErrUnknownSession, consts.ErrRevokedSession, consts.ErrLockedOut, consts.ErrInvalidPermission,
+		consts.ErrInvalidRequiredTokenType,
+	}
+	for _, err := range verifierErrors {
+		assert.NotEmpty(t, explanations[err], "test for explanation of "+err.Error())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+	"time"
+)
+
+var (
+	// explanations tell how to fix the most common reasons a token is rejected
+	explanations = map[error]string{
+		consts.ErrIncompleteToken:              "a token has a header, a body and a signature separated by dots, check that it was not truncated",
+		consts.ErrTokenTooLarge:                "the token exceeds the maximum token size",
+		consts.ErrInvalidEncodedHeader:         "the header is not valid base64, check that the token was not altered",
+		consts.ErrInvalidEncodedBody:           "the body is not valid base64, check that the token was not altered",
+		consts.ErrDuplicateJSONKey:             "the header or body repeats a key, the token was forged or altered",
+		consts.ErrUnknownHeaderField:           "the header has a field hwsc does not sign, the token was forged or altered",
+		consts.ErrExpiredBody:                  "the token has expired, sign in again or exchange the refresh token",
+		consts.ErrTokenNotYetValid:             "the token is not valid yet, check its not before timestamp and the clocks of the services",
+		consts.ErrTokenTooOld:                  "the token was issued longer ago than the service allows, issue a new one",
+		consts.ErrInvalidIssuedAt:              "the token is issued in the future or lacks an issued at timestamp required by the service",
+		consts.ErrExpiredSecret:                "the secret that signed the token has expired, issue a new token with the active secret",
+		consts.ErrInvalidSecretCreateTimestamp: "the secret is created in the future, check the clocks of the services",
+		consts.ErrInvalidSignature:             "the signature does not match, the token was signed with another secret or altered",
+		consts.ErrInvalidPermission:            "the permission of the token is below the required one, or an ADMIN token is not signed with HS512",
+		consts.ErrInvalidRequiredTokenType:     "the token type differs from the one required, ie: a refresh or email token used as an access token",
+		consts.ErrIssuerMismatch:               "the token was minted by another issuer than the service trusts",
+		consts.ErrAudienceMismatch:             "the token is not meant for this service",
+		consts.ErrInvalidUUID:                  "the uuid of the token is not a valid lowercase ulid",
+		consts.ErrPrincipalNotAllowed:          "the service only accepts user tokens, or only service tokens",
+		consts.ErrImpersonationNotAllowed:      "the token impersonates a user, and the actor or operation is not allowed to",
+		consts.ErrUnknownPermission:            "the permission of the token is unknown",
+		consts.ErrUnknownTokenType:             "the token type is unknown",
+		consts.ErrUnknownAlgorithm:             "the signing algorithm is unknown",
+		consts.ErrMissingProofOfPossession:     "the token is bound to a key or certificate of the client, only the client can present it",
+		consts.ErrNilIdentification:            "no token was given to verify",
+		consts.ErrEmptyToken:                   "the token is empty, check that it was passed",
+		consts.ErrInvalidTokenSize:             "the secret key needs a positive number of bytes",
+		consts.ErrNilSecret:                    "no secret was given to verify the token with",
+		consts.ErrEmptySecret:                  "the secret has no key, check the secret file",
+		consts.ErrInvalidTimeStamp:             "the token lacks an expiration timestamp, the token was forged or altered",
+		consts.ErrInvalidNotBefore:             "the not before timestamp of the token is after its expiration",
+		consts.ErrInvalidIssuer:                "the issuer claim of the token is blank, the token was forged or altered",
+		consts.ErrInvalidAudience:              "an audience of the token is blank, the token was forged or altered",
+		consts.ErrInvalidRole:                  "a role of the token is blank or repeated, the token was forged or altered",
+		consts.ErrInvalidScope:                 "a scope of the token is not a valid resource:action, the token was forged or altered",
+		consts.ErrInsufficientScope:            "the token lacks a scope or role the service requires, issue a token granting it",
+		consts.ErrInvalidAuthMethod:            "an authentication method of the token is unknown or repeated, the token was forged or altered",
+		consts.ErrMFARequired:                  "the service requires a second factor, sign in again with a one-time code",
+		consts.ErrInvalidPrincipal:             "the token names both a user and a service, or neither, the token was forged or altered",
+		consts.ErrInvalidServiceName:           "the service name of the token is not valid, the token was forged or altered",
+		consts.ErrUnknownPrincipal:             "the principal type of the token is unknown, the token was forged or altered",
+		consts.ErrServiceNotAllowed:            "the service token is not from a service this service accepts",
+		consts.ErrInvalidActor:                 "the actor of the token is not a valid user or service, or acts through too long a chain",
+		consts.ErrInvalidConfirmation:          "the key binding of the token is malformed, the token was forged or altered",
+		consts.ErrInvalidProof:                 "the proof of possession is malformed, expired, or made for another request",
+		consts.ErrProofMismatch:                "the proof of possession is signed with another key than the token is bound to",
+		consts.ErrProofReplayed:                "the proof of possession was already used, make a new proof for every request",
+		consts.ErrUnsupportedKey:               "the key of the proof of possession is of an unsupported type",
+		consts.ErrInvalidTokenBinding:          "the value the token is bound to is blank, ie: an email address",
+		consts.ErrTokenBindingMismatch:         "the token is bound to another value than the one it is used with, ie: another email address",
+		consts.ErrMissingSession:               "the service tracks sessions and the token was issued without one, sign in again",
+		consts.ErrUnknownSession:               "the session of the token is unknown to the service, sign in again",
+		consts.ErrRevokedSession:               "the session of the token was revoked, ie: by a logout, sign in again",
+		consts.ErrLockedOut:                    "too many invalid tokens were presented by the client, wait for the lockout to end",
+		consts.ErrInvalidEncryptedToken:        "the encrypted token is malformed, check that it was not truncated or altered",
+		consts.ErrDecryptionFailed:             "the token cannot be decrypted, it was encrypted with another key or altered",
+		consts.ErrTokenNotEncrypted:            "the service only accepts encrypted tokens",
+	}
+)
+
+// report is the output of a subcommand.
+// Fields are omitted when they do not apply to the subcommand.
+type report struct {
+	Token       string       `json:"token,omitempty"`
+	Valid       *bool        `json:"valid,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Explanation string       `json:"explanation,omitempty"`
+	Header      *auth.Header `json:"header,omitempty"`
+	Body        *auth.Body   `json:"body,omitempty"`
+	Type        string       `json:"type,omitempty"`
+	Algorithm   string       `json:"algorithm,omitempty"`
+	Permission  string       `json:"permission,omitempty"`
+	Expires     string       `json:"expires,omitempty"`
+	IssuedAt    string       `json:"issued_at,omitempty"`
+	NotBefore   string       `json:"not_before,omitempty"`
+	Actor       string       `json:"actor,omitempty"`
+}
+
+// newReport describes the header and body, which can be nil if the token could not be decoded.
+func (c *command) newReport(header *auth.Header, body *auth.Body) *report {
+	r := &report{
+		Header: header,
+		Body:   body,
+	}
+	if header != nil {
+		r.Type = auth.TokenTypeStringMap[header.TokenTyp]
+		r.Algorithm = auth.AlgorithmStringMap[header.Alg]
+	}
+	if body != nil {
+		r.Permission = auth.PermissionStringMap[body.Permission]
+		r.Expires = formatTimestamp(body.ExpirationTimestamp)
+		r.IssuedAt = formatTimestamp(body.IssuedAt)
+		r.NotBefore = formatTimestamp(body.NotBefore)
+		if body.Actor != nil {
+			r.Actor = body.Actor.String()
+		}
+	}
+	return r
+}
+
+// setError records the error and its explanation.
+func (r *report) setError(err error) {
+	valid := false
+	r.Valid = &valid
+	r.Error = err.Error()
+	r.Explanation = explanations[err]
+}
+
+// print writes the report as JSON or human readable text, and returns code.
+func (c *command) print(r *report, code int) int {
+	if c.jsonOut {
+		return c.printJSON(r, code)
+	}
+	lines := []struct {
+		name  string
+		value string
+	}{
+		{"token", r.Token},
+		{"type", r.Type},
+		{"algorithm", r.Algorithm},
+	}
+	if r.Body != nil {
+		lines = append(lines, []struct {
+			name  string
+			value string
+		}{
+			{"uuid", r.Body.UUID},
+			{"service", r.Body.Service},
+			{"acted by", r.Actor},
+			{"permission", r.Permission},
+			{"issued at", r.IssuedAt},
+			{"not before", r.NotBefore},
+			{"expires", r.Expires},
+			{"issuer", r.Body.Issuer},
+			{"audience", strings.Join(r.Body.Audience, ", ")},
+			{"id", r.Body.ID},
+			{"family id", r.Body.FamilyID},
+			{"session", r.Body.Session},
+			{"amr", strings.Join(r.Body.AuthMethods, ", ")},
+			{"roles", strings.Join(r.Body.Roles, ", ")},
+			{"scopes", strings.Join(r.Body.Scopes, ", ")},
+		}...)
+	}
+	for _, line := range lines {
+		if line.value != "" {
+			fmt.Fprintf(c.stdout, "%-11s %s\n", line.name+":", line.value)
+		}
+	}
+	if r.Valid != nil && *r.Valid {
+		fmt.Fprintln(c.stdout, "valid:      yes")
+	}
+	if r.Error != "" {
+		fmt.Fprintf(c.stdout, "error:      %s\n", r.Error)
+	}
+	if r.Explanation != "" {
+		fmt.Fprintf(c.stdout, "why:        %s\n", r.Explanation)
+	}
+	return code
+}
+
+// printJSON writes v as indented JSON, and returns code.
+func (c *command) printJSON(v interface{}, code int) int {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitFailure
+	}
+	return code
+}
+
+// formatTimestamp formats a unix timestamp in RFC 3339, or returns an empty string if zero.
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
@@ -29,4 +29,117 @@ var (
 	ErrInvalidTokenSize             = errors.New("invalid token size")
 	ErrInvalidTimeStamp             = errors.New("zero timestamp")
 	ErrInvalidNumberOfDays          = errors.New("invalid number of days to add to expiration timestamp")
+	ErrNilRefreshStore              = errors.New("nil refresh store")
+	ErrInvalidRefreshToken          = errors.New("invalid refresh token")
+	ErrUnknownTokenFamily           = errors.New("unknown token family")
+	ErrDuplicateTokenFamily         = errors.New("duplicate token family")
+	ErrRevokedTokenFamily           = errors.New("revoked token family")
+	ErrRefreshTokenReused           = errors.New("refresh token reused, token family revoked")
+	ErrUnregisteredOperation        = errors.New("operation has no registered requirement")
+	ErrMalformedAuthorization       = errors.New("malformed authorization header")
+	ErrInvalidRole                  = errors.New("invalid role")
+	ErrInvalidScope                 = errors.New("invalid scope")
+	ErrInsufficientScope            = errors.New("insufficient scope")
+	ErrNilPolicyConfig              = errors.New("nil policy config")
+	ErrNotResourceOwner             = errors.New("not the resource owner")
+	ErrNilOwnerResolver             = errors.New("nil owner resolver")
+	ErrTokenTooLarge                = errors.New("token exceeds maximum size")
+	ErrDuplicateJSONKey             = errors.New("duplicate json key")
+	ErrUnknownHeaderField           = errors.New("unknown header field")
+	ErrNilClock                     = errors.New("nil clock")
+	ErrInvalidIssuedAt              = errors.New("invalid issued at timestamp")
+	ErrInvalidNotBefore             = errors.New("invalid not before timestamp")
+	ErrTokenNotYetValid             = errors.New("token not yet valid")
+	ErrTokenTooOld                  = errors.New("token exceeds maximum age")
+	ErrInvalidIssuer                = errors.New("invalid issuer")
+	ErrInvalidAudience              = errors.New("invalid audience")
+	ErrIssuerMismatch               = errors.New("token issuer mismatch")
+	ErrAudienceMismatch             = errors.New("token audience mismatch")
+	ErrNilLifetimeConfig            = errors.New("nil lifetime config")
+	ErrNilLifetimePolicy            = errors.New("nil lifetime policy")
+	ErrInvalidLifetime              = errors.New("invalid token lifetime")
+	ErrUnknownAlignment             = errors.New("unknown lifetime alignment")
+	ErrInvalidTimeZone              = errors.New("invalid time zone")
+	ErrNilVerificationStore         = errors.New("nil verification store")
+	ErrInvalidVerificationToken     = errors.New("invalid verification token")
+	ErrUnknownVerificationToken     = errors.New("unknown verification token")
+	ErrVerificationTokenSuperseded  = errors.New("verification token superseded by a newer one")
+	ErrVerificationTokenConsumed    = errors.New("verification token already used")
+	ErrInvalidTokenBinding          = errors.New("invalid token binding")
+	ErrTokenBindingMismatch         = errors.New("token binding mismatch")
+	ErrEmptyPassword                = errors.New("empty password")
+	ErrPasswordMismatch             = errors.New("password does not match")
+	ErrInvalidPasswordHash          = errors.New("invalid password hash")
+	ErrUnknownHashAlgorithm         = errors.New("unknown password hashing algorithm")
+	ErrInvalidHashParams            = errors.New("invalid password hashing parameters")
+	ErrNilPasswordPolicyConfig      = errors.New("nil password policy config")
+	ErrInvalidPasswordPolicy        = errors.New("invalid password policy")
+	ErrInvalidPasswordEncoding      = errors.New("password is not valid utf-8")
+	ErrPasswordTooShort             = errors.New("password is too short")
+	ErrPasswordTooLong              = errors.New("password is too long")
+	ErrPasswordMissingUpper         = errors.New("password requires an uppercase letter")
+	ErrPasswordMissingLower         = errors.New("password requires a lowercase letter")
+	ErrPasswordMissingDigit         = errors.New("password requires a digit")
+	ErrPasswordMissingSymbol        = errors.New("password requires a symbol")
+	ErrPasswordBanned               = errors.New("password is banned")
+	ErrInvalidEncryptionKey         = errors.New("invalid encryption key")
+	ErrUnknownKeyAlgorithm          = errors.New("unknown key algorithm")
+	ErrKeyAlgorithmMismatch         = errors.New("key algorithm mismatch")
+	ErrInvalidEncryptedToken        = errors.New("invalid encrypted token")
+	ErrDecryptionFailed             = errors.New("token decryption failed")
+	ErrTokenNotEncrypted            = errors.New("token is not encrypted")
+	ErrNoActiveSecret               = errors.New("no active secret")
+	ErrDuplicateSecret              = errors.New("duplicate secret")
+	ErrInvalidSecretLifetime        = errors.New("invalid secret lifetime")
+	ErrInvalidMasterKey             = errors.New("invalid master key")
+	ErrCorruptSecretStore           = errors.New("secret store cannot be decrypted")
+	ErrInvalidConfirmation          = errors.New("invalid confirmation claim")
+	ErrMissingProofOfPossession     = errors.New("missing proof of possession")
+	ErrInvalidProof                 = errors.New("invalid proof of possession")
+	ErrProofMismatch                = errors.New("proof of possession does not match the token binding")
+	ErrUnsupportedKey               = errors.New("unsupported key")
+	ErrInvalidServiceName           = errors.New("invalid service name")
+	ErrInvalidPrincipal             = errors.New("invalid principal")
+	ErrUnknownPrincipal             = errors.New("unknown principal type")
+	ErrPrincipalNotAllowed          = errors.New("principal type not allowed")
+	ErrServiceNotAllowed            = errors.New("service not allowed")
+	ErrNilClientStore               = errors.New("nil client store")
+	ErrUnknownClient                = errors.New("unknown client")
+	ErrDuplicateClient              = errors.New("duplicate client")
+	ErrInvalidClientCredentials     = errors.New("invalid client credentials")
+	ErrInvalidActor                 = errors.New("invalid actor")
+	ErrNilImpersonationConfig       = errors.New("nil impersonation config")
+	ErrNilImpersonationPolicy       = errors.New("nil impersonation policy")
+	ErrInvalidImpersonationPolicy   = errors.New("invalid impersonation policy")
+	ErrImpersonationNotAllowed      = errors.New("impersonation not allowed")
+	ErrNilFailureStore              = errors.New("nil failure store")
+	ErrInvalidFailureLimit          = errors.New("invalid failure limit")
+	ErrInvalidLockout               = errors.New("invalid lockout duration")
+	ErrLockedOut                    = errors.New("too many failed verifications, locked out")
+	ErrNilSessionStore              = errors.New("nil session store")
+	ErrUnknownSession               = errors.New("unknown session")
+	ErrDuplicateSession             = errors.New("duplicate session")
+	ErrMissingSession               = errors.New("token has no session")
+	ErrRevokedSession               = errors.New("revoked session")
+	ErrInvalidTOTPParams            = errors.New("invalid totp parameters")
+	ErrNilReplayStore               = errors.New("nil totp replay store")
+	ErrInvalidTOTPSecret            = errors.New("invalid totp secret")
+	ErrInvalidTOTPCode              = errors.New("invalid totp code")
+	ErrTOTPReplayed                 = errors.New("totp code already used")
+	ErrInvalidRecoveryCodeCount     = errors.New("invalid number of recovery codes")
+	ErrInvalidRecoveryCode          = errors.New("invalid recovery code")
+	ErrInvalidAuthMethod            = errors.New("invalid authentication method")
+	ErrMFARequired                  = errors.New("multi-factor authentication required")
+	ErrNilSubjectMapper             = errors.New("nil subject mapper")
+	ErrOIDCDiscovery                = errors.New("cannot load openid provider metadata or keys")
+	ErrInvalidIDToken               = errors.New("invalid id token")
+	ErrInvalidNonce                 = errors.New("invalid id token nonce")
+	ErrUnknownSigningKey            = errors.New("unknown signing key")
+	ErrInvalidSignedURL             = errors.New("invalid signed url")
+	ErrExpiredURL                   = errors.New("signed url expired")
+	ErrUnknownURLKey                = errors.New("unknown url signing key")
+	ErrInvalidRequirement           = errors.New("requirement must be public or name a token type")
+	ErrProofReplayed                = errors.New("proof of possession already used")
+	ErrNilProofReplayStore          = errors.New("nil proof replay store")
+	ErrInvalidExternalURL           = errors.New("invalid external url")
 )
@@ -14,6 +14,8 @@ const (
 	LogTagError = "[ERROR]"
 	// LogTagFatal failure tag
 	LogTagFatal = "[FATAL]"
+	// LogTagAudit security audit tag
+	LogTagAudit = "[AUDIT]"
 )
 
 // RequestService logs service request
@@ -31,6 +33,11 @@ func Error(args ...string) {
 	log.Printf("%s %s", LogTagError, strings.Join(args, " "))
 }
 
+// Audit provides security audit logging, ie: who really performed an operation
+func Audit(args ...string) {
+	log.Printf("%s %s", LogTagAudit, strings.Join(args, " "))
+}
+
 // Fatal provides failure logging and shutting down application
 func Fatal(args ...string) {
 	log.Fatalf("%s %s", LogTagFatal, strings.Join(args, " "))
@@ -0,0 +1,248 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Algorithm is the password hashing algorithm.
+type Algorithm int32
+
+const (
+	// NoAlg default zero value
+	NoAlg Algorithm = iota
+	// Argon2id is the recommended algorithm
+	Argon2id
+	// Bcrypt for compatibility with existing hashes
+	Bcrypt
+)
+
+const (
+	argon2idID     = "argon2id"
+	bcryptMaxBytes = 72
+	// argon2idMaxMemory, argon2idMaxIterations and argon2idMaxParallelism bound the params of argon2id hashes,
+	// so that a corrupted or forged hash cannot make every login allocate gigabytes
+	argon2idMaxMemory      = 1024 * 1024
+	argon2idMaxIterations  = 16
+	argon2idMaxParallelism = 16
+)
+
+var (
+	// DefaultParams hashes with argon2id using 64 MiB of memory, one pass and 4 threads.
+	DefaultParams = Params{
+		Algorithm:   Argon2id,
+		Memory:      64 * 1024,
+		Iterations:  1,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+
+	// DefaultHasher hashes with DefaultParams.
+	DefaultHasher = &Hasher{params: DefaultParams}
+)
+
+// Params are the parameters of the hashing algorithm.
+// Memory is in KiB, and Memory, Iterations, Parallelism, SaltLength and KeyLength are only used by Argon2id.
+// Argon2id is limited to 1 GiB of memory, 16 iterations and 16 threads.
+// Cost is only used by Bcrypt.
+type Params struct {
+	Algorithm   Algorithm
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+	Cost        int
+}
+
+// Hasher hashes passwords into PHC strings, ie: "$argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>".
+// Bcrypt hashes use the modular crypt format, ie: "$2a$10$<salt and hash>".
+type Hasher struct {
+	params Params
+}
+
+// NewHasher makes a hasher with the params.
+// Returns an error if the params are not valid for the algorithm.
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Algorithm {
+	case Argon2id:
+		if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 ||
+			params.SaltLength < 8 || params.KeyLength < 16 || !isBoundedArgon2id(params) {
+			return nil, consts.ErrInvalidHashParams
+		}
+	case Bcrypt:
+		if params.Cost < bcrypt.MinCost || params.Cost > bcrypt.MaxCost {
+			return nil, consts.ErrInvalidHashParams
+		}
+	default:
+		return nil, consts.ErrUnknownHashAlgorithm
+	}
+	return &Hasher{
+		params: params,
+	}, nil
+}
+
+// Hash hashes the password with a random salt.
+// Returns the encoded hash, or an error if hashing fails.
+func (h *Hasher) Hash(password string) (string, error) {
+	if password == "" {
+		return "", consts.ErrEmptyPassword
+	}
+	switch h.params.Algorithm {
+	case Argon2id:
+		salt := make([]byte, h.params.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory,
+			h.params.Parallelism, h.params.KeyLength)
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2idID, argon2.Version,
+			h.params.Memory, h.params.Iterations, h.params.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case Bcrypt:
+		if len(password) > bcryptMaxBytes {
+			return "", consts.ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.Cost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+	return "", consts.ErrUnknownHashAlgorithm
+}
+
+// Verify checks the password against the encoded hash in constant time.
+// Hashes of any supported algorithm are verified, regardless of the params of the hasher.
+// Returns consts.ErrPasswordMismatch if the password does not match,
+// or consts.ErrPasswordTooLong if bcrypt would truncate it.
+func (h *Hasher) Verify(password string, encoded string) error {
+	decoded, err := decode(encoded)
+	if err != nil {
+		return err
+	}
+	switch decoded.params.Algorithm {
+	case Argon2id:
+		key := argon2.IDKey([]byte(password), decoded.salt, decoded.params.Iterations, decoded.params.Memory,
+			decoded.params.Parallelism, decoded.params.KeyLength)
+		if subtle.ConstantTimeCompare(key, decoded.key) != 1 {
+			return consts.ErrPasswordMismatch
+		}
+		return nil
+	case Bcrypt:
+		// bcrypt ignores the bytes past the limit, so longer passwords would match on their prefix
+		if len(password) > bcryptMaxBytes {
+			return consts.ErrPasswordTooLong
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return consts.ErrPasswordMismatch
+		}
+		return err
+	}
+	return consts.ErrUnknownHashAlgorithm
+}
+
+// NeedsRehash checks if the encoded hash was made with another algorithm or other params than the hasher,
+// ie: to rehash the password on the next successful login.
+// Returns an error if the encoded hash is not valid.
+func (h *Hasher) NeedsRehash(encoded string) (bool, error) {
+	decoded, err := decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	if decoded.params.Algorithm != h.params.Algorithm {
+		return true, nil
+	}
+	switch h.params.Algorithm {
+	case Argon2id:
+		return decoded.params.Memory != h.params.Memory ||
+			decoded.params.Iterations != h.params.Iterations ||
+			decoded.params.Parallelism != h.params.Parallelism ||
+			decoded.params.SaltLength != h.params.SaltLength ||
+			decoded.params.KeyLength != h.params.KeyLength, nil
+	case Bcrypt:
+		return decoded.params.Cost != h.params.Cost, nil
+	}
+	return false, consts.ErrUnknownHashAlgorithm
+}
+
+// decodedHash is the algorithm, params, salt and key of an encoded hash.
+type decodedHash struct {
+	params Params
+	salt   []byte
+	key    []byte
+}
+
+// decode parses an encoded hash.
+// Returns an error if the encoded hash is not valid.
+func decode(encoded string) (*decodedHash, error) {
+	if strings.HasPrefix(encoded, "$"+argon2idID+"$") {
+		return decodeArgon2id(encoded)
+	}
+	if strings.HasPrefix(encoded, "$2") {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return nil, consts.ErrInvalidPasswordHash
+		}
+		return &decodedHash{
+			params: Params{
+				Algorithm: Bcrypt,
+				Cost:      cost,
+			},
+		}, nil
+	}
+	return nil, consts.ErrUnknownHashAlgorithm
+}
+
+// decodeArgon2id parses an argon2id PHC string.
+func decodeArgon2id(encoded string) (*decodedHash, error) {
+	// "", "argon2id", "v=19", "m=65536,t=1,p=4", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	params := Params{
+		Algorithm: Argon2id,
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations,
+		&params.Parallelism); err != nil {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	if fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Iterations, params.Parallelism) != parts[3] ||
+		params.Iterations < 1 || params.Parallelism < 1 || !isBoundedArgon2id(params) {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.Strict().DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.Strict().DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, consts.ErrInvalidPasswordHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return &decodedHash{
+		params: params,
+		salt:   salt,
+		key:    key,
+	}, nil
+}
+
+// isBoundedArgon2id checks the memory, iterations and parallelism of the argon2id params against their limits.
+func isBoundedArgon2id(params Params) bool {
+	return params.Memory <= argon2idMaxMemory && params.Iterations <= argon2idMaxIterations &&
+		params.Parallelism <= argon2idMaxParallelism
+}
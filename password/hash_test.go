@@ -0,0 +1,194 @@
+package password
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var (
+	testArgon2idParams = Params{
+		Algorithm:   Argon2id,
+		Memory:      1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	testBcryptParams = Params{
+		Algorithm: Bcrypt,
+		Cost:      4,
+	}
+)
+
+func TestNewHasher(t *testing.T) {
+	cases := []struct {
+		desc     string
+		params   Params
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for no algorithm", Params{}, true, consts.ErrUnknownHashAlgorithm},
+		{"test for unknown algorithm", Params{Algorithm: Bcrypt + 1}, true, consts.ErrUnknownHashAlgorithm},
+		{"test for zero argon2id params", Params{Algorithm: Argon2id}, true, consts.ErrInvalidHashParams},
+		{"test for short salt",
+			Params{Algorithm: Argon2id, Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 4, KeyLength: 32},
+			true, consts.ErrInvalidHashParams,
+		},
+		{"test for too little memory",
+			Params{Algorithm: Argon2id, Memory: 16, Iterations: 1, Parallelism: 4, SaltLength: 16, KeyLength: 32},
+			true, consts.ErrInvalidHashParams,
+		},
+		{"test for too much memory",
+			Params{Algorithm: Argon2id, Memory: 2 * 1024 * 1024, Iterations: 1, Parallelism: 4, SaltLength: 16,
+				KeyLength: 32},
+			true, consts.ErrInvalidHashParams,
+		},
+		{"test for too many threads",
+			Params{Algorithm: Argon2id, Memory: 1024, Iterations: 1, Parallelism: 64, SaltLength: 16, KeyLength: 32},
+			true, consts.ErrInvalidHashParams,
+		},
+		{"test for low bcrypt cost", Params{Algorithm: Bcrypt, Cost: 3}, true, consts.ErrInvalidHashParams},
+		{"test for high bcrypt cost", Params{Algorithm: Bcrypt, Cost: 32}, true, consts.ErrInvalidHashParams},
+		{"test for default params", DefaultParams, false, nil},
+		{"test for argon2id", testArgon2idParams, false, nil},
+		{"test for bcrypt", testBcryptParams, false, nil},
+	}
+	for _, c := range cases {
+		h, err := NewHasher(c.params)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, h, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, h, c.desc)
+		}
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	argon2idHasher, err := NewHasher(testArgon2idParams)
+	assert.Nil(t, err)
+	bcryptHasher, err := NewHasher(testBcryptParams)
+	assert.Nil(t, err)
+	cases := []struct {
+		desc      string
+		hasher    *Hasher
+		password  string
+		expPrefix string
+	}{
+		{"test for argon2id", argon2idHasher, "correct horse battery staple", "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"test for argon2id unicode", argon2idHasher, "pässwörd 密码", "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"test for default hasher", DefaultHasher, "correct horse battery staple", "$argon2id$v=19$m=65536,t=1,p=4$"},
+		{"test for bcrypt", bcryptHasher, "correct horse battery staple", "$2a$04$"},
+	}
+	for _, c := range cases {
+		encoded, err := c.hasher.Hash(c.password)
+		assert.Nil(t, err, c.desc)
+		assert.True(t, strings.HasPrefix(encoded, c.expPrefix), c.desc)
+		assert.NotContains(t, encoded, c.password, c.desc)
+
+		other, err := c.hasher.Hash(c.password)
+		assert.Nil(t, err, c.desc)
+		assert.NotEqual(t, encoded, other, "test for random salt")
+
+		// any hasher verifies hashes of any algorithm
+		for _, h := range []*Hasher{argon2idHasher, bcryptHasher} {
+			assert.Nil(t, h.Verify(c.password, encoded), c.desc)
+			assert.EqualError(t, h.Verify(c.password+" ", encoded), consts.ErrPasswordMismatch.Error(), c.desc)
+			assert.EqualError(t, h.Verify("", encoded), consts.ErrPasswordMismatch.Error(), c.desc)
+		}
+	}
+
+	desc := "test for empty password"
+	_, err = argon2idHasher.Hash("")
+	assert.EqualError(t, err, consts.ErrEmptyPassword.Error(), desc)
+
+	desc = "test for password truncated by bcrypt"
+	_, err = bcryptHasher.Hash(strings.Repeat("a", 73))
+	assert.EqualError(t, err, consts.ErrPasswordTooLong.Error(), desc)
+	_, err = argon2idHasher.Hash(strings.Repeat("a", 73))
+	assert.Nil(t, err, desc)
+	encoded, err := bcryptHasher.Hash(strings.Repeat("a", 72))
+	assert.Nil(t, err, desc)
+	assert.Nil(t, bcryptHasher.Verify(strings.Repeat("a", 72), encoded), desc)
+	assert.EqualError(t, bcryptHasher.Verify(strings.Repeat("a", 73), encoded), consts.ErrPasswordTooLong.Error(), desc)
+}
+
+func TestVerifyInvalidHash(t *testing.T) {
+	cases := []struct {
+		desc    string
+		encoded string
+		expErr  error
+	}{
+		{"test for empty hash", "", consts.ErrUnknownHashAlgorithm},
+		{"test for plain text", "password", consts.ErrUnknownHashAlgorithm},
+		{"test for argon2i", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo", consts.ErrUnknownHashAlgorithm},
+		{"test for missing key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0", consts.ErrInvalidPasswordHash},
+		{"test for wrong version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for malformed params", "$argon2id$v=19$m=1024,t=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for trailing params", "$argon2id$v=19$m=1024,t=1,p=1,k=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for zero iterations", "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for too much memory", "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for too many iterations", "$argon2id$v=19$m=1024,t=4294967295,p=1$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for too many threads", "$argon2id$v=19$m=1024,t=1,p=255$c2FsdHNhbHRzYWx0$aGFzaGhhc2hoYXNo",
+			consts.ErrInvalidPasswordHash},
+		{"test for padded salt", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA==$aGFzaGhhc2hoYXNo", consts.ErrInvalidPasswordHash},
+		{"test for empty key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$", consts.ErrInvalidPasswordHash},
+		{"test for truncated bcrypt", "$2a$04$", consts.ErrInvalidPasswordHash},
+	}
+	for _, c := range cases {
+		assert.EqualError(t, DefaultHasher.Verify("password", c.encoded), c.expErr.Error(), c.desc)
+		_, err := DefaultHasher.NeedsRehash(c.encoded)
+		assert.EqualError(t, err, c.expErr.Error(), c.desc)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2idHasher, err := NewHasher(testArgon2idParams)
+	assert.Nil(t, err)
+	bcryptHasher, err := NewHasher(testBcryptParams)
+	assert.Nil(t, err)
+	strongerParams := testArgon2idParams
+	strongerParams.Iterations = 2
+	strongerHasher, err := NewHasher(strongerParams)
+	assert.Nil(t, err)
+	longerParams := testArgon2idParams
+	longerParams.KeyLength = 64
+	longerHasher, err := NewHasher(longerParams)
+	assert.Nil(t, err)
+	costlierHasher, err := NewHasher(Params{Algorithm: Bcrypt, Cost: 5})
+	assert.Nil(t, err)
+
+	argon2idHash, err := argon2idHasher.Hash("password")
+	assert.Nil(t, err)
+	bcryptHash, err := bcryptHasher.Hash("password")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc      string
+		hasher    *Hasher
+		encoded   string
+		expOutput bool
+	}{
+		{"test for same argon2id params", argon2idHasher, argon2idHash, false},
+		{"test for same bcrypt cost", bcryptHasher, bcryptHash, false},
+		{"test for bcrypt to argon2id", argon2idHasher, bcryptHash, true},
+		{"test for argon2id to bcrypt", bcryptHasher, argon2idHash, true},
+		{"test for more iterations", strongerHasher, argon2idHash, true},
+		{"test for longer key", longerHasher, argon2idHash, true},
+		{"test for higher bcrypt cost", costlierHasher, bcryptHash, true},
+	}
+	for _, c := range cases {
+		needsRehash, err := c.hasher.NeedsRehash(c.encoded)
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expOutput, needsRehash, c.desc)
+	}
+}
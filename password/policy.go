@@ -0,0 +1,139 @@
+package password
+
+import (
+	"bufio"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// DefaultPolicy requires at least 8 characters and no more than 64, without character classes.
+	DefaultPolicy = &Policy{
+		minLength: 8,
+		maxLength: 64,
+	}
+)
+
+// PolicyConfig describes the passwords users are allowed to choose.
+// Lengths are counted in characters, a MaxLength of zero means no maximum.
+// BannedPath is an optional file of banned passwords, one per line,
+// compared case insensitively. Blank lines and lines starting with "#" are skipped.
+type PolicyConfig struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	BannedPath    string
+}
+
+// Policy validates passwords chosen by users.
+// The banned passwords are read once, when the policy is made.
+type Policy struct {
+	minLength     int
+	maxLength     int
+	requireUpper  bool
+	requireLower  bool
+	requireDigit  bool
+	requireSymbol bool
+	banned        map[string]struct{}
+}
+
+// NewPolicy makes a policy from the config, reading the banned passwords file if any.
+// Returns an error if the lengths are not valid or the file cannot be read.
+func NewPolicy(config *PolicyConfig) (*Policy, error) {
+	if config == nil {
+		return nil, consts.ErrNilPasswordPolicyConfig
+	}
+	if config.MinLength < 1 || config.MaxLength < 0 ||
+		(config.MaxLength > 0 && config.MaxLength < config.MinLength) {
+		return nil, consts.ErrInvalidPasswordPolicy
+	}
+	p := &Policy{
+		minLength:     config.MinLength,
+		maxLength:     config.MaxLength,
+		requireUpper:  config.RequireUpper,
+		requireLower:  config.RequireLower,
+		requireDigit:  config.RequireDigit,
+		requireSymbol: config.RequireSymbol,
+	}
+	if config.BannedPath != "" {
+		banned, err := loadBanned(config.BannedPath)
+		if err != nil {
+			return nil, err
+		}
+		p.banned = banned
+	}
+	return p, nil
+}
+
+// loadBanned reads the banned passwords file at path.
+func loadBanned(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	banned := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return banned, nil
+}
+
+// Validate checks the password against the policy.
+// Returns the first error encountered.
+func (p *Policy) Validate(password string) error {
+	if !utf8.ValidString(password) {
+		return consts.ErrInvalidPasswordEncoding
+	}
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		return consts.ErrPasswordTooShort
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		return consts.ErrPasswordTooLong
+	}
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.requireUpper && !hasUpper {
+		return consts.ErrPasswordMissingUpper
+	}
+	if p.requireLower && !hasLower {
+		return consts.ErrPasswordMissingLower
+	}
+	if p.requireDigit && !hasDigit {
+		return consts.ErrPasswordMissingDigit
+	}
+	if p.requireSymbol && !hasSymbol {
+		return consts.ErrPasswordMissingSymbol
+	}
+	if _, ok := p.banned[strings.ToLower(password)]; ok {
+		return consts.ErrPasswordBanned
+	}
+	return nil
+}
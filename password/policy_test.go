@@ -0,0 +1,101 @@
+package password
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "password")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bannedPath := filepath.Join(dir, "banned.txt")
+	err = ioutil.WriteFile(bannedPath, []byte("# common passwords\nPassword1\n\n  qwerty123  \n"), 0600)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc     string
+		config   *PolicyConfig
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for nil config", nil, true, consts.ErrNilPasswordPolicyConfig},
+		{"test for zero min length", &PolicyConfig{}, true, consts.ErrInvalidPasswordPolicy},
+		{"test for negative max length", &PolicyConfig{MinLength: 8, MaxLength: -1}, true, consts.ErrInvalidPasswordPolicy},
+		{"test for max under min length", &PolicyConfig{MinLength: 8, MaxLength: 7}, true, consts.ErrInvalidPasswordPolicy},
+		{"test for missing banned file", &PolicyConfig{MinLength: 8, BannedPath: filepath.Join(dir, "missing.txt")},
+			true, nil},
+		{"test for no max length", &PolicyConfig{MinLength: 8}, false, nil},
+		{"test for banned file", &PolicyConfig{MinLength: 8, MaxLength: 64, BannedPath: bannedPath}, false, nil},
+	}
+	for _, c := range cases {
+		p, err := NewPolicy(c.config)
+		if c.isExpErr {
+			if c.expErr != nil {
+				assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			} else {
+				assert.NotNil(t, err, c.desc)
+			}
+			assert.Nil(t, p, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, p, c.desc)
+		}
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "password")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	bannedPath := filepath.Join(dir, "banned.txt")
+	err = ioutil.WriteFile(bannedPath, []byte("# common passwords\nPassword1!\n\n  Qwerty123!  \n"), 0600)
+	assert.Nil(t, err)
+
+	strict, err := NewPolicy(&PolicyConfig{
+		MinLength:     8,
+		MaxLength:     16,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		BannedPath:    bannedPath,
+	})
+	assert.Nil(t, err)
+	cases := []struct {
+		desc     string
+		policy   *Policy
+		password string
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for empty password", strict, "", true, consts.ErrPasswordTooShort},
+		{"test for invalid utf-8", strict, "Passw0rd!\xff", true, consts.ErrInvalidPasswordEncoding},
+		{"test for short password", strict, "Pa1!", true, consts.ErrPasswordTooShort},
+		{"test for short password counted in characters", strict, "Pä1!äää", true, consts.ErrPasswordTooShort},
+		{"test for long password", strict, "Passw0rd!" + strings.Repeat("a", 8), true, consts.ErrPasswordTooLong},
+		{"test for missing uppercase", strict, "passw0rd!", true, consts.ErrPasswordMissingUpper},
+		{"test for missing lowercase", strict, "PASSW0RD!", true, consts.ErrPasswordMissingLower},
+		{"test for missing digit", strict, "Password!", true, consts.ErrPasswordMissingDigit},
+		{"test for missing symbol", strict, "Passw0rdd", true, consts.ErrPasswordMissingSymbol},
+		{"test for banned password", strict, "pASSWORD1!", true, consts.ErrPasswordBanned},
+		{"test for banned password with trimmed line", strict, "qWERTY123!", true, consts.ErrPasswordBanned},
+		{"test for valid password", strict, "Tr0ub4dor&3", false, nil},
+		{"test for valid unicode password", strict, "Ünïcödé 密码 1", false, nil},
+		{"test for default policy", DefaultPolicy, "correct horse battery staple", false, nil},
+		{"test for default policy max length", DefaultPolicy, strings.Repeat("a", 65), true, consts.ErrPasswordTooLong},
+	}
+	for _, c := range cases {
+		err := c.policy.Validate(c.password)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package totp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"strings"
+)
+
+const (
+	// DefaultRecoveryCodes is how many recovery codes users are usually given
+	DefaultRecoveryCodes = 10
+	// recoveryCodeByteSize bytes of randomness in a recovery code, 80 bits encode to 16 base32 characters
+	recoveryCodeByteSize = 10
+	// recoveryGroupSize characters between the dashes of a recovery code
+	recoveryGroupSize = 4
+)
+
+// recoveryEncoding is the encoding of recovery codes, lowercase to be easier to type
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes generates count single-use recovery codes, ie: "abcd-efgh-ijkl-mnop",
+// for users who lost their authenticator.
+// Returns the codes to show the user once, and their hashes to store instead of the codes.
+func GenerateRecoveryCodes(count int) ([]string, []string, error) {
+	if count < 1 {
+		return nil, nil, consts.ErrInvalidRecoveryCodeCount
+	}
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := auth.GenerateSecretKey(recoveryCodeByteSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		random, err := base64.URLEncoding.DecodeString(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded := recoveryEncoding.EncodeToString(random)
+		groups := make([]string, 0, len(encoded)/recoveryGroupSize)
+		for j := 0; j < len(encoded); j += recoveryGroupSize {
+			groups = append(groups, encoded[j:j+recoveryGroupSize])
+		}
+		codes = append(codes, strings.Join(groups, "-"))
+		hashes = append(hashes, hashRecoveryCode(encoded))
+	}
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode checks the code against the stored hashes of the user's recovery codes.
+// Codes are single-use: store the remaining hashes in place of the previous ones.
+// Returns the remaining hashes, or consts.ErrInvalidRecoveryCode if the code does not match any hash.
+func VerifyRecoveryCode(code string, hashes []string) ([]string, error) {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hashed := []byte(hashRecoveryCode(normalized))
+	matched := -1
+	// every hash is compared so that the time taken does not leak which code matched
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare(hashed, []byte(stored)) == 1 {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return nil, consts.ErrInvalidRecoveryCode
+	}
+	remaining := make([]string, 0, len(hashes)-1)
+	remaining = append(remaining, hashes[:matched]...)
+	return append(remaining, hashes[matched+1:]...), nil
+}
+
+// hashRecoveryCode hashes the normalized recovery code with SHA-256.
+// Recovery codes are random keys, so they do not need a slow password hash.
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(0)
+	assert.EqualError(t, err, consts.ErrInvalidRecoveryCodeCount.Error(), "test for no codes")
+	assert.Nil(t, codes, "test for no codes")
+	assert.Nil(t, hashes, "test for no codes")
+
+	codes, hashes, err = GenerateRecoveryCodes(DefaultRecoveryCodes)
+	assert.Nil(t, err)
+	assert.Len(t, codes, DefaultRecoveryCodes)
+	assert.Len(t, hashes, DefaultRecoveryCodes)
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		assert.Regexp(t, format, code, "test for code format")
+		assert.NotContains(t, hashes[i], strings.Replace(code, "-", "", -1), "test for hashed code")
+		assert.False(t, seen[code], "test for unique codes")
+		seen[code] = true
+	}
+}
+
+func TestVerifyRecoveryCode(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc         string
+		code         string
+		isExpErr     bool
+		expRemaining []string
+	}{
+		{"test for unknown code", "aaaa-bbbb-cccc-dddd", true, nil},
+		{"test for typed code", strings.ToUpper(strings.Replace(codes[1], "-", " ", -1)), false,
+			[]string{hashes[0], hashes[2]}},
+		{"test for used code", codes[1], true, nil},
+		{"test for last codes", codes[2], false, []string{hashes[0]}},
+	}
+	for _, c := range cases {
+		remaining, err := VerifyRecoveryCode(c.code, hashes)
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrInvalidRecoveryCode.Error(), c.desc)
+			assert.Nil(t, remaining, c.desc)
+			continue
+		}
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expRemaining, remaining, c.desc)
+		hashes = remaining
+	}
+}
@@ -0,0 +1,251 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algorithm is the HMAC hash function codes are computed with.
+type Algorithm int32
+
+const (
+	// NoAlg default zero value
+	NoAlg Algorithm = iota
+	// Sha1 is the RFC 6238 default, and the only algorithm every authenticator app supports
+	Sha1
+	// Sha256 for authenticators that support it
+	Sha256
+	// Sha512 for authenticators that support it
+	Sha512
+)
+
+const (
+	// SecretByteSize bytes of randomness in a secret, the RFC 4226 recommended length
+	SecretByteSize = 20
+	// maxSkew bounds the drift window, wider windows make codes easier to guess
+	maxSkew = 10
+)
+
+var (
+	// DefaultParams are the parameters every authenticator app supports:
+	// 6 digit SHA-1 codes every 30 seconds, accepting the previous and next code for clock drift.
+	DefaultParams = Params{
+		Algorithm: Sha1,
+		Digits:    6,
+		Period:    30 * time.Second,
+		Skew:      1,
+	}
+
+	// AlgorithmStringMap maps enum Algorithm to its otpauth:// name
+	AlgorithmStringMap = map[Algorithm]string{
+		Sha1:   "SHA1",
+		Sha256: "SHA256",
+		Sha512: "SHA512",
+	}
+
+	// secretEncoding is the encoding of secrets in provisioning URIs
+	secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Params are the parameters of the codes.
+// Skew is how many periods a code may be early or late, to tolerate clock drift between the user and the service.
+type Params struct {
+	Algorithm Algorithm
+	Digits    int
+	Period    time.Duration
+	Skew      int
+}
+
+// ReplayStore keeps the last time step a code was accepted at for every user,
+// so that a code cannot be used twice.
+type ReplayStore interface {
+	// Use records that the code of step was accepted for the key.
+	// Returns consts.ErrTOTPReplayed if a code of step or of a later step was already accepted.
+	Use(key string, step int64) error
+}
+
+// memoryReplayStore is an in memory ReplayStore.
+type memoryReplayStore struct {
+	locker sync.Mutex
+	steps  map[string]int64
+}
+
+// NewMemoryReplayStore makes an in memory ReplayStore.
+// Used codes are forgotten when the process exits.
+func NewMemoryReplayStore() ReplayStore {
+	return &memoryReplayStore{
+		steps: make(map[string]int64),
+	}
+}
+
+// Use implements ReplayStore.
+func (s *memoryReplayStore) Use(key string, step int64) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	if last, ok := s.steps[key]; ok && step <= last {
+		return consts.ErrTOTPReplayed
+	}
+	s.steps[key] = step
+	return nil
+}
+
+// Option configures an Authenticator.
+type Option func(*Authenticator)
+
+// WithClock makes the authenticator read the current time from the clock.
+func WithClock(clock auth.Clock) Option {
+	return func(a *Authenticator) {
+		a.clock = clock
+	}
+}
+
+// Authenticator generates and verifies RFC 6238 time-based one-time passwords.
+type Authenticator struct {
+	params Params
+	store  ReplayStore
+	clock  auth.Clock
+}
+
+// NewAuthenticator makes an authenticator with the params, recording accepted codes in the store.
+// Returns an error if the params are not valid or the store is nil.
+func NewAuthenticator(params Params, store ReplayStore, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{
+		params: params,
+		store:  store,
+		clock:  auth.SystemClock,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.clock == nil {
+		return nil, consts.ErrNilClock
+	}
+	if _, ok := AlgorithmStringMap[params.Algorithm]; !ok {
+		return nil, consts.ErrInvalidTOTPParams
+	}
+	if params.Digits < 6 || params.Digits > 8 || params.Period < time.Second ||
+		params.Period%time.Second != 0 || params.Skew < 0 || params.Skew > maxSkew {
+		return nil, consts.ErrInvalidTOTPParams
+	}
+	if store == nil {
+		return nil, consts.ErrNilReplayStore
+	}
+	return a, nil
+}
+
+// GenerateSecret generates a random secret to share with the user's authenticator app.
+// Returns the base32 encoded secret, or an error if the system's secure random number generator fails.
+func GenerateSecret() (string, error) {
+	key, err := auth.GenerateSecretKey(SecretByteSize)
+	if err != nil {
+		return "", err
+	}
+	random, err := base64.URLEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(random), nil
+}
+
+// ProvisioningURI makes the otpauth:// URI authenticator apps enroll the secret with, usually shown as a QR code.
+// The issuer names the service, and account the user, ie: the user's email.
+func (a *Authenticator) ProvisioningURI(secret string, issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", AlgorithmStringMap[a.params.Algorithm])
+	query.Set("digits", strconv.Itoa(a.params.Digits))
+	query.Set("period", strconv.Itoa(int(a.params.Period/time.Second)))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Code computes the code of the secret at a time.
+// Returns consts.ErrInvalidTOTPSecret if the secret is not base32 encoded.
+func (a *Authenticator) Code(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return a.code(key, a.step(at)), nil
+}
+
+// Verify checks the code of the user identified by key, ie: the user's UUID,
+// within the drift window around the current time.
+// A code is only accepted once, and accepting it rejects the codes of earlier time steps.
+// Returns consts.ErrInvalidTOTPCode if the code does not match, or consts.ErrTOTPReplayed if it was already used.
+func (a *Authenticator) Verify(key string, secret string, code string) error {
+	secretKey, err := decodeSecret(secret)
+	if err != nil {
+		return err
+	}
+	if len(code) != a.params.Digits {
+		return consts.ErrInvalidTOTPCode
+	}
+	now := a.step(a.clock.Now())
+	for step := now - int64(a.params.Skew); step <= now+int64(a.params.Skew); step++ {
+		if subtle.ConstantTimeCompare([]byte(a.code(secretKey, step)), []byte(code)) == 1 {
+			return a.store.Use(key, step)
+		}
+	}
+	return consts.ErrInvalidTOTPCode
+}
+
+// step is the time step of t.
+func (a *Authenticator) step(t time.Time) int64 {
+	return t.Unix() / int64(a.params.Period/time.Second)
+}
+
+// code computes the RFC 4226 code of the key for the counter.
+func (a *Authenticator) code(key []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+	mac := hmac.New(a.hash(), key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < a.params.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", a.params.Digits, value%modulo)
+}
+
+// hash returns the hash function of the algorithm.
+func (a *Authenticator) hash() func() hash.Hash {
+	switch a.params.Algorithm {
+	case Sha256:
+		return sha256.New
+	case Sha512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// decodeSecret decodes a base32 secret, ignoring case, spaces and padding as authenticator apps display them.
+func decodeSecret(secret string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := secretEncoding.DecodeString(strings.TrimRight(normalized, "="))
+	if err != nil || len(key) == 0 {
+		return nil, consts.ErrInvalidTOTPSecret
+	}
+	return key, nil
+}
@@ -0,0 +1,161 @@
+package totp
+
+import (
+	"encoding/base32"
+	"github.com/hwsc-org/hwsc-lib/auth"
+	"github.com/hwsc-org/hwsc-lib/consts"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	// rfc6238Secrets are the ASCII seeds of the RFC 6238 appendix B test vectors
+	rfc6238Secrets = map[Algorithm]string{
+		Sha1:   "12345678901234567890",
+		Sha256: "12345678901234567890123456789012",
+		Sha512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+)
+
+func encodeTestSecret(secret string) string {
+	return base32.StdEncoding.EncodeToString([]byte(secret))
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	cases := []struct {
+		desc     string
+		params   Params
+		store    ReplayStore
+		opts     []Option
+		isExpErr bool
+		expErr   error
+	}{
+		{"test for no algorithm", Params{Digits: 6, Period: time.Minute}, NewMemoryReplayStore(), nil,
+			true, consts.ErrInvalidTOTPParams},
+		{"test for short codes", Params{Algorithm: Sha1, Digits: 4, Period: time.Minute}, NewMemoryReplayStore(), nil,
+			true, consts.ErrInvalidTOTPParams},
+		{"test for sub-second period", Params{Algorithm: Sha1, Digits: 6, Period: time.Millisecond},
+			NewMemoryReplayStore(), nil, true, consts.ErrInvalidTOTPParams},
+		{"test for wide skew", Params{Algorithm: Sha1, Digits: 6, Period: time.Minute, Skew: maxSkew + 1},
+			NewMemoryReplayStore(), nil, true, consts.ErrInvalidTOTPParams},
+		{"test for nil store", DefaultParams, nil, nil, true, consts.ErrNilReplayStore},
+		{"test for nil clock", DefaultParams, NewMemoryReplayStore(), []Option{WithClock(nil)},
+			true, consts.ErrNilClock},
+		{"test for default params", DefaultParams, NewMemoryReplayStore(), nil, false, nil},
+	}
+	for _, c := range cases {
+		a, err := NewAuthenticator(c.params, c.store, c.opts...)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+			assert.Nil(t, a, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.NotNil(t, a, c.desc)
+		}
+	}
+}
+
+func TestAuthenticatorCode(t *testing.T) {
+	cases := []struct {
+		desc      string
+		algorithm Algorithm
+		unix      int64
+		expCode   string
+	}{
+		{"test for sha1 at 59", Sha1, 59, "94287082"},
+		{"test for sha256 at 59", Sha256, 59, "46119246"},
+		{"test for sha512 at 59", Sha512, 59, "90693936"},
+		{"test for sha1 at 1111111109", Sha1, 1111111109, "07081804"},
+		{"test for sha256 at 1111111111", Sha256, 1111111111, "67062674"},
+		{"test for sha512 at 1234567890", Sha512, 1234567890, "93441116"},
+		{"test for sha1 at 2000000000", Sha1, 2000000000, "69279037"},
+		{"test for sha512 at 20000000000", Sha512, 20000000000, "47863826"},
+	}
+	for _, c := range cases {
+		a, err := NewAuthenticator(Params{Algorithm: c.algorithm, Digits: 8, Period: 30 * time.Second},
+			NewMemoryReplayStore())
+		assert.Nil(t, err, c.desc)
+		code, err := a.Code(encodeTestSecret(rfc6238Secrets[c.algorithm]), time.Unix(c.unix, 0))
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expCode, code, c.desc)
+	}
+
+	a, err := NewAuthenticator(DefaultParams, NewMemoryReplayStore())
+	assert.Nil(t, err)
+	_, err = a.Code("not base32!", time.Unix(59, 0))
+	assert.EqualError(t, err, consts.ErrInvalidTOTPSecret.Error(), "test for invalid secret")
+	code, err := a.Code(strings.ToLower(encodeTestSecret(rfc6238Secrets[Sha1])), time.Unix(59, 0))
+	assert.Nil(t, err, "test for lowercase secret")
+	assert.Equal(t, "287082", code, "test for lowercase secret")
+}
+
+func TestAuthenticatorVerify(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	clock := auth.NewFakeClock(now)
+	a, err := NewAuthenticator(DefaultParams, NewMemoryReplayStore(), WithClock(clock))
+	assert.Nil(t, err)
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	codeAt := func(at time.Time) string {
+		code, err := a.Code(secret, at)
+		assert.Nil(t, err)
+		return code
+	}
+	const user = "01d1na5ekzr7p98hragv5fmvxa"
+
+	cases := []struct {
+		desc   string
+		key    string
+		code   string
+		expErr error
+	}{
+		{"test for wrong length", user, "12345", consts.ErrInvalidTOTPCode},
+		{"test for code outside the drift window", user, codeAt(now.Add(-time.Minute)), consts.ErrInvalidTOTPCode},
+		{"test for previous code within the drift window", user, codeAt(now.Add(-30 * time.Second)), nil},
+		{"test for current code", user, codeAt(now), nil},
+		{"test for replayed code", user, codeAt(now), consts.ErrTOTPReplayed},
+		{"test for earlier code after a later one", user, codeAt(now.Add(-30 * time.Second)), consts.ErrTOTPReplayed},
+		{"test for current code of another user", "01d3x3wm2nnrdfzp0tka2vw9dx", codeAt(now), nil},
+		{"test for next code within the drift window", user, codeAt(now.Add(30 * time.Second)), nil},
+	}
+	for _, c := range cases {
+		err := a.Verify(c.key, secret, c.code)
+		if c.expErr != nil {
+			assert.EqualError(t, err, c.expErr.Error(), c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+		}
+	}
+	assert.EqualError(t, a.Verify(user, "", "123456"), consts.ErrInvalidTOTPSecret.Error(), "test for empty secret")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	key, err := decodeSecret(secret)
+	assert.Nil(t, err)
+	assert.Len(t, key, SecretByteSize)
+	other, err := GenerateSecret()
+	assert.Nil(t, err)
+	assert.NotEqual(t, secret, other)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	a, err := NewAuthenticator(DefaultParams, NewMemoryReplayStore())
+	assert.Nil(t, err)
+	uri, err := url.Parse(a.ProvisioningURI("JBSWY3DPEHPK3PXP", "hwsc", "jane doe@hwsc.org"))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/hwsc:jane doe@hwsc.org", uri.Path)
+	assert.Equal(t, url.Values{
+		"secret":    {"JBSWY3DPEHPK3PXP"},
+		"issuer":    {"hwsc"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}, uri.Query())
+}
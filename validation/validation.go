@@ -3,9 +3,18 @@ package validation
 import (
 	"github.com/hwsc-org/hwsc-lib/consts"
 	"github.com/oklog/ulid"
+	"regexp"
 	"strings"
 )
 
+const (
+	maxServiceNameLength = 63
+)
+
+var (
+	serviceNameRegex = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+)
+
 // ValidateUserUUID ensures uuid is not a zero value and matches format set by ulid package
 // Returns error if zero value or invalid uuid (determined by ulid package)
 func ValidateUserUUID(uuid string) error {
@@ -27,3 +36,13 @@ func ValidateUserUUID(uuid string) error {
 
 	return nil
 }
+
+// ValidateServiceName ensures name is a lowercase service name of up to 63 characters,
+// made of letters, digits and single hyphens, starting with a letter, ie: "hwsc-document-svc".
+// Returns error if zero value or invalid name
+func ValidateServiceName(name string) error {
+	if len(name) > maxServiceNameLength || !serviceNameRegex.MatchString(name) {
+		return consts.ErrInvalidServiceName
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package validation
 import (
 	"github.com/hwsc-org/hwsc-lib/consts"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -27,3 +28,31 @@ func TestValidateUserUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateServiceName(t *testing.T) {
+	cases := []struct {
+		name     string
+		isExpErr bool
+	}{
+		{"hwsc-document-svc", false},
+		{"svc2", false},
+		{"", true},
+		{"Hwsc-document-svc", true},
+		{"hwsc_document_svc", true},
+		{"hwsc--document", true},
+		{"-hwsc", true},
+		{"hwsc-", true},
+		{"2hwsc", true},
+		{strings.Repeat("a", 64), true},
+	}
+
+	for _, c := range cases {
+		err := ValidateServiceName(c.name)
+
+		if c.isExpErr {
+			assert.EqualError(t, err, consts.ErrInvalidServiceName.Error(), c.name)
+		} else {
+			assert.Nil(t, err, c.name)
+		}
+	}
+}